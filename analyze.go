@@ -0,0 +1,397 @@
+package lisp
+
+/*
+ Syntactic analysis: lexical addressing
+
+ After a form has been expanded, analyze converts it into a tree of nodes,
+ which is what the machine evaluates. Every local variable is resolved, once,
+ to its lexical address: how many frames out it lives (its depth), and where
+ in that frame (its index). The frames of a running program are activations,
+ whose values are held in a slice, so a variable reference costs a few pointer
+ hops rather than a map lookup per frame. Every global variable is resolved to
+ the cell that holds its value.
+
+ This is the pretreatment of chapter 6 of Lisp in Small Pieces.
+*/
+
+// A scope is the analyzer's picture of an activation: the names of its
+// variables, in order. The parameters come first, followed by the variables
+// defined in the body.
+type scope struct {
+	names []symbol
+	outer *scope
+}
+
+// lookup returns the lexical address of the variable name in s. The third
+// result is false if name is not a local variable.
+func (s *scope) lookup(name symbol) (depth, index int, ok bool) {
+	for ; s != nil; s, depth = s.outer, depth+1 {
+		for i, n := range s.names {
+			if n == name {
+				return depth, i, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// An activation holds the values of the variables of one procedure call.
+type activation struct {
+	values []scmer
+	outer  *activation
+	proc   scmer     // the procedure called, for backtraces
+	site   *location // where it was called from, if known
+}
+
+// A global is a top-level variable. Its value is nil until it is defined.
+type global struct {
+	name  symbol
+	value scmer
+}
+
+// globals holds every global variable that has been defined or referred to.
+var globals = map[symbol]*global{}
+
+// globalVariable returns the global variable called name, creating it (as yet
+// undefined) if need be.
+func globalVariable(name symbol) *global {
+	g, ok := globals[name]
+	if !ok {
+		g = &global{name: name}
+		globals[name] = g
+	}
+	return g
+}
+
+// A node is an analyzed expression. Its step method begins its evaluation in
+// m.en, source returns the expression it was analyzed from, for tracing, and
+// where returns its location, if known.
+type node interface {
+	step(m *machine)
+	source() scmer
+	where() *location
+}
+
+// origin records the expression a node was analyzed from, and the location
+// of the innermost list it is within.
+type origin struct {
+	expr scmer
+	loc  *location
+}
+
+func (o origin) source() scmer    { return o.expr }
+func (o origin) where() *location { return o.loc }
+
+// at returns the origin of a node analyzed from x.
+func at(x scmer) origin {
+	return origin{x, translating}
+}
+
+// A reference is a node that refers to a variable.
+type reference interface {
+	node
+	get(en *activation) scmer
+	set(en *activation, value scmer)
+}
+
+type constant struct {
+	origin
+	value scmer
+}
+
+type localRef struct {
+	origin
+	name         symbol
+	depth, index int
+}
+
+func (r *localRef) get(en *activation) scmer {
+	for i := r.depth; i > 0; i-- {
+		en = en.outer
+	}
+	v := en.values[r.index]
+	if v == nil {
+		Fail("variable used before it is initialized: %s", r.name)
+	}
+	return v
+}
+
+func (r *localRef) set(en *activation, value scmer) {
+	for i := r.depth; i > 0; i-- {
+		en = en.outer
+	}
+	en.values[r.index] = value
+}
+
+type globalRef struct {
+	origin
+	g *global
+}
+
+func (r *globalRef) get(en *activation) scmer {
+	return r.g.Get()
+}
+
+func (r *globalRef) set(en *activation, value scmer) {
+	r.g.Set(value)
+}
+
+type ifNode struct {
+	origin
+	test, consequent, alternative node
+}
+
+// An assignment is a set! of any variable, or a define of a local one.
+type assignment struct {
+	origin
+	target reference
+	value  node
+	result scmer
+}
+
+// A definition is a define of a global variable.
+type definition struct {
+	origin
+	g      *global
+	value  node
+	result scmer
+}
+
+type lambda struct {
+	origin
+	name    symbol   // the variable it is defined as, if any, for errors
+	params  scmer    // as expanded
+	written scmer    // the lambda expression as written, for printing
+	hidden  bool     // true if an expansion made it, and it has no source
+	arity   int      // the number of required parameters
+	rest    bool     // true if there is a rest parameter, after the required ones
+	size    int      // the number of variables in the activation
+	names   []symbol // the names of those variables
+	code    node     // the analyzed body
+}
+
+type sequence struct {
+	origin
+	body []node
+}
+
+type application struct {
+	origin
+	parts []node // the operator, followed by the operands
+	apply bool   // true for (apply f args)
+}
+
+// analyze returns the node for the expanded expression x, to be evaluated in
+// an activation described by s (nil at top level).
+func analyze(x scmer, s *scope) node {
+	saved := translating
+	if loc := locationOf(x); loc != nil {
+		translating = loc
+	}
+	n := analyzeForm(x, s)
+	translating = saved
+	return n
+}
+
+// analyzeForm does the work of analyze.
+func analyzeForm(x scmer, s *scope) node {
+	switch e := x.(type) {
+	case symbol:
+		return analyzeVariable(x, e, s)
+	case emptyList:
+		Fail("eval: missing procedure expression: %s", e)
+	case *pair:
+		switch car, _ := e.car.(symbol); car {
+		case "quote", "if", "set!", "define", "lambda", "apply", "begin", "#%global":
+			return analyzeSpecial(car, e, s)
+		}
+		parts, ok := listToSlice(e)
+		if !ok {
+			Fail("eval: improper list used as expression: %s", e)
+		}
+		return &application{at(x), analyzeAll(parts, s), false}
+	}
+	// Everything else evaluates to itself, including the primitives and
+	// controls found in the expansions of quasiquote and guard.
+	return &constant{at(x), x}
+}
+
+// analyzeVariable returns a reference to the variable name, which appears in
+// the expression x.
+func analyzeVariable(x scmer, name symbol, s *scope) reference {
+	if depth, index, ok := s.lookup(name); ok {
+		return &localRef{at(x), name, depth, index}
+	}
+	return &globalRef{at(x), globalVariable(name)}
+}
+
+// analyzeAll analyzes each of the expressions xs.
+func analyzeAll(xs []scmer, s *scope) []node {
+	nodes := make([]node, len(xs))
+	for i, x := range xs {
+		nodes[i] = analyze(x, s)
+	}
+	return nodes
+}
+
+// analyzeSpecial analyzes a core special form.
+func analyzeSpecial(keyword symbol, e *pair, s *scope) node {
+	form, ok := listToSlice(e)
+	if !ok {
+		Fail("eval: improper list used as expression: %s", e)
+	}
+	switch keyword {
+	case "quote":
+		return &constant{at(e), form[1]}
+	case "if":
+		// An if without an alternative has an unspecified value when the
+		// test fails.
+		n := &ifNode{at(e), analyze(form[1], s), analyze(form[2], s), nil}
+		if len(form) > 3 {
+			n.alternative = analyze(form[3], s)
+		} else {
+			n.alternative = &constant{at(e), symbol("#%void")}
+		}
+		return n
+	case "set!":
+		target, ok := analyze(form[1], s).(reference)
+		if !ok {
+			Fail("set!: not a variable: %s", form[1])
+		}
+		return &assignment{at(e), target, analyze(form[2], s), symbol("#%set!")}
+	case "define":
+		return analyzeDefine(e, form, s)
+	case "lambda":
+		if len(form) < 3 {
+			Fail("lambda: missing body: %s", e)
+		}
+		return analyzeLambda(e, form[1], form[2:], s)
+	case "apply":
+		return &application{at(e), analyzeAll(form[1:], s), true}
+	case "#%global":
+		// a reference to a global variable whose name is shadowed locally
+		return &globalRef{at(e), globalVariable(form[1].(symbol))}
+	}
+	// begin
+	if len(form) == 1 {
+		return &constant{at(e), nil}
+	}
+	return &sequence{at(e), analyzeAll(form[1:], s)}
+}
+
+// analyzeDefine analyzes the (define ...) form x, given also as a slice.
+func analyzeDefine(x scmer, form []scmer, s *scope) node {
+	if len(form) < 3 {
+		Fail("define requires at least 3 arguments: %s", x)
+	}
+	var name symbol
+	var value node
+	switch target := form[1].(type) {
+	case symbol:
+		if len(form) != 3 {
+			Fail("define requires exactly 3 arguments: %s", x)
+		}
+		name, value = target, analyze(form[2], s)
+	case *pair:
+		sym, ok := target.car.(symbol)
+		if !ok {
+			Fail("define has illegal structure")
+		}
+		// (define (name . params) body ...) defines name as
+		// (lambda params body ...)
+		lambda := &pair{symbol("lambda"), &pair{target.cdr, makeList(form[2:]...)}}
+		if p, ok := x.(*pair); ok && sources[p] != nil {
+			sources[lambda] = sources[p]
+			delete(sources, p)
+		}
+		name, value = sym, analyzeLambda(lambda, target.cdr, form[2:], s)
+	default:
+		Fail("define: 1st arg must be symbol or func declaration: %s", x)
+	}
+	if l, ok := value.(*lambda); ok {
+		l.name = name
+	}
+	result := makeList(symbol("#%undef"), symbol("define"), name)
+	if s == nil {
+		return &definition{at(x), globalVariable(name), value, result}
+	}
+	return &assignment{at(x), analyzeVariable(name, name, s), value, result}
+}
+
+// analyzeLambda analyzes the lambda expression x, whose parameters and body
+// forms are given. The body is evaluated as a sequence, in an activation that
+// holds the variables it defines, as well as the parameters.
+func analyzeLambda(x scmer, params scmer, body []scmer, s *scope) *lambda {
+	inner := &scope{nil, s}
+	l := &lambda{origin: at(x), params: params, written: sources[x.(*pair)]}
+	if l.written != nil {
+		delete(sources, x.(*pair))
+	} else {
+		l.written = &pair{symbol("lambda"), &pair{params, makeList(body...)}}
+		l.hidden = true
+	}
+	for {
+		if p, ok := params.(*pair); ok {
+			inner.names = append(inner.names, p.car.(symbol))
+			l.arity++
+			params = p.cdr
+		} else if sym, ok := params.(symbol); ok {
+			inner.names = append(inner.names, sym)
+			l.rest = true
+			break
+		} else {
+			break
+		}
+	}
+	for _, x := range body {
+		inner.names = definedNames(x, inner.names)
+	}
+	l.size, l.names = len(inner.names), inner.names
+	if len(body) == 1 {
+		l.code = analyze(body[0], inner)
+	} else {
+		l.code = &sequence{at(x), analyzeAll(body, inner)}
+	}
+	return l
+}
+
+// definedNames adds to names the name of each variable that is defined in
+// the expanded expression x, other than within a nested lambda.
+func definedNames(x scmer, names []symbol) []symbol {
+	p, ok := x.(*pair)
+	if !ok {
+		return names
+	}
+	switch p.car {
+	case symbol("quote"), symbol("lambda"), symbol("#%global"):
+		return names
+	case symbol("define"):
+		def, ok := p.cdr.(*pair)
+		if !ok {
+			return names
+		}
+		if t, ok := def.car.(*pair); ok {
+			// The body belongs to the procedure being defined.
+			return addName(names, t.car)
+		}
+		names = addName(names, def.car)
+	}
+	for ; ok; p, ok = p.cdr.(*pair) {
+		names = definedNames(p.car, names)
+	}
+	return names
+}
+
+// addName appends the variable name x to names, unless it is there already.
+func addName(names []symbol, x scmer) []symbol {
+	name, ok := x.(symbol)
+	if !ok {
+		return names
+	}
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
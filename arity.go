@@ -0,0 +1,113 @@
+package lisp
+
+import "strconv"
+
+/*
+ Arity
+
+ Every procedure has an arity: the number of arguments it accepts, from a
+ minimum to a maximum, or any number from the minimum up. A lambda
+ expression's arity follows from its parameters, and a primitive's is
+ declared along with it, in the table that defines it. It is checked
+ whenever the procedure is applied, so that a call with the wrong number of
+ arguments fails with an error that names the procedure, what it expected
+ and what it got, rather than with whatever the code does with a missing
+ argument, or by quietly ignoring an extra one.
+*/
+
+type arity struct {
+	min, max int
+}
+
+// variadic is the max of the arity of a procedure that accepts any number of
+// arguments from its min up.
+const variadic = -1
+
+// lambdaArity returns the arity of a lambda expression with the given number
+// of required parameters, and a rest parameter if rest is true.
+func lambdaArity(required int, rest bool) arity {
+	if rest {
+		return arity{required, variadic}
+	}
+	return arity{required, required}
+}
+
+// String returns a as a phrase, such as "at least 1 argument".
+func (a arity) String() string {
+	count := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return strconv.Itoa(n) + " arguments"
+	}
+	switch {
+	case a.max == variadic:
+		return "at least " + count(a.min)
+	case a.min == a.max:
+		return count(a.min)
+	}
+	return strconv.Itoa(a.min) + " to " + count(a.max)
+}
+
+// accepts reports whether a procedure of arity a accepts n arguments.
+func (a arity) accepts(n int) bool {
+	return n >= a.min && (a.max == variadic || n <= a.max)
+}
+
+// check fails on behalf of the named procedure unless it accepts args.
+func (a arity) check(who string, args []scmer) {
+	if !a.accepts(len(args)) {
+		Fail("%s: expected %s, got %d: %s", who, a, len(args), makeList(args...))
+	}
+}
+
+// procName returns the name of a procedure for its errors: the variable it
+// was defined as, if any, or else its lambda expression, abbreviated.
+func procName(name symbol, params scmer) string {
+	if name != "" {
+		return sourceName(name).String()
+	}
+	return "(lambda " + sourceParams(params).String() + " ...)"
+}
+
+// arityOf returns the arity of the procedure x. The second result is false
+// if x is not a procedure.
+func arityOf(x scmer) (arity, bool) {
+	switch p := x.(type) {
+	case *primitive:
+		return p.arity, true
+	case *control:
+		return p.arity, true
+	case *proc:
+		return lambdaArity(p.lambda.arity, p.lambda.rest), true
+	case *closure:
+		return lambdaArity(p.block.arity, p.block.rest), true
+	case *native:
+		return p.arity, true
+	case *caseLambda:
+		return p.arity, true
+	case *continuation:
+		return arity{0, variadic}, true
+	case *parameter:
+		return arity{0, 0}, true
+	}
+	return arity{}, false
+}
+
+var arityPrimitives = map[string]primitiveDef{
+	// (procedure-arity f) returns the number of arguments f takes, if that
+	// is fixed, or else a pair of the least and the most, where the most is
+	// #f if there is no limit.
+	"procedure-arity": {arity{1, 1}, func(a ...scmer) scmer {
+		n, ok := arityOf(a[0])
+		switch {
+		case !ok:
+			Fail("procedure-arity: expected a procedure, got %s", a[0])
+		case n.max == variadic:
+			return &pair{fixnum(n.min), boolean(false)}
+		case n.min != n.max:
+			return &pair{fixnum(n.min), fixnum(n.max)}
+		}
+		return fixnum(n.min)
+	}},
+}
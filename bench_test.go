@@ -0,0 +1,43 @@
+package lisp
+
+import "testing"
+
+// The benchmarks are the classic fib and tak, which spend nearly all their
+// time looking up variables and applying procedures. The VM versions run them
+// as bytecode.
+
+func BenchmarkFib(b *testing.B) {
+	evalString(b, `(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))`)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := evalString(b, "(fib 20)"); got != fixnum(6765) {
+			b.Fatalf("wanted 6765, got %s", got)
+		}
+	}
+}
+
+func BenchmarkTak(b *testing.B) {
+	evalString(b, `
+		(define (tak x y z)
+		  (if (< y x)
+		      (tak (tak (- x 1) y z) (tak (- y 1) z x) (tak (- z 1) x y))
+		      z))`)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := evalString(b, "(tak 18 12 6)"); got != fixnum(7) {
+			b.Fatalf("wanted 7, got %s", got)
+		}
+	}
+}
+
+func BenchmarkFibVM(b *testing.B) {
+	UseVM = true
+	defer func() { UseVM = false }()
+	BenchmarkFib(b)
+}
+
+func BenchmarkTakVM(b *testing.B) {
+	UseVM = true
+	defer func() { UseVM = false }()
+	BenchmarkTak(b)
+}
@@ -0,0 +1,662 @@
+package lisp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+/*
+ Bytecode files
+
+ A bytecode file holds the compiled code of a sequence of top-level
+ expressions, so that a library can be loaded without reading, expanding and
+ analyzing it again. Macros are expanded when the file is compiled; the global
+ macros that an expression defines are saved with its code, and defined again
+ when it is loaded, so that the code that loads a library can use them.
+
+ The file starts with bytecodeMagic, followed by a block for each expression.
+ A block is its lambda fields, its constants, the names of its globals, its
+ code, its lines, the macros it defines and its nested blocks. A line is a
+ pc, followed by the file, line and column of its location. A macro is its
+ name, and either 0, if the name was defined as a variable instead, or 1, its
+ ellipsis, its literals and its rules. A value that is circular has labels,
+ as write prints it with. Numbers are unsigned varints, unless noted, and
+ strings are a length followed by bytes.
+*/
+
+const bytecodeMagic = "\x7fLiSP bytecode 8\n"
+
+// The tags that begin each value in a bytecode file.
+const (
+	tagNil        byte = iota // the value of (begin)
+	tagEmpty                  // ()
+	tagFalse                  // #f
+	tagTrue                   // #t
+	tagFixnum                 // a signed varint
+	tagBignum                 // a string, in decimal
+	tagRatnum                 // a string, as n/d
+	tagFlonum                 // 8 bytes, the IEEE 754 bits, little-endian
+	tagCompnum                // two values, the real and imaginary parts
+	tagStr                    // a string, the contents
+	tagChar                   // a number, the rune
+	tagSymbol                 // a string
+	tagPair                   // two values, the car and the cdr
+	tagVector                 // a number, the length, then the items
+	tagBytevector             // a string, the bytes
+	tagEmbedded               // a number, the index in embedded
+	tagAlias                  // a number, the index among the macro's aliases, then the name if it is new
+	tagLabel                  // a number, the label, then the pair or vector it labels
+	tagRef                    // a number, the label of a pair or vector that encloses this one
+)
+
+// embedded lists the procedures that expansions contain directly, rather than
+// by name. New ones must be added at the end.
+var embedded = []scmer{
+	applyControl, qqCons, qqAppend,
+	guardCallCC, guardWithExceptionHandler, guardRaiseContinuable,
+	qqVector,
+	caseMemv, caseLambdaMake,
+	valuesCallWithValues, parameterizeControl,
+	delayPromise, delayForcePromise,
+	makeRecordType, recordConstructor, recordPredicate, recordAccessor, recordModifier,
+}
+
+// IsBytecode reports whether r holds a bytecode file.
+func IsBytecode(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(bytecodeMagic))
+	return string(magic) == bytecodeMagic
+}
+
+// CompileFiles compiles the named source files into the bytecode file out.
+func CompileFiles(names []string, out string) error {
+	var blocks []*codeBlock
+	for _, name := range names {
+		compiled, err := compileFile(name)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, compiled...)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := writeBytecode(f, blocks); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	return f.Close()
+}
+
+// compileFile compiles each expression in the named source file.
+func compileFile(name string) ([]*codeBlock, error) {
+	var blocks []*codeBlock
+	err := readFile(name, func(datum scmer) error {
+		b, err := compileTopLevel(datum)
+		blocks = append(blocks, b)
+		return err
+	})
+	return blocks, err
+}
+
+// compileTopLevel compiles the top-level expression datum.
+func compileTopLevel(datum scmer) (*codeBlock, error) {
+	n, err := analyzeTopLevel(datum)
+	if err != nil {
+		return nil, err
+	}
+	b := compile(n)
+	b.macros = definedMacros
+	return b, nil
+}
+
+// analyzeFile expands and analyzes each expression in the named source file.
+func analyzeFile(name string) ([]node, error) {
+	var nodes []node
+	err := readFile(name, func(datum scmer) error {
+		n, err := analyzeTopLevel(datum)
+		nodes = append(nodes, n)
+		return err
+	})
+	return nodes, err
+}
+
+// readFile calls each for each expression in the named source file, until it
+// returns an error.
+func readFile(name string, each func(datum scmer) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := scan.NewScanner(name, bufio.NewReader(f))
+	for {
+		datum, err := read(scanner)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := each(datum); err != nil {
+			return err
+		}
+	}
+}
+
+// analyzeTopLevel expands and analyzes the top-level expression datum.
+func analyzeTopLevel(datum scmer) (n node, err error) {
+	defer recoverError(&err)
+	translating, definedMacros = nil, nil
+	defer forget(datum)
+	defer locateTranslationError()
+	return analyze(expand(datum, nil), nil), nil
+}
+
+// RunBytecode executes the code in a bytecode file, printing the value of
+// each expression, as Repl does. It returns false if there was an error.
+func RunBytecode(r *bufio.Reader) bool {
+	blocks, err := readBytecode(r)
+	for _, b := range blocks {
+		var value scmer
+		if value, err = executeTopLevel(b); err != nil {
+			break
+		}
+		fmt.Println(value)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	return err == nil
+}
+
+// executeTopLevel defines the macros that the code block b defines, and then
+// executes it.
+func executeTopLevel(b *codeBlock) (value scmer, err error) {
+	defer recoverError(&err)
+	for _, d := range b.macros {
+		if d.macro != nil {
+			globalMacros[d.name] = d.macro
+		} else {
+			delete(globalMacros, d.name)
+		}
+	}
+	winders, handlers, currentOutput = nil, nil, standardOutput
+	return executeBlock(b), nil
+}
+
+// writeBytecode writes a bytecode file holding blocks to w.
+func writeBytecode(w io.Writer, blocks []*codeBlock) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.w.WriteString(bytecodeMagic)
+	for _, b := range blocks {
+		e.block(b)
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// readBytecode reads the blocks in a bytecode file from r.
+func readBytecode(r *bufio.Reader) ([]*codeBlock, error) {
+	magic := make([]byte, len(bytecodeMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != bytecodeMagic {
+		return nil, errors.New("not a bytecode file")
+	}
+	d := &decoder{r: r}
+	var blocks []*codeBlock
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return blocks, nil
+		}
+		b := d.block(nil)
+		if d.err != nil {
+			return nil, d.err
+		}
+		blocks = append(blocks, b)
+	}
+}
+
+type encoder struct {
+	w       *bufio.Writer
+	err     error
+	aliases map[*alias]int // the aliases of the macro being written, numbered
+	labels  map[scmer]int  // the labels of the value being written, as a printer's
+	count   int            // the number of labels written so far
+}
+
+func (e *encoder) number(x int) {
+	e.w.Write(binary.AppendUvarint(nil, uint64(x)))
+}
+
+func (e *encoder) string(s string) {
+	e.number(len(s))
+	e.w.WriteString(s)
+}
+
+func (e *encoder) block(b *codeBlock) {
+	e.number(b.arity)
+	var flags byte
+	if b.rest {
+		flags |= 1
+	}
+	if b.hidden {
+		flags |= 2
+	}
+	e.w.WriteByte(flags)
+	e.number(b.size)
+	e.number(b.maxStack)
+	e.string(string(b.name))
+	e.value(b.params)
+	e.value(b.written)
+	e.number(len(b.names))
+	for _, name := range b.names {
+		e.string(string(name))
+	}
+	e.number(len(b.constants))
+	for _, x := range b.constants {
+		e.value(x)
+	}
+	e.number(len(b.globals))
+	for _, g := range b.globals {
+		e.string(string(g.name))
+	}
+	e.string(string(b.code))
+	e.number(len(b.lines))
+	for _, l := range b.lines {
+		e.number(l.pc)
+		e.string(l.loc.file)
+		e.number(l.loc.line)
+		e.number(l.loc.col)
+	}
+	e.number(len(b.macros))
+	for _, d := range b.macros {
+		e.string(string(d.name))
+		if d.macro == nil {
+			e.w.WriteByte(0)
+		} else {
+			e.w.WriteByte(1)
+			e.macro(d.name, d.macro)
+		}
+	}
+	e.number(len(b.blocks))
+	for _, inner := range b.blocks {
+		e.block(inner)
+	}
+}
+
+// macro writes m, the global macro name. Only a macro defined in the global
+// environment can be saved, since that is the only one it can be loaded into.
+func (e *encoder) macro(name symbol, m *macro) {
+	if m.env != nil && e.err == nil {
+		e.err = fmt.Errorf("bytecode: cannot save macro %s, which is defined within let-syntax or letrec-syntax", name)
+	}
+	e.aliases = map[*alias]int{}
+	defer func() { e.aliases = nil }()
+	e.value(m.ellipsis)
+	e.number(len(m.literals))
+	for _, l := range m.literals {
+		e.value(l)
+	}
+	e.number(len(m.rules))
+	for _, r := range m.rules {
+		e.value(r[0])
+		e.value(r[1])
+	}
+}
+
+// value writes x, which may be circular. The pairs and vectors that are
+// reached again from within themselves are labeled, as write labels them, so
+// that the value can be read back in finite time.
+func (e *encoder) value(x scmer) {
+	e.labels, e.count = nil, 0
+	if isNode(x) {
+		p := &printer{labels: map[scmer]int{}}
+		p.mark(x, false)
+		e.labels = p.labels
+	}
+	e.datum(x)
+}
+
+// datum writes x, which is within the value being written.
+func (e *encoder) datum(x scmer) {
+	for {
+		if n, ok := e.labels[x]; ok && n >= 0 {
+			e.w.WriteByte(tagRef)
+			e.number(n)
+			return
+		} else if ok {
+			e.labels[x] = e.count
+			e.w.WriteByte(tagLabel)
+			e.number(e.count)
+			e.count++
+		}
+		p, ok := x.(*pair)
+		if !ok {
+			break
+		}
+		e.w.WriteByte(tagPair)
+		e.datum(p.car)
+		x = p.cdr
+	}
+	switch x := x.(type) {
+	case nil:
+		e.w.WriteByte(tagNil)
+	case emptyList:
+		e.w.WriteByte(tagEmpty)
+	case boolean:
+		if x {
+			e.w.WriteByte(tagTrue)
+		} else {
+			e.w.WriteByte(tagFalse)
+		}
+	case fixnum:
+		e.w.WriteByte(tagFixnum)
+		e.w.Write(binary.AppendVarint(nil, int64(x)))
+	case *bignum:
+		e.w.WriteByte(tagBignum)
+		e.string(x.String())
+	case *ratnum:
+		e.w.WriteByte(tagRatnum)
+		e.string(x.String())
+	case flonum:
+		e.w.WriteByte(tagFlonum)
+		e.w.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(float64(x))))
+	case *compnum:
+		e.w.WriteByte(tagCompnum)
+		e.datum(x.re)
+		e.datum(x.im)
+	case *str:
+		e.w.WriteByte(tagStr)
+		e.string(x.text())
+	case char:
+		e.w.WriteByte(tagChar)
+		e.number(int(x))
+	case symbol:
+		e.w.WriteByte(tagSymbol)
+		e.string(string(x))
+	case *vector:
+		e.w.WriteByte(tagVector)
+		e.number(len(x.items))
+		for _, item := range x.items {
+			e.datum(item)
+		}
+	case *bytevector:
+		e.w.WriteByte(tagBytevector)
+		e.string(string(x.bytes))
+	case *alias:
+		// An alias is the same identifier only where it is the same alias.
+		e.w.WriteByte(tagAlias)
+		if i, ok := e.aliases[x]; ok {
+			e.number(i)
+			return
+		}
+		if (e.aliases == nil || x.env != nil) && e.err == nil {
+			e.err = fmt.Errorf("bytecode: cannot save identifier %s, renamed within let-syntax or letrec-syntax", x)
+			return
+		}
+		e.aliases[x] = len(e.aliases)
+		e.number(e.aliases[x])
+		e.datum(x.name)
+	default:
+		for i, y := range embedded {
+			if x == y {
+				e.w.WriteByte(tagEmbedded)
+				e.number(i)
+				return
+			}
+		}
+		if e.err == nil {
+			e.err = fmt.Errorf("bytecode: cannot save a constant of type %T: %s", x, x)
+		}
+	}
+}
+
+type decoder struct {
+	r       *bufio.Reader
+	err     error
+	aliases []*alias // the aliases of the macro being read, in order
+	labels  []scmer  // the labeled nodes of the value being read, in order
+	pending bool     // whether the last label read awaits its node
+}
+
+// fail records the first error found.
+func (d *decoder) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) byte() byte {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+	return c
+}
+
+func (d *decoder) number() int {
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return int(x)
+}
+
+func (d *decoder) string() string {
+	n := d.number()
+	if d.err != nil {
+		return ""
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(d.r, s); err != nil {
+		d.fail(err)
+	}
+	return string(s)
+}
+
+// block reads a block that is within the lambda expression outer.
+func (d *decoder) block(outer *codeBlock) *codeBlock {
+	b := &codeBlock{outer: outer}
+	b.arity = d.number()
+	flags := d.byte()
+	b.rest, b.hidden = flags&1 != 0, flags&2 != 0
+	b.size = d.number()
+	b.maxStack = d.number()
+	b.name = symbol(d.string())
+	b.params = d.value()
+	b.written = d.value()
+	b.names = make([]symbol, d.count())
+	for i := range b.names {
+		b.names[i] = symbol(d.string())
+	}
+	b.constants = make([]scmer, d.count())
+	for i := range b.constants {
+		b.constants[i] = d.value()
+	}
+	b.globals = make([]*global, d.count())
+	for i := range b.globals {
+		b.globals[i] = globalVariable(symbol(d.string()))
+	}
+	b.code = []byte(d.string())
+	b.lines = make([]line, d.count())
+	for i := range b.lines {
+		b.lines[i].pc = d.number()
+		b.lines[i].loc = &location{file: d.string(), line: d.number(), col: d.number()}
+	}
+	b.macros = make([]macroDefinition, d.count())
+	for i := range b.macros {
+		b.macros[i].name = symbol(d.string())
+		if d.byte() != 0 {
+			b.macros[i].macro = d.macro()
+		}
+	}
+	b.blocks = make([]*codeBlock, d.count())
+	// A lambda at top level has no activation to be within.
+	if b.params == nil {
+		outer = nil
+	} else {
+		outer = b
+	}
+	for i := range b.blocks {
+		b.blocks[i] = d.block(outer)
+	}
+	return b
+}
+
+// count reads the length of a table, which is 0 once there has been an error.
+func (d *decoder) count() int {
+	if n := d.number(); d.err == nil {
+		return n
+	}
+	return 0
+}
+
+// macro reads a global macro.
+func (d *decoder) macro() *macro {
+	d.aliases = nil
+	m := &macro{ellipsis: d.value()}
+	m.literals = make([]scmer, d.count())
+	for i := range m.literals {
+		m.literals[i] = d.value()
+	}
+	m.rules = make([][2]scmer, d.count())
+	for i := range m.rules {
+		m.rules[i] = [2]scmer{d.value(), d.value()}
+	}
+	return m
+}
+
+// value reads a value, which may be circular.
+func (d *decoder) value() scmer {
+	d.labels, d.pending = nil, false
+	return d.datum()
+}
+
+// datum reads a part of the value being read. Each pair is made before its car
+// and cdr are read, so that they can refer to it by its label.
+func (d *decoder) datum() scmer {
+	var x scmer
+	for next := &x; d.err == nil; {
+		tag := d.byte()
+		if tag == tagLabel {
+			if d.number() != len(d.labels) {
+				d.fail(errors.New("bytecode: labels out of order"))
+				return nil
+			}
+			d.labels, d.pending = append(d.labels, nil), true
+			tag = d.byte()
+		}
+		if tag != tagPair {
+			*next = d.atom(tag)
+			d.label(*next)
+			break
+		}
+		p := &pair{}
+		d.label(p)
+		*next = p
+		p.car = d.datum()
+		next = &p.cdr
+	}
+	if d.err != nil {
+		return nil
+	}
+	return x
+}
+
+// label makes x the node of the label just read, if it awaits one.
+func (d *decoder) label(x scmer) {
+	if d.pending {
+		d.labels[len(d.labels)-1], d.pending = x, false
+	}
+}
+
+// atom reads a value, other than a pair, that begins with tag.
+func (d *decoder) atom(tag byte) scmer {
+	switch tag {
+	case tagNil:
+		return nil
+	case tagEmpty:
+		return empty
+	case tagFalse:
+		return boolean(false)
+	case tagTrue:
+		return boolean(true)
+	case tagFixnum:
+		x, err := binary.ReadVarint(d.r)
+		if err != nil {
+			d.fail(err)
+		}
+		return fixnum(x)
+	case tagBignum:
+		x, ok := new(big.Int).SetString(d.string(), 10)
+		if !ok {
+			d.fail(errors.New("bytecode: bad bignum"))
+			return nil
+		}
+		return (*bignum)(x)
+	case tagRatnum:
+		x, ok := new(big.Rat).SetString(d.string())
+		if !ok {
+			d.fail(errors.New("bytecode: bad ratnum"))
+			return nil
+		}
+		return (*ratnum)(x)
+	case tagFlonum:
+		var bits [8]byte
+		if _, err := io.ReadFull(d.r, bits[:]); err != nil {
+			d.fail(err)
+		}
+		return flonum(math.Float64frombits(binary.LittleEndian.Uint64(bits[:])))
+	case tagCompnum:
+		re := d.datum()
+		return &compnum{re, d.datum()}
+	case tagStr:
+		return makeStr(d.string())
+	case tagChar:
+		return char(d.number())
+	case tagSymbol:
+		return symbol(d.string())
+	case tagVector:
+		v := &vector{make([]scmer, d.count())}
+		d.label(v)
+		for i := range v.items {
+			v.items[i] = d.datum()
+		}
+		return v
+	case tagBytevector:
+		return &bytevector{[]byte(d.string())}
+	case tagEmbedded:
+		if i := d.number(); i < len(embedded) {
+			return embedded[i]
+		}
+	case tagRef:
+		if n := d.number(); n < len(d.labels) && d.labels[n] != nil {
+			return d.labels[n]
+		}
+	case tagAlias:
+		switch i := d.number(); {
+		case i < len(d.aliases):
+			return d.aliases[i]
+		case i == len(d.aliases):
+			a := &alias{}
+			d.aliases = append(d.aliases, a)
+			a.name = d.datum()
+			return a
+		}
+	}
+	d.fail(fmt.Errorf("bytecode: bad value tag %d", tag))
+	return nil
+}
@@ -0,0 +1,148 @@
+package lisp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+// TestBytecodeRoundTrip checks that code saved to a bytecode file and loaded
+// again computes what it did before.
+func TestBytecodeRoundTrip(t *testing.T) {
+	source := `
+		(define (fact n) (if (< n 2) 1 (* n (fact (- n 1)))))
+		(fact 25)
+		(quote (1 2/3 4.5 -6+7i "eight" #\\9 #t () (x . y)))
+		((lambda (a . b) (list a b ` + "`(,a ,@b))" + `) 1 2 3)
+		(guard (e (#t (error-object-message e))) (error "oops"))
+		(quote #0=(a (b . #0#) . #0#))
+		((lambda () '#1=#(x #1# (#1#))))`
+	var blocks []*codeBlock
+	scanner := scan.NewScanner("<string>", strings.NewReader(source))
+	for {
+		datum, err := read(scanner)
+		if err != nil {
+			break
+		}
+		b, err := compileTopLevel(datum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	var file bytes.Buffer
+	if err := writeBytecode(&file, blocks); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(&file)
+	if !IsBytecode(r) {
+		t.Fatal("IsBytecode returned false for a bytecode file")
+	}
+	loaded, err := readBytecode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(blocks) {
+		t.Fatalf("wanted %d blocks, got %d", len(blocks), len(loaded))
+	}
+	for i := range blocks {
+		want, err := executeTopLevel(blocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := executeTopLevel(loaded[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(got, want) {
+			t.Errorf("block %d: wanted %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestReadBytecodeRejectsSource(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("(+ 1 2)"))
+	if IsBytecode(r) {
+		t.Error("IsBytecode returned true for source code")
+	}
+	if _, err := readBytecode(r); err == nil {
+		t.Error("readBytecode accepted source code")
+	}
+}
+
+// TestBytecodeMacros checks that the global macros a bytecode file defines are
+// defined again when it is loaded, so that code read afterwards can use them.
+func TestBytecodeMacros(t *testing.T) {
+	source := `
+		(define-syntax swap!
+		  (syntax-rules ()
+		    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+		(define-syntax define-getter
+		  (syntax-rules ()
+		    ((_ name value)
+		     (define-syntax name
+		       (syntax-rules (=>)
+		         ((_ => k) (k value))
+		         ((_ x (... ...)) (list value x (... ...))))))))
+		(define-getter get 7)
+		(define-syntax gone (syntax-rules () ((_) 1)))
+		(define gone 2)`
+	var blocks []*codeBlock
+	scanner := scan.NewScanner("<string>", strings.NewReader(source))
+	for {
+		datum, err := read(scanner)
+		if err != nil {
+			break
+		}
+		b, err := compileTopLevel(datum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+	var file bytes.Buffer
+	if err := writeBytecode(&file, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []symbol{"swap!", "define-getter", "get", "gone"} {
+		delete(globalMacros, name)
+	}
+	loaded, err := readBytecode(bufio.NewReader(&file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range loaded {
+		if _, err := executeTopLevel(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct{ source, want string }{
+		{"(let ((tmp 1) (b 2)) (swap! tmp b) (list tmp b))", "(2 1)"},
+		{"(get 1 2)", "(7 1 2)"},
+		{"(get => -)", "-7"},
+		{"(define-getter other 8)", "(#%undef define-syntax other)"},
+		{"(other)", "(8)"},
+		{"gone", "2"},
+	}
+	for _, test := range tests {
+		datum, err := read(scan.NewScanner("<string>", strings.NewReader(test.source)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := compileTopLevel(datum)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if got, err := executeTopLevel(b); err != nil {
+			t.Errorf("%s: %v", test.source, err)
+		} else if got.String() != test.want {
+			t.Errorf("%s: wanted %s, got %s", test.source, test.want, got)
+		}
+	}
+}
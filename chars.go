@@ -0,0 +1,124 @@
+package lisp
+
+import "unicode"
+
+/*
+ Characters
+
+ A char is a Unicode scalar value. Its case and classification come from Go's
+ unicode tables.
+*/
+
+// asChar returns x as a rune, or fails on behalf of the named procedure.
+func asChar(who string, x scmer) rune {
+	c, ok := x.(char)
+	if !ok {
+		Fail("%s: expected a char, got %s", who, x)
+	}
+	return rune(c)
+}
+
+// foldRune returns r case-folded, as for char-foldcase, string-foldcase and
+// the -ci comparisons.
+func foldRune(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+// digitValue returns the value of r if it is a decimal digit (of any script).
+func digitValue(r rune) (int, bool) {
+	// The decimal digits come in runs of ten, from 0 to 9, and each range
+	// in the table is one or more whole runs.
+	for _, g := range unicode.Nd.R16 {
+		if rune(g.Lo) <= r && r <= rune(g.Hi) {
+			return int(r-rune(g.Lo)) % 10, true
+		}
+	}
+	for _, g := range unicode.Nd.R32 {
+		if rune(g.Lo) <= r && r <= rune(g.Hi) {
+			return int(r-rune(g.Lo)) % 10, true
+		}
+	}
+	return 0, false
+}
+
+// charComparison returns a primitive that reports whether each of its
+// arguments is related to the next by holds, comparing their code points,
+// case-folded if fold is true.
+func charComparison(who string, fold bool, holds func(c int) bool) func(...scmer) scmer {
+	key := func(x scmer) rune {
+		r := asChar(who, x)
+		if fold {
+			return foldRune(r)
+		}
+		return r
+	}
+	return func(a ...scmer) scmer {
+		result := true
+		for i := range a {
+			k := key(a[i])
+			if i > 0 && result {
+				result = holds(int(key(a[i-1])) - int(k))
+			}
+		}
+		return boolean(result)
+	}
+}
+
+// charPredicate returns a primitive that reports whether its argument is a
+// char for which holds is true.
+func charPredicate(who string, holds func(r rune) bool) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		return boolean(holds(asChar(who, a[0])))
+	}
+}
+
+// charMapping returns a primitive that returns its argument, a char, mapped
+// by f.
+func charMapping(who string, f func(r rune) rune) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		return char(f(asChar(who, a[0])))
+	}
+}
+
+var charPrimitives = map[string]primitiveDef{
+	"char?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(char)
+		return boolean(ok)
+	}},
+	"char->integer": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(asChar("char->integer", a[0]))
+	}},
+	"integer->char": {arity{1, 1}, func(a ...scmer) scmer {
+		n, ok := a[0].(fixnum)
+		if !ok || n < 0 || n > unicode.MaxRune || 0xd800 <= n && n < 0xe000 {
+			Fail("integer->char: expected a Unicode scalar value, got %s", a[0])
+		}
+		return char(n)
+	}},
+	"char-upcase":   {arity{1, 1}, charMapping("char-upcase", unicode.ToUpper)},
+	"char-downcase": {arity{1, 1}, charMapping("char-downcase", unicode.ToLower)},
+	"char-foldcase": {arity{1, 1}, charMapping("char-foldcase", foldRune)},
+	"char-alphabetic?": {arity{1, 1}, charPredicate("char-alphabetic?", func(r rune) bool {
+		return unicode.In(r, unicode.Letter, unicode.Nl, unicode.Other_Alphabetic)
+	})},
+	"char-numeric?":    {arity{1, 1}, charPredicate("char-numeric?", unicode.IsDigit)},
+	"char-whitespace?": {arity{1, 1}, charPredicate("char-whitespace?", unicode.IsSpace)},
+	"char-upper-case?": {arity{1, 1}, charPredicate("char-upper-case?", unicode.IsUpper)},
+	"char-lower-case?": {arity{1, 1}, charPredicate("char-lower-case?", unicode.IsLower)},
+	"digit-value": {arity{1, 1}, func(a ...scmer) scmer {
+		if d, ok := digitValue(asChar("digit-value", a[0])); ok {
+			return fixnum(d)
+		}
+		return boolean(false)
+	}},
+	"char=?":     {arity{1, variadic}, charComparison("char=?", false, func(c int) bool { return c == 0 })},
+	"char<?":     {arity{1, variadic}, charComparison("char<?", false, func(c int) bool { return c < 0 })},
+	"char>?":     {arity{1, variadic}, charComparison("char>?", false, func(c int) bool { return c > 0 })},
+	"char<=?":    {arity{1, variadic}, charComparison("char<=?", false, func(c int) bool { return c <= 0 })},
+	"char>=?":    {arity{1, variadic}, charComparison("char>=?", false, func(c int) bool { return c >= 0 })},
+	"char-ci=?":  {arity{1, variadic}, charComparison("char-ci=?", true, func(c int) bool { return c == 0 })},
+	"char-ci<?":  {arity{1, variadic}, charComparison("char-ci<?", true, func(c int) bool { return c < 0 })},
+	"char-ci>?":  {arity{1, variadic}, charComparison("char-ci>?", true, func(c int) bool { return c > 0 })},
+	"char-ci<=?": {arity{1, variadic}, charComparison("char-ci<=?", true, func(c int) bool { return c <= 0 })},
+	"char-ci>=?": {arity{1, variadic}, charComparison("char-ci>=?", true, func(c int) bool { return c >= 0 })},
+}
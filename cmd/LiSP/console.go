@@ -1,4 +1,4 @@
-package scan
+package main
 
 import (
 	"io"
@@ -13,7 +13,7 @@ type gnuReadline struct {
 	next int
 }
 
-func NewConsoleReader( /* config *config.T */ ) io.ByteReader {
+func newConsoleReader( /* config *config.T */ ) io.ByteReader {
 	return &gnuReadline{ /*////config,*/ "", 0}
 }
 
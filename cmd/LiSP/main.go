@@ -0,0 +1,168 @@
+// Based on https://github.com/robpike/ivy/blob/master/ivy.go
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	lisp "github.com/perlmonger42/LiSP"
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+var (
+	execute  = flag.Bool("e", false, "execute arguments as a single expression")
+	testMode = flag.Bool("test", false, "execute Read Eval Read Compare Loop")
+	output   = flag.String("o", "", "compile the files to bytecode in `file` (or Go source, with -compile-go), instead of running them")
+	toGo     = flag.Bool("compile-go", false, "compile the files to the Go source of a standalone program")
+	// format  = flag.String("format", "", "use `fmt` as format for printing numbers; empty sets default format")
+	// gformat = flag.Bool("g", false, `shorthand for -format="%.12g"`)
+	// maxbits   = flag.Uint("maxbits", 1e9, "maximum size of an integer, in bits; 0 means no limit")
+	// maxdigits = flag.Uint("maxdigits", 1e4, "above this many `digits`, integers print as floating point; 0 disables")
+	prompt  = flag.String("prompt", "> ", "command `prompt`")
+	prompt2 = flag.String("prompt2", "? ", "continued command `prompt`")
+	// debugFlag = flag.String("debug", "", "comma-separated `names` of debug settings to enable")
+)
+
+func init() {
+	flag.BoolVar(&lisp.Tracing, "trace", false, "print exprs before and after eval")
+	flag.BoolVar(&lisp.UseVM, "vm", false, "compile exprs to bytecode and run them on the virtual machine")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: LiSP [options] [file ...]\n")
+	fmt.Fprintf(os.Stderr, "Flags may come before or after the files, up to --; with -e, the\n")
+	fmt.Fprintf(os.Stderr, "arguments after the flags are the expression.\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// args holds the command-line arguments that are not flags.
+var args []string
+
+// parseArgs parses the flags on the command line, wherever they are, and sets
+// args to the other arguments, in order. The flag package alone stops at the
+// first argument that is not a flag, which would make a file of the -o in
+// "LiSP prog.scm -o prog.bc".
+func parseArgs() {
+	rest := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(rest)
+		n := len(rest) - flag.NArg()
+		if *execute || n > 0 && rest[n-1] == "--" {
+			args = append(args, flag.Args()...)
+			return
+		}
+		rest = flag.Args()
+		if len(rest) == 0 {
+			return
+		}
+		args, rest = append(args, rest[0]), rest[1:]
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	parseArgs()
+
+	if *execute {
+		stringReader := strings.NewReader(strings.Join(args, " "))
+		scanner := scan.NewScanner("<args>", stringReader)
+		Run(scanner, false)
+		return
+	}
+
+	if *toGo {
+		if err := compileGo(args, *output); err != nil {
+			fmt.Fprintf(os.Stderr, "LiSP: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *output != "" {
+		if err := lisp.CompileFiles(args, *output); err != nil {
+			fmt.Fprintf(os.Stderr, "LiSP: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) > 0 {
+		for _, name := range args {
+			var reader io.ByteReader
+			interactive := false
+			if name == "-" {
+				interactive = true
+				name = "<stdin>"
+				reader = newConsoleReader()
+			} else {
+				if f, err := os.Open(name); err != nil {
+					fmt.Fprintf(os.Stderr, "LiSP: %s\n", err)
+					os.Exit(1)
+				} else if r := bufio.NewReader(f); lisp.IsBytecode(r) {
+					if ok := lisp.RunBytecode(r); !ok {
+						break
+					}
+					continue
+				} else {
+					reader = r
+				}
+			}
+			scanner := scan.NewScanner(name, reader)
+			if name == "<stdin>" {
+				lisp.SetStandardInput(scanner)
+			}
+			if ok := Run(scanner, interactive); !ok {
+				break
+			}
+		}
+		return
+	}
+
+	scanner := scan.NewScanner("<stdin>", newConsoleReader())
+	lisp.SetStandardInput(scanner)
+	Run(scanner, true)
+}
+
+func Run(scanner *scan.Scanner, interactive bool) bool {
+	var err error
+	if *testMode {
+		err = lisp.Rercl(scanner, interactive)
+	} else {
+		err = lisp.Repl(scanner, interactive)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	return err == nil
+}
+
+// compileGo compiles the named files to Go source, written to the file out, or
+// to standard output if out is empty.
+func compileGo(names []string, out string) error {
+	if out == "" {
+		return lisp.CompileGo(names, os.Stdout)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := lisp.CompileGo(names, f); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	return f.Close()
+}
+
+// runArgs executes the text of the command-line arguments as a LiSP program.
+func runArgs() {
+	stringReader := strings.NewReader(strings.Join(args, " "))
+	scanner := scan.NewScanner("<args>", stringReader)
+	lisp.Repl(scanner, false)
+}
@@ -0,0 +1,234 @@
+package lisp
+
+import "encoding/binary"
+
+/*
+ Bytecode compiler
+
+ compile turns an analyzed expression into a codeBlock: a compact string of
+ bytecode, with tables of the constants, global variables and nested lambdas
+ it refers to. The virtual machine that executes it is in vm.go, and the
+ format in which it is saved to disk is in bytecode.go.
+
+ This is the design of chapter 7 of Lisp in Small Pieces, more or less. The
+ bytecode has a single register, the accumulator (machine.value), and a stack
+ per activation for the operands of the calls that are being set up.
+*/
+
+// The opcodes. Each is a byte, followed by its operands, each of which is an
+// unsigned varint.
+const (
+	opConst        byte = iota // k: load constants[k]
+	opLocal0                   // i: load local variable i of the current activation
+	opLocal                    // d i: load local variable i of the activation d levels out
+	opGlobal                   // g: load the value of globals[g]
+	opSetLocal                 // d i: assign the accumulator to a local variable
+	opSetGlobal                // g: assign the accumulator to globals[g], which must be defined
+	opDefineGlobal             // g: define globals[g] with the value of the accumulator
+	opJump                     // n: skip n bytes
+	opJumpFalse                // n: skip n bytes if the accumulator is #f
+	opClosure                  // b: load a closure of blocks[b] over the current activation
+	opPush                     // push the accumulator onto the stack
+	opCall                     // n: apply the procedure below the top n values to them
+	opTailCall                 // n: likewise, in place of the current activation
+	opReturn                   // pass the accumulator to the continuation
+)
+
+// A codeBlock is the compiled code of a lambda expression, or of an
+// expression at top level.
+type codeBlock struct {
+	code      []byte
+	constants []scmer
+	globals   []*global
+	blocks    []*codeBlock      // the lambda expressions within this one
+	outer     *codeBlock        // the lambda expression this one is within, if any
+	lines     []line            // where the code came from, in order of pc
+	macros    []macroDefinition // the global macros a top-level expression defines
+
+	// As for a lambda.
+	name            symbol
+	params, written scmer
+	hidden          bool
+	arity           int
+	rest            bool
+	size            int
+	names           []symbol // the names of the variables in the activation
+
+	maxStack int // the most values the code has on the stack at once
+}
+
+// A compiler compiles code into a codeBlock.
+type compiler struct {
+	b       *codeBlock
+	globals map[*global]int // the index in b.globals of each global
+	depth   int             // the number of values on the stack
+}
+
+// compile compiles the analyzed expression n, to be executed at top level.
+func compile(n node) *codeBlock {
+	c := &compiler{b: &codeBlock{}, globals: map[*global]int{}}
+	c.compile(n, true)
+	return c.b
+}
+
+// compileLambda compiles l, which is within the lambda expression outer (or
+// nil, at top level).
+func compileLambda(l *lambda, outer *codeBlock) *codeBlock {
+	b := &codeBlock{
+		outer:   outer,
+		name:    l.name,
+		params:  l.params,
+		written: l.written,
+		hidden:  l.hidden,
+		arity:   l.arity,
+		rest:    l.rest,
+		size:    l.size,
+		names:   l.names,
+	}
+	c := &compiler{b: b, globals: map[*global]int{}}
+	c.compile(l.code, true)
+	return b
+}
+
+// emit appends an instruction to the code.
+func (c *compiler) emit(op byte, operands ...int) {
+	c.b.code = append(c.b.code, op)
+	for _, x := range operands {
+		c.b.code = binary.AppendUvarint(c.b.code, uint64(x))
+	}
+}
+
+// constant returns the index of a new constant, x.
+func (c *compiler) constant(x scmer) int {
+	c.b.constants = append(c.b.constants, x)
+	return len(c.b.constants) - 1
+}
+
+// global returns the index of g in the table of globals.
+func (c *compiler) global(g *global) int {
+	i, ok := c.globals[g]
+	if !ok {
+		i = len(c.b.globals)
+		c.b.globals = append(c.b.globals, g)
+		c.globals[g] = i
+	}
+	return i
+}
+
+// mark notes that the code from here on was compiled from an expression at
+// loc, if that is known.
+func (c *compiler) mark(loc *location) {
+	if n := len(c.b.lines); loc == nil || n > 0 && c.b.lines[n-1].loc == loc {
+		return
+	}
+	c.b.lines = append(c.b.lines, line{len(c.b.code), loc})
+}
+
+// fragment returns the code for n, and its lines, without adding them to the
+// block.
+func (c *compiler) fragment(n node, tail bool) ([]byte, []line) {
+	savedCode, savedLines := c.b.code, c.b.lines
+	c.b.code, c.b.lines = nil, nil
+	c.compile(n, tail)
+	code, lines := c.b.code, c.b.lines
+	c.b.code, c.b.lines = savedCode, savedLines
+	return code, lines
+}
+
+// splice appends a fragment's code and lines to the block.
+func (c *compiler) splice(code []byte, lines []line) {
+	for _, l := range lines {
+		c.b.lines = append(c.b.lines, line{len(c.b.code) + l.pc, l.loc})
+	}
+	c.b.code = append(c.b.code, code...)
+}
+
+// compile appends the code for n. If tail is true, n is in tail position, and
+// its code passes its value to the continuation.
+func (c *compiler) compile(n node, tail bool) {
+	c.mark(n.where())
+	switch n := n.(type) {
+	case *constant:
+		c.emit(opConst, c.constant(n.value))
+	case *localRef:
+		if n.depth == 0 {
+			c.emit(opLocal0, n.index)
+		} else {
+			c.emit(opLocal, n.depth, n.index)
+		}
+	case *globalRef:
+		c.emit(opGlobal, c.global(n.g))
+	case *ifNode:
+		c.compile(n.test, false)
+		consequent, consequentLines := c.fragment(n.consequent, tail)
+		alternative, alternativeLines := c.fragment(n.alternative, tail)
+		if !tail {
+			// The consequent must jump over the alternative.
+			consequent = append(consequent, opJump)
+			consequent = binary.AppendUvarint(consequent, uint64(len(alternative)))
+		}
+		c.emit(opJumpFalse, len(consequent))
+		c.splice(consequent, consequentLines)
+		c.splice(alternative, alternativeLines)
+		return
+	case *assignment:
+		c.compile(n.value, false)
+		c.mark(n.loc)
+		switch t := n.target.(type) {
+		case *localRef:
+			c.emit(opSetLocal, t.depth, t.index)
+		case *globalRef:
+			c.emit(opSetGlobal, c.global(t.g))
+		}
+		c.emit(opConst, c.constant(n.result))
+	case *definition:
+		c.compile(n.value, false)
+		c.emit(opDefineGlobal, c.global(n.g))
+		c.emit(opConst, c.constant(n.result))
+	case *lambda:
+		// A lambda at top level has no activation to be within.
+		outer := c.b
+		if c.b.params == nil {
+			outer = nil
+		}
+		c.b.blocks = append(c.b.blocks, compileLambda(n, outer))
+		c.emit(opClosure, len(c.b.blocks)-1)
+	case *sequence:
+		for _, x := range n.body[:len(n.body)-1] {
+			c.compile(x, false)
+		}
+		c.compile(n.body[len(n.body)-1], tail)
+		return
+	case *application:
+		pushed := 0
+		if n.apply {
+			c.emit(opConst, c.constant(applyControl))
+			c.push()
+			pushed++
+		}
+		for _, x := range n.parts {
+			c.compile(x, false)
+			c.push()
+			pushed++
+		}
+		c.depth -= pushed
+		c.mark(n.loc)
+		if tail {
+			c.emit(opTailCall, pushed-1)
+			return
+		}
+		c.emit(opCall, pushed-1)
+	}
+	if tail {
+		c.emit(opReturn)
+	}
+}
+
+// push appends an opPush, and keeps track of how deep the stack gets.
+func (c *compiler) push() {
+	c.emit(opPush)
+	c.depth++
+	if c.depth > c.b.maxStack {
+		c.b.maxStack = c.depth
+	}
+}
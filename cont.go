@@ -0,0 +1,334 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ Continuations and dynamic-wind
+
+ (values obj ...) returns its arguments together, as one value that
+ call-with-values takes apart again. A parameter object's value is part of
+ the dynamic state: parameterize changes it for the extent of its body, with
+ a dynamic-wind whose before and after thunks swap the values in and out.
+*/
+
+// A control is a primitive procedure that needs access to the machine that
+// applies it, because it does something other than compute a value from its
+// arguments: apply a procedure in tail position, capture or replace the
+// continuation, and so on.
+type control struct {
+	name symbol
+	arity
+	f func(m *machine, args []scmer)
+}
+
+// A controlDef is an entry in a table of controls, like a primitiveDef.
+type controlDef struct {
+	arity
+	f func(m *machine, args []scmer)
+}
+
+func (x *control) String() string {
+	return fmt.Sprintf("#<primitive:%s>", x.name)
+}
+
+// applyControl implements the apply special form: (apply f args).
+var applyControl = &control{"apply", arity{2, 2}, func(m *machine, a []scmer) {
+	m.apply(a[0], spread(a[1]))
+}}
+
+// A winder records a call to dynamic-wind whose thunk is still active.
+// The winders in effect form a stack, linked through outer.
+type winder struct {
+	before, after scmer
+	outer         *winder
+	depth         int // the number of winders in the stack, counting this one
+}
+
+// winders is the stack of dynamic-wind calls currently in effect.
+var winders *winder
+
+// A continuation is a first-class continuation, as created by call/cc.
+type continuation struct {
+	k        frame
+	base     frame // the frame that ends k
+	winders  *winder
+	handlers *handler
+	output   *port // the current output port
+}
+
+func (x *continuation) String() string {
+	return "#<continuation>"
+}
+
+// invoke passes args to the continuation c, as values passes them to its
+// own. Any after thunks of dynamic-wind calls that c is outside of are run
+// first, innermost first, followed by the before thunks of the calls that c is
+// inside of, outermost first.
+func (c *continuation) invoke(m *machine, args []scmer) {
+	var exits, enters []*winder // each innermost first
+	from, to := winders, c.winders
+	for from.height() > to.height() {
+		exits, from = append(exits, from), from.outer
+	}
+	for to.height() > from.height() {
+		enters, to = append(enters, to), to.outer
+	}
+	for from != to {
+		exits, from = append(exits, from), from.outer
+		enters, to = append(enters, to), to.outer
+	}
+
+	// Frames are resumed in the opposite order to that in which they are
+	// pushed, and the current continuation is abandoned.
+	var k frame = &reinstateFrame{c, makeValues(args...)}
+	for _, w := range enters {
+		k = &windFrame{w.before, w.outer, k}
+	}
+	for i := len(exits) - 1; i >= 0; i-- {
+		k = &windFrame{exits[i].after, exits[i].outer, k}
+	}
+	m.k = k
+	m.ret(nil)
+}
+
+// height returns the number of winders in the stack whose top is w.
+func (w *winder) height() int {
+	if w == nil {
+		return 0
+	}
+	return w.depth
+}
+
+// windFrame calls a before or after thunk while unwinding to, or rewinding
+// into, the dynamic extent of a continuation. It ignores the value it is
+// given.
+type windFrame struct {
+	thunk   scmer
+	winders *winder // the winders in effect while thunk runs
+	next    frame
+}
+
+func (f *windFrame) resume(m *machine) {
+	m.k = f.next
+	winders = f.winders
+	m.apply(f.thunk, nil)
+}
+
+func (f *windFrame) below() frame { return f.next }
+
+// reinstateFrame makes a continuation current, once the thunks between it and
+// the current continuation have been run.
+type reinstateFrame struct {
+	c     *continuation
+	value scmer
+}
+
+func (f *reinstateFrame) resume(m *machine) {
+	// Compiled code runs on the Go stack, so the code that a machine it
+	// started would return to is gone once that machine has halted.
+	if h, ok := f.c.base.(*haltFrame); ok && h.native && !h.running() {
+		Fail("continuation no longer active")
+	}
+	winders, handlers, currentOutput = f.c.winders, f.c.handlers, f.c.output
+	// If the continuation belongs to a machine further out (that is, one
+	// which called a Go primitive which called back into Scheme), unwind the
+	// Go stack to that machine and resume there.
+	for i := len(machines) - 1; i >= 0; i-- {
+		if machines[i] != m && machines[i].base == f.c.base {
+			panic(&escape{machines[i], f.c.k, f.c.base, f.value})
+		}
+	}
+	m.k, m.base = f.c.k, f.c.base
+	m.ret(f.value)
+}
+
+// running reports whether the machine that f ends is running.
+func (f *haltFrame) running() bool {
+	for _, m := range machines {
+		if m.base == f {
+			return true
+		}
+	}
+	return false
+}
+
+// A reinstateFrame replaces the continuation, so nothing is below it.
+func (f *reinstateFrame) below() frame { return nil }
+
+// An escape is raised (with panic) to transfer control to a continuation that
+// belongs to a machine further out on the Go stack.
+type escape struct {
+	to    *machine
+	k     frame
+	base  frame
+	value scmer
+}
+
+// dynamicWindFrame calls the thunk of a dynamic-wind, once before has been
+// called.
+type dynamicWindFrame struct {
+	before, thunk, after scmer
+	next                 frame
+}
+
+func (f *dynamicWindFrame) resume(m *machine) {
+	m.k = f.next
+	w := &winder{f.before, f.after, winders, winders.height() + 1}
+	m.push(&unwindFrame{w, m.k})
+	winders = w
+	m.apply(f.thunk, nil)
+}
+
+func (f *dynamicWindFrame) below() frame { return f.next }
+
+// unwindFrame calls the after thunk of a dynamic-wind when its thunk returns.
+type unwindFrame struct {
+	w    *winder
+	next frame
+}
+
+func (f *unwindFrame) resume(m *machine) {
+	m.k = f.next
+	winders = f.w.outer
+	m.push(&valueFrame{m.value, m.k})
+	m.apply(f.w.after, nil)
+}
+
+func (f *unwindFrame) below() frame { return f.next }
+
+// valueFrame ignores the value it is given, and returns its own instead.
+type valueFrame struct {
+	value scmer
+	next  frame
+}
+
+func (f *valueFrame) resume(m *machine) {
+	m.k = f.next
+	m.ret(f.value)
+}
+
+func (f *valueFrame) below() frame { return f.next }
+
+// multipleValues is the value of (values obj ...) with other than exactly one
+// obj.
+type multipleValues struct {
+	values []scmer
+}
+
+func (x *multipleValues) String() string {
+	var b strings.Builder
+	for i, v := range x.values {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// makeValues returns the value that passes the values a to a continuation.
+func makeValues(a ...scmer) scmer {
+	if len(a) == 1 {
+		return a[0]
+	}
+	return &multipleValues{append([]scmer(nil), a...)}
+}
+
+// valuesFrame applies consumer to the values it is given, for
+// call-with-values.
+type valuesFrame struct {
+	consumer scmer
+	next     frame
+}
+
+func (f *valuesFrame) resume(m *machine) {
+	m.k = f.next
+	if v, ok := m.value.(*multipleValues); ok {
+		m.apply(f.consumer, append([]scmer(nil), v.values...))
+	} else {
+		m.apply(f.consumer, []scmer{m.value})
+	}
+}
+
+func (f *valuesFrame) below() frame { return f.next }
+
+// callWithValues implements (call-with-values producer consumer).
+func callWithValues(m *machine, a []scmer) {
+	m.push(&valuesFrame{a[1], m.k})
+	m.apply(a[0], nil)
+}
+
+// A parameter is a parameter object, as made by make-parameter. Applied to
+// no arguments, it returns its value.
+type parameter struct {
+	value     scmer
+	converter scmer // the procedure that converts a new value, or nil
+}
+
+func (x *parameter) String() string {
+	return "#<parameter>"
+}
+
+// parameterize implements (parameterize thunk param value ...), to which the
+// parameterize special form expands: it calls thunk with each param bound to
+// its value.
+func parameterize(m *machine, a []scmer) {
+	params := make([]*parameter, (len(a)-1)/2)
+	values := make([]scmer, len(params))
+	for i := range params {
+		p, ok := a[1+2*i].(*parameter)
+		if !ok {
+			Fail("parameterize: expected a parameter, got %s", a[1+2*i])
+		}
+		params[i], values[i] = p, a[2+2*i]
+		if p.converter != nil {
+			values[i] = apply(p.converter, []scmer{values[i]})
+		}
+	}
+	swap := &primitive{"parameterize", arity{0, 0}, func(...scmer) scmer {
+		for i, p := range params {
+			p.value, values[i] = values[i], p.value
+		}
+		return symbol("#%void")
+	}}
+	m.push(&dynamicWindFrame{swap, a[0], swap, m.k})
+	m.apply(swap, nil)
+}
+
+// valuesCallWithValues and parameterizeControl are the procedures that the
+// expansions of let-values, define-values and parameterize call.
+var (
+	valuesCallWithValues = &control{"call-with-values", arity{2, 2}, callWithValues}
+	parameterizeControl  = &control{"parameterize", arity{1, variadic}, parameterize}
+)
+
+// controls are added to the global environment along with the primitives.
+var controls = map[string]controlDef{
+	"call-with-current-continuation": {arity{1, 1}, callCC},
+	"call/cc":                        {arity{1, 1}, callCC},
+	"dynamic-wind": {arity{3, 3}, func(m *machine, a []scmer) {
+		m.push(&dynamicWindFrame{a[0], a[1], a[2], m.k})
+		m.apply(a[0], nil)
+	}},
+	"call-with-values": {arity{2, 2}, callWithValues},
+}
+
+var continuationPrimitives = map[string]primitiveDef{
+	"values": {arity{0, variadic}, makeValues},
+	"make-parameter": {arity{1, 2}, func(a ...scmer) scmer {
+		p := &parameter{value: a[0]}
+		if len(a) > 1 {
+			p.converter = a[1]
+			p.value = apply(p.converter, []scmer{a[0]})
+		}
+		return p
+	}},
+}
+
+// callCC implements (call/cc f).
+func callCC(m *machine, a []scmer) {
+	m.apply(a[0], []scmer{&continuation{m.k, m.base, winders, handlers, currentOutput}})
+}
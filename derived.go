@@ -0,0 +1,536 @@
+package lisp
+
+/*
+ Syntactic analysis: derived expressions
+
+ The derived expressions of R7RS (let and its variants, cond, case, and, or,
+ when, unless, do, case-lambda, parameterize, delay and delay-force) are
+ rewritten in terms of the core special forms and then expanded in turn, as
+ if they were macros defined at top level. The keywords and temporary
+ variables that a rewriting introduces are aliases closed in the global
+ environment (see introduce), so they cannot be captured by the variables of
+ the form being rewritten, nor can a local binding of lambda or if change
+ what the rewriting means.
+
+ Where a rewriting needs a procedure, it contains the procedure itself, as
+ guard's expansion does, so that it works even where the procedure's name has
+ been redefined.
+
+ cond-expand is here too, though it is not rewritten: it expands into the body
+ of the clause whose feature requirement is met.
+*/
+
+// introduce returns a new identifier for name, as a macro defined at top
+// level would introduce it. Where it is free, it means what name means
+// globally, whatever is bound where it is used; where it is bound, it is
+// distinct from every other identifier.
+func introduce(name symbol) scmer {
+	return &alias{name, nil}
+}
+
+// unspecified returns an expanded expression for the value of a form whose
+// value R7RS leaves unspecified, such as a cond in which no clause applies.
+func unspecified() scmer {
+	return makeList(symbol("quote"), symbol("#%void"))
+}
+
+// lambdaForm returns (lambda params body ...).
+func lambdaForm(params scmer, body []scmer) scmer {
+	return &pair{introduce("lambda"), &pair{params, makeList(body...)}}
+}
+
+// letBindings returns the variables and initial values of the bindings
+// ((var init) ...) of the derived expression k. No variable may be bound
+// twice.
+func letBindings(k symbol, x scmer) (vars, inits []scmer) {
+	bindings, ok := listToSlice(x)
+	if !ok {
+		Fail("bad syntax: %s: bindings must be a list: %s", k, x)
+	}
+	for _, b := range bindings {
+		spec, ok := listToSlice(b)
+		if !ok || len(spec) != 2 || !isIdentifier(spec[0]) {
+			Fail("bad syntax: %s: bad binding: %s", k, syntaxToDatum(b))
+		}
+		for _, v := range vars {
+			if v == spec[0] {
+				Fail("bad syntax: %s: duplicate variable: %s", k, syntaxToDatum(v))
+			}
+		}
+		vars, inits = append(vars, spec[0]), append(inits, spec[1])
+	}
+	return vars, inits
+}
+
+// expandLet expands (let ((var init) ...) body ...), which is
+//
+//	((lambda (var ...) body ...) init ...)
+//
+// and the named let (let name ((var init) ...) body ...), which is
+//
+//	((letrec ((name (lambda (var ...) body ...))) name) init ...)
+func expandLet(form []scmer, e *senv) scmer {
+	if len(form) > 1 && isIdentifier(form[1]) {
+		if len(form) < 4 {
+			Fail("bad syntax: let requires a name, bindings and a body: %s", syntaxToDatum(makeList(form...)))
+		}
+		vars, inits := letBindings("let", form[2])
+		proc := makeList(introduce("letrec"),
+			makeList(makeList(form[1], lambdaForm(makeList(vars...), form[3:]))),
+			form[1])
+		return expand(&pair{proc, makeList(inits...)}, e)
+	}
+	if len(form) < 3 {
+		Fail("bad syntax: let requires bindings and a body: %s", syntaxToDatum(makeList(form...)))
+	}
+	vars, inits := letBindings("let", form[1])
+	return expand(&pair{lambdaForm(makeList(vars...), form[2:]), makeList(inits...)}, e)
+}
+
+// expandLetStar expands (let* (binding ...) body ...) into nested lets, one
+// per binding.
+func expandLetStar(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: let* requires bindings and a body: %s", syntaxToDatum(makeList(form...)))
+	}
+	bindings, ok := listToSlice(form[1])
+	if !ok {
+		Fail("bad syntax: let*: bindings must be a list: %s", form[1])
+	}
+	if len(bindings) <= 1 {
+		return expand(&pair{introduce("let"), &pair{form[1], makeList(form[2:]...)}}, e)
+	}
+	inner := &pair{introduce("let*"), &pair{makeList(bindings[1:]...), makeList(form[2:]...)}}
+	return expand(makeList(introduce("let"), makeList(bindings[0]), inner), e)
+}
+
+// expandLetrec expands (letrec ((var init) ...) body ...), and letrec* the
+// same way, as
+//
+//	((lambda () (define var init) ... (let () body ...)))
+//
+// The variables are those of the procedure's activation, which are
+// unassigned until their defines are evaluated, in order. They are all bound
+// before any init is expanded, so that each init is in the scope of them all.
+func expandLetrec(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: %s requires bindings and a body: %s", form[0], syntaxToDatum(makeList(form...)))
+	}
+	vars, inits := letBindings(base(form[0]), form[1])
+	inner := &senv{map[scmer]*meaning{}, true, e}
+	names := make([]symbol, len(vars))
+	for i, v := range vars {
+		names[i] = bind(v, inner)
+	}
+	var body []scmer
+	for i, name := range names {
+		body = append(body, makeList(symbol("define"), name, expand(inits[i], inner)))
+	}
+	body = append(body, expand(&pair{introduce("let"), &pair{empty, makeList(form[2:]...)}}, inner))
+	return makeList(makeList(symbol("lambda"), empty, &pair{symbol("begin"), makeList(body...)}))
+}
+
+// valuesFormals returns the formals of a let-values binding or define-values
+// with the same shape as formals, but with a new variable in place of each
+// one, and also returns the variables of both, in order.
+func valuesFormals(k symbol, formals scmer) (temps scmer, vars, tempVars []scmer) {
+	var items []scmer
+	for {
+		if p, ok := formals.(*pair); ok && isIdentifier(p.car) {
+			vars, formals = append(vars, p.car), p.cdr
+			items = append(items, introduce("value"))
+		} else if isIdentifier(formals) {
+			vars = append(vars, formals)
+			temps = introduce("values")
+			break
+		} else if formals == empty {
+			temps = empty
+			break
+		} else {
+			Fail("bad syntax: %s: bad formals: %s", k, syntaxToDatum(formals))
+		}
+	}
+	tempVars = append(tempVars, items...)
+	if temps != empty {
+		tempVars = append(tempVars, temps)
+	}
+	return makeDottedList(items, temps), vars, tempVars
+}
+
+// expandLetValues expands (let-values ((formals expr) ...) body ...). The
+// values of each expr are received by new variables, so that no expr is in
+// the scope of the variables bound before it:
+//
+//	(call-with-values (lambda () expr) (lambda temps ...
+//	  ((lambda (var ...) body ...) temp ...)))
+func expandLetValues(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: let-values requires bindings and a body: %s", syntaxToDatum(makeList(form...)))
+	}
+	bindings, ok := listToSlice(form[1])
+	if !ok {
+		Fail("bad syntax: let-values: bindings must be a list: %s", form[1])
+	}
+	var allVars, allTemps []scmer
+	var producers, receivers []scmer
+	for _, b := range bindings {
+		spec, ok := listToSlice(b)
+		if !ok || len(spec) != 2 {
+			Fail("bad syntax: let-values: bad binding: %s", syntaxToDatum(b))
+		}
+		temps, vars, tempVars := valuesFormals("let-values", spec[0])
+		allVars, allTemps = append(allVars, vars...), append(allTemps, tempVars...)
+		producers = append(producers, lambdaForm(empty, spec[1:]))
+		receivers = append(receivers, temps)
+	}
+	var x scmer = &pair{lambdaForm(makeList(allVars...), form[2:]), makeList(allTemps...)}
+	for i := len(bindings) - 1; i >= 0; i-- {
+		x = makeList(valuesCallWithValues, producers[i], lambdaForm(receivers[i], []scmer{x}))
+	}
+	return expand(x, e)
+}
+
+// expandLetStarValues expands (let*-values (binding ...) body ...) into nested
+// let-values, one per binding.
+func expandLetStarValues(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: let*-values requires bindings and a body: %s", syntaxToDatum(makeList(form...)))
+	}
+	bindings, ok := listToSlice(form[1])
+	if !ok {
+		Fail("bad syntax: let*-values: bindings must be a list: %s", form[1])
+	}
+	if len(bindings) <= 1 {
+		return expand(&pair{introduce("let-values"), &pair{form[1], makeList(form[2:]...)}}, e)
+	}
+	inner := &pair{introduce("let*-values"), &pair{makeList(bindings[1:]...), makeList(form[2:]...)}}
+	return expand(makeList(introduce("let-values"), makeList(bindings[0]), inner), e)
+}
+
+// expandDefineValues expands (define-values formals expr), which defines
+// each variable of formals and then assigns it its value:
+//
+//	(begin (define var #f) ...
+//	       (call-with-values (lambda () expr)
+//	         (lambda temps (set! var temp) ...)))
+func expandDefineValues(form []scmer, e *senv) scmer {
+	if len(form) != 3 {
+		Fail("bad syntax: define-values requires formals and an expression: %s", syntaxToDatum(makeList(form...)))
+	}
+	temps, vars, tempVars := valuesFormals("define-values", form[1])
+	var defines, sets []scmer
+	for i, v := range vars {
+		defines = append(defines, makeList(introduce("define"), v, boolean(false)))
+		sets = append(sets, makeList(introduce("set!"), v, tempVars[i]))
+	}
+	sets = append(sets, makeList(introduce("quote"), symbol("#%void")))
+	call := makeList(valuesCallWithValues, lambdaForm(empty, form[2:]), lambdaForm(temps, sets))
+	return expand(&pair{introduce("begin"), makeList(append(defines, call)...)}, e)
+}
+
+// expandCase expands (case key clause ...) as
+//
+//	((lambda (key) tests) key)
+//
+// where tests tests the clauses in turn, like cond, each with memv.
+func expandCase(form []scmer, e *senv) scmer {
+	if len(form) < 2 {
+		Fail("bad syntax: case requires a key: %s", syntaxToDatum(makeList(form...)))
+	}
+	key := introduce("key")
+	var tests scmer = makeList(introduce("quote"), symbol("#%void"))
+	for i := len(form) - 1; i >= 2; i-- {
+		clause, ok := listToSlice(form[i])
+		if !ok || len(clause) < 2 {
+			Fail("bad syntax: case: bad clause: %s", syntaxToDatum(form[i]))
+		}
+		var body scmer = &pair{introduce("begin"), makeList(clause[1:]...)}
+		if isAuxiliary(clause[1], "=>", e) {
+			if len(clause) != 3 {
+				Fail("bad syntax: case: bad clause: %s", syntaxToDatum(form[i]))
+			}
+			body = makeList(clause[2], key)
+		}
+		if isAuxiliary(clause[0], "else", e) {
+			if i != len(form)-1 {
+				Fail("bad syntax: case: else clause must be last: %s", syntaxToDatum(form[i]))
+			}
+			tests = body
+			continue
+		}
+		if _, ok := listToSlice(clause[0]); !ok {
+			Fail("bad syntax: case: data must be a list: %s", syntaxToDatum(form[i]))
+		}
+		test := makeList(caseMemv, key, makeList(introduce("quote"), clause[0]))
+		tests = makeList(introduce("if"), test, body, tests)
+	}
+	return expand(makeList(lambdaForm(makeList(key), []scmer{tests}), form[1]), e)
+}
+
+// expandAnd expands (and test ...) into nested ifs.
+func expandAnd(form []scmer, e *senv) scmer {
+	switch len(form) {
+	case 1:
+		return boolean(true)
+	case 2:
+		return expand(form[1], e)
+	}
+	rest := &pair{introduce("and"), makeList(form[2:]...)}
+	return expand(makeList(introduce("if"), form[1], rest, boolean(false)), e)
+}
+
+// expandOr expands (or test ...) into nested ifs. Each test's value is bound
+// to a variable, so that it is only evaluated once:
+//
+//	((lambda (x) (if x x (or test ...))) test)
+func expandOr(form []scmer, e *senv) scmer {
+	switch len(form) {
+	case 1:
+		return boolean(false)
+	case 2:
+		return expand(form[1], e)
+	}
+	x := introduce("x")
+	rest := &pair{introduce("or"), makeList(form[2:]...)}
+	test := makeList(introduce("if"), x, x, rest)
+	return expand(makeList(lambdaForm(makeList(x), []scmer{test}), form[1]), e)
+}
+
+// expandWhen expands (when test body ...) and (unless test body ...) into an
+// if.
+func expandWhen(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: %s requires a test and a body: %s", form[0], syntaxToDatum(makeList(form...)))
+	}
+	body := &pair{introduce("begin"), makeList(form[2:]...)}
+	if base(form[0]) == "unless" {
+		return expand(makeList(introduce("if"), form[1], makeList(introduce("quote"), symbol("#%void")), body), e)
+	}
+	return expand(makeList(introduce("if"), form[1], body), e)
+}
+
+// expandDo expands (do ((var init step) ...) (test expr ...) command ...) as
+//
+//	(let loop ((var init) ...)
+//	  (if test
+//	      (begin expr ...)
+//	      (begin command ... (loop step ...))))
+//
+// where a variable without a step keeps its value.
+func expandDo(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: do requires bindings and a test: %s", syntaxToDatum(makeList(form...)))
+	}
+	specs, ok := listToSlice(form[1])
+	if !ok {
+		Fail("bad syntax: do: bindings must be a list: %s", form[1])
+	}
+	var bindings, steps []scmer
+	for _, s := range specs {
+		spec, ok := listToSlice(s)
+		if !ok || len(spec) < 2 || len(spec) > 3 || !isIdentifier(spec[0]) {
+			Fail("bad syntax: do: bad binding: %s", syntaxToDatum(s))
+		}
+		bindings = append(bindings, makeList(spec[0], spec[1]))
+		steps = append(steps, spec[len(spec)-1])
+	}
+	exit, ok := listToSlice(form[2])
+	if !ok || len(exit) == 0 {
+		Fail("bad syntax: do: expected (test expr ...): %s", syntaxToDatum(form[2]))
+	}
+	loop := introduce("loop")
+	var result scmer = makeList(introduce("quote"), symbol("#%void"))
+	if len(exit) > 1 {
+		result = &pair{introduce("begin"), makeList(exit[1:]...)}
+	}
+	commands := append(append([]scmer(nil), form[3:]...), &pair{loop, makeList(steps...)})
+	body := makeList(introduce("if"), exit[0], result, &pair{introduce("begin"), makeList(commands...)})
+	return expand(makeList(introduce("let"), loop, makeList(bindings...), body), e)
+}
+
+// expandCaseLambda expands (case-lambda (formals body ...) ...) as
+//
+//	(make-case-lambda '(case-lambda (formals body ...) ...)
+//	  (lambda formals body ...) ...)
+//
+// which makes a procedure that applies the first clause that accepts its
+// arguments, and prints as the case-lambda expression.
+func expandCaseLambda(form []scmer, e *senv) scmer {
+	if len(form) < 2 {
+		Fail("bad syntax: case-lambda requires a clause: %s", syntaxToDatum(makeList(form...)))
+	}
+	call := []scmer{caseLambdaMake, makeList(introduce("quote"), syntaxToDatum(makeList(form...)))}
+	for _, c := range form[1:] {
+		clause, ok := listToSlice(c)
+		if !ok || len(clause) < 2 {
+			Fail("bad syntax: case-lambda: bad clause: %s", syntaxToDatum(c))
+		}
+		call = append(call, lambdaForm(clause[0], clause[1:]))
+	}
+	return expand(makeList(call...), e)
+}
+
+// expandParameterize expands (parameterize ((param value) ...) body ...) as
+//
+//	(parameterize (lambda () body ...) param value ...)
+//
+// where parameterize is the control that binds the parameters while it calls
+// the thunk.
+func expandParameterize(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: parameterize requires bindings and a body: %s", syntaxToDatum(makeList(form...)))
+	}
+	bindings, ok := listToSlice(form[1])
+	if !ok {
+		Fail("bad syntax: parameterize: bindings must be a list: %s", form[1])
+	}
+	call := []scmer{parameterizeControl, lambdaForm(empty, form[2:])}
+	for _, b := range bindings {
+		spec, ok := listToSlice(b)
+		if !ok || len(spec) != 2 {
+			Fail("bad syntax: parameterize: bad binding: %s", syntaxToDatum(b))
+		}
+		call = append(call, spec...)
+	}
+	return expand(makeList(call...), e)
+}
+
+// expandDelay expands (delay expr) and (delay-force expr) into a call that
+// makes a promise from the thunk (lambda () expr).
+func expandDelay(form []scmer, e *senv) scmer {
+	if len(form) != 2 {
+		Fail("bad syntax: %s requires exactly 1 argument: %s", form[0], syntaxToDatum(makeList(form...)))
+	}
+	maker := delayPromise
+	if base(form[0]) == "delay-force" {
+		maker = delayForcePromise
+	}
+	return expand(makeList(maker, lambdaForm(empty, form[1:])), e)
+}
+
+// caseMemv and caseLambdaMake are the procedures that the expansions of case
+// and case-lambda call.
+var (
+	caseMemv = &primitive{"memv", arity{2, 2}, func(a ...scmer) scmer {
+		for p, ok := a[1].(*pair); ok; p, ok = p.cdr.(*pair) {
+			if eqv(a[0], p.car) {
+				return boolean(true)
+			}
+		}
+		return boolean(false)
+	}}
+	caseLambdaMake = &primitive{"case-lambda", arity{2, variadic}, func(a ...scmer) scmer {
+		p := &caseLambda{clauses: a[1:], written: a[0]}
+		p.arity, _ = arityOf(a[1])
+		for _, c := range a[2:] {
+			n, _ := arityOf(c)
+			if n.min < p.min {
+				p.min = n.min
+			}
+			if p.max != variadic && (n.max == variadic || n.max > p.max) {
+				p.max = n.max
+			}
+		}
+		return p
+	}}
+)
+
+// A caseLambda is a procedure made by case-lambda. Its arity is the union of
+// those of its clauses.
+type caseLambda struct {
+	clauses []scmer // procedures
+	written scmer   // the case-lambda expression as written, for printing
+	arity
+}
+
+func (x *caseLambda) String() string {
+	return x.written.String()
+}
+
+// clause returns the first clause of p that accepts args, or fails if none
+// does.
+func (p *caseLambda) clause(args []scmer) scmer {
+	p.check("case-lambda", args)
+	for _, c := range p.clauses {
+		if n, _ := arityOf(c); n.accepts(len(args)) {
+			return c
+		}
+	}
+	Fail("case-lambda: no clause accepts %d arguments: %s", len(args), makeList(args...))
+	return nil
+}
+
+// features lists the feature identifiers that cond-expand recognizes.
+var features = []symbol{"r7rs", "exact-closed", "exact-complex", "ratios", "full-unicode", "lisp"}
+
+var featurePrimitives = map[string]primitiveDef{
+	"features": {arity{0, 0}, func(a ...scmer) scmer {
+		list := make([]scmer, len(features))
+		for i, f := range features {
+			list[i] = f
+		}
+		return makeList(list...)
+	}},
+}
+
+// condExpansion returns the body of the first clause of the cond-expand form
+// whose feature requirement is met, or nil if none is.
+func condExpansion(form []scmer, e *senv) []scmer {
+	for i, c := range form[1:] {
+		clause, ok := listToSlice(c)
+		if !ok || len(clause) == 0 {
+			Fail("bad syntax: cond-expand: bad clause: %s", syntaxToDatum(c))
+		}
+		if isAuxiliary(clause[0], "else", e) {
+			if i < len(form)-2 {
+				Fail("bad syntax: cond-expand: else clause must be last: %s", syntaxToDatum(c))
+			}
+			return clause[1:]
+		} else if hasFeatures(clause[0]) {
+			return clause[1:]
+		}
+	}
+	return nil
+}
+
+// hasFeatures reports whether the feature requirement req is met. No library
+// is available, since there are none to import.
+func hasFeatures(req scmer) bool {
+	if isIdentifier(req) {
+		for _, f := range features {
+			if base(req) == f {
+				return true
+			}
+		}
+		return false
+	}
+	items, ok := listToSlice(req)
+	if ok && len(items) > 0 && isIdentifier(items[0]) {
+		switch base(items[0]) {
+		case "and":
+			for _, x := range items[1:] {
+				if !hasFeatures(x) {
+					return false
+				}
+			}
+			return true
+		case "or":
+			for _, x := range items[1:] {
+				if hasFeatures(x) {
+					return true
+				}
+			}
+			return false
+		case "not":
+			if len(items) == 2 {
+				return !hasFeatures(items[1])
+			}
+		case "library":
+			if len(items) == 2 {
+				return false
+			}
+		}
+	}
+	Fail("bad syntax: cond-expand: bad feature requirement: %s", syntaxToDatum(req))
+	panic("Fail didn't panic")
+}
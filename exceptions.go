@@ -0,0 +1,221 @@
+package lisp
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+/*
+ Exceptions
+*/
+
+// A handler is an exception handler installed by with-exception-handler.
+// The handlers in effect form a stack, linked through outer.
+type handler struct {
+	proc  scmer
+	outer *handler
+}
+
+// handlers is the stack of exception handlers currently in effect.
+var handlers *handler
+
+// An errorObject is a condition, as created by (error message irritant ...)
+// or by a primitive that fails. It is also the Go error that reports the
+// condition if nothing handles it.
+type errorObject struct {
+	message   scmer      // a string
+	irritants scmer      // a list
+	trace     *backtrace // where it was first raised, once it has been
+}
+
+func (x *errorObject) String() string {
+	var b strings.Builder
+	b.WriteString("#<error ")
+	b.WriteString(x.message.String())
+	for p, ok := x.irritants.(*pair); ok; p, ok = p.cdr.(*pair) {
+		b.WriteString(" ")
+		b.WriteString(p.car.String())
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+func (x *errorObject) Error() string {
+	var b strings.Builder
+	b.WriteString(display(x.message))
+	for p, ok := x.irritants.(*pair); ok; p, ok = p.cdr.(*pair) {
+		b.WriteString(" ")
+		b.WriteString(p.car.String())
+	}
+	return x.trace.annotate(b.String())
+}
+
+// An uncaught is panicked when an object is raised while no handler is in
+// effect, to carry it to the top level.
+type uncaught struct {
+	obj   scmer
+	trace *backtrace
+}
+
+func (x *uncaught) Error() string {
+	if e, ok := x.obj.(*errorObject); ok {
+		return e.Error()
+	}
+	return x.trace.annotate(fmt.Sprintf("uncaught exception: %s", x.obj))
+}
+
+// condition returns the condition that a Go panic with value r represents:
+// the error object passed to Fail, or one describing a Go runtime error. It
+// returns nil if r is neither.
+func condition(r interface{}) *errorObject {
+	switch x := r.(type) {
+	case *errorObject:
+		return x
+	case runtime.Error:
+		return &errorObject{makeStr(x.Error()), empty, nil}
+	}
+	return nil
+}
+
+// raise raises obj as an exception, by calling the current handler with the
+// handlers outside it in effect. If continuable is true, the handler's value
+// is returned to raise's continuation; otherwise the handler must not return.
+func (m *machine) raise(obj scmer, continuable bool) {
+	m.raiseFrom(obj, continuable, nil)
+}
+
+// raiseFrom is raise, for an object that was first raised with the backtrace
+// t, or that is being raised for the first time, if t is nil.
+func (m *machine) raiseFrom(obj scmer, continuable bool, t *backtrace) {
+	e, ok := obj.(*errorObject)
+	if ok && e.trace != nil {
+		t = e.trace
+	} else if t == nil {
+		t = m.backtrace()
+	}
+	if ok && e.trace == nil {
+		e.trace = t
+	}
+	h := handlers
+	if h == nil {
+		panic(&uncaught{obj, t})
+	}
+	m.push(&handlerReturnFrame{obj, continuable, h, t, m.k})
+	handlers = h.outer
+	m.apply(h.proc, []scmer{obj})
+}
+
+// handlerReturnFrame receives the value of a handler called by raise.
+type handlerReturnFrame struct {
+	obj         scmer
+	continuable bool
+	h           *handler   // the handler that was called
+	trace       *backtrace // where obj was raised
+	next        frame
+}
+
+func (f *handlerReturnFrame) resume(m *machine) {
+	m.k = f.next
+	if f.continuable {
+		handlers = f.h
+		m.ret(m.value)
+		return
+	}
+	// A secondary exception is raised in the handler's dynamic environment.
+	m.raise(&errorObject{
+		makeStr("handler returned from non-continuable exception:"),
+		makeList(f.obj),
+		nil,
+	}, false)
+}
+
+func (f *handlerReturnFrame) below() frame { return f.next }
+
+// handlersFrame restores the handler stack when the thunk of a
+// with-exception-handler returns.
+type handlersFrame struct {
+	handlers *handler
+	next     frame
+}
+
+func (f *handlersFrame) resume(m *machine) {
+	m.k = f.next
+	handlers = f.handlers
+	m.ret(m.value)
+}
+
+func (f *handlersFrame) below() frame { return f.next }
+
+// withExceptionHandler implements (with-exception-handler handler thunk).
+func withExceptionHandler(m *machine, a []scmer) {
+	m.push(&handlersFrame{handlers, m.k})
+	handlers = &handler{a[0], handlers}
+	m.apply(a[1], nil)
+}
+
+func raiseContinuable(m *machine, a []scmer) {
+	m.raise(a[0], true)
+}
+
+// guardReraise raises again the condition that a guard's handler was called
+// with, when none of its clauses applies, as having been raised where it
+// first was.
+func guardReraise(m *machine, a []scmer) {
+	for i := len(machines) - 1; i >= 0; i-- {
+		for k := machines[i].k; k != nil; k = k.below() {
+			if f, ok := k.(*handlerReturnFrame); ok {
+				m.raiseFrom(a[0], true, f.trace)
+				return
+			}
+		}
+	}
+	m.raise(a[0], true)
+}
+
+// exceptionControls are added to the global environment along with the
+// primitives.
+var exceptionControls = map[string]controlDef{
+	"with-exception-handler": {arity{2, 2}, withExceptionHandler},
+	"raise": {arity{1, 1}, func(m *machine, a []scmer) {
+		m.raise(a[0], false)
+	}},
+	"raise-continuable": {arity{1, 1}, raiseContinuable},
+	"error": {arity{1, variadic}, func(m *machine, a []scmer) {
+		if _, ok := a[0].(*str); !ok {
+			Fail("error: expected a string message, got %s", a[0])
+		}
+		m.raise(&errorObject{a[0], makeList(a[1:]...), nil}, false)
+	}},
+}
+
+var exceptionPrimitives = map[string]primitiveDef{
+	"error-object?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*errorObject)
+		return boolean(ok)
+	}},
+	"error-object-message": {arity{1, 1}, func(a ...scmer) scmer {
+		return asErrorObject("error-object-message", a[0]).message
+	}},
+	"error-object-irritants": {arity{1, 1}, func(a ...scmer) scmer {
+		return asErrorObject("error-object-irritants", a[0]).irritants
+	}},
+}
+
+// asErrorObject returns x as an error object, or fails on behalf of the named
+// procedure.
+func asErrorObject(who string, x scmer) *errorObject {
+	e, ok := x.(*errorObject)
+	if !ok {
+		Fail("%s: expected an error object, got %s", who, x)
+	}
+	return e
+}
+
+// The expansion of guard refers to these directly, rather than by name, so
+// that it works even where they have been redefined.
+var (
+	guardCallCC               = &control{"call/cc", arity{1, 1}, callCC}
+	guardWithExceptionHandler = &control{"with-exception-handler", arity{2, 2}, withExceptionHandler}
+	guardRaiseContinuable     = &control{"raise-continuable", arity{1, 1}, guardReraise}
+)
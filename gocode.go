@@ -0,0 +1,322 @@
+package lisp
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+ Compiled Go: the compiler
+
+ CompileGo translates a program into the Go source of a main package, which
+ uses the exported parts of this package (see native.go) as its runtime.
+ Each top-level expression becomes a function, each lambda expression a Go
+ function literal, and each variable a Go variable. Constants are read, and
+ global variables looked up, once, when the program starts.
+*/
+
+// CompileGo compiles the named source files into a Go program, written to w.
+func CompileGo(names []string, w io.Writer) (err error) {
+	defer recoverError(&err)
+	g := &goCompiler{globals: map[*global]string{}}
+	var forms []string
+	for _, name := range names {
+		nodes, err := analyzeFile(name)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			forms = append(forms, g.function(nil, n))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by LiSP -compile-go from %s. DO NOT EDIT.\n\n", strings.Join(names, ", "))
+	b.WriteString("package main\n\n")
+	b.WriteString("import lisp \"github.com/perlmonger42/LiSP\"\n\n")
+	b.WriteString("var (\n")
+	for _, decl := range g.decls {
+		b.WriteString(decl + "\n")
+	}
+	b.WriteString(")\n\n")
+	b.WriteString("func main() {\n\tlisp.Main(\n")
+	for _, form := range forms {
+		b.WriteString(form + ",\n")
+	}
+	b.WriteString(")\n}\n")
+
+	source, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return fmt.Errorf("compile-go: generated bad code: %v", err)
+	}
+	_, err = w.Write(source)
+	return err
+}
+
+// A goCompiler compiles analyzed expressions into Go.
+type goCompiler struct {
+	decls   []string           // the program's package-level variables
+	globals map[*global]string // the Go variable for each global variable
+	count   int                // the number of Go names made so far
+
+	b      *strings.Builder // the code of the function being compiled
+	scopes []*goScope       // the variables of the lambdas it is within
+}
+
+// A goScope holds the Go names of a lambda's variables.
+type goScope struct {
+	l     *lambda
+	names []string
+}
+
+// name returns a Go name, unique in the program, made from prefix and the
+// Scheme name s.
+func (g *goCompiler) name(prefix string, s symbol) string {
+	g.count++
+	name := fmt.Sprintf("%s%d", prefix, g.count)
+	if s != "" {
+		name += "_" + strings.Map(func(r rune) rune {
+			if r < 128 && (r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return r
+			}
+			return '_'
+		}, string(s))
+	}
+	return name
+}
+
+// constant returns the Go variable that holds the constant x.
+func (g *goCompiler) constant(x scmer) string {
+	var init string
+	switch x.(type) {
+	case nil:
+		init = "lisp.Value(nil)"
+	case *primitive, *control:
+		for i, y := range embedded {
+			if x == y {
+				init = fmt.Sprintf("lisp.Embedded(%d)", i)
+			}
+		}
+		if init == "" {
+			Fail("compile-go: cannot compile a constant of type %T: %s", x, x)
+		}
+	default:
+		init = fmt.Sprintf("lisp.Datum(%s)", strconv.Quote(x.String()))
+	}
+	c := g.name("c", "")
+	g.decls = append(g.decls, fmt.Sprintf("%s = %s", c, init))
+	return c
+}
+
+// global returns the Go variable that holds the global variable v.
+func (g *goCompiler) global(v *global) string {
+	name, ok := g.globals[v]
+	if !ok {
+		name = g.name("g", v.name)
+		g.globals[v] = name
+		g.decls = append(g.decls, fmt.Sprintf("%s = lisp.GlobalVariable(%s)", name, strconv.Quote(string(v.name))))
+	}
+	return name
+}
+
+func (g *goCompiler) emit(format string, a ...interface{}) {
+	fmt.Fprintf(g.b, format+"\n", a...)
+}
+
+// temp declares a new Go variable, and returns its name.
+func (g *goCompiler) temp() string {
+	t := g.name("t", "")
+	g.emit("var %s lisp.Value", t)
+	return t
+}
+
+// function returns a Go function literal that runs n, the body of the lambda
+// l (or of a top-level expression, if l is nil).
+func (g *goCompiler) function(l *lambda, n node) string {
+	saved := g.b
+	g.b = &strings.Builder{}
+	defer func() { g.b = saved }()
+
+	if l == nil {
+		g.emit("func() lisp.Value {")
+	} else {
+		g.emit("func(args []lisp.Value) lisp.Value {")
+		scope := &goScope{l, make([]string, len(l.names))}
+		for i, name := range l.names {
+			scope.names[i] = g.name("v", name)
+			switch {
+			case i < l.arity:
+				g.emit("%s := args[%d]", scope.names[i], i)
+			case i == l.arity && l.rest:
+				g.emit("%s := lisp.List(args[%d:]...)", scope.names[i], i)
+			default:
+				g.emit("var %s lisp.Value", scope.names[i])
+			}
+			g.emit("_ = %s", scope.names[i])
+		}
+		g.scopes = append(g.scopes, scope)
+		defer func() { g.scopes = g.scopes[:len(g.scopes)-1] }()
+	}
+	g.tail(n)
+	g.b.WriteString("}")
+	return g.b.String()
+}
+
+// local returns the Go name of the local variable r refers to, and whether it
+// is a parameter.
+func (g *goCompiler) local(r *localRef) (string, bool) {
+	scope := g.scopes[len(g.scopes)-1-r.depth]
+	isParam := r.index < scope.l.arity || r.index == scope.l.arity && scope.l.rest
+	return scope.names[r.index], isParam
+}
+
+// simple reports whether n has no effects, and can be computed by a Go
+// expression without statements.
+func simple(n node) bool {
+	switch n.(type) {
+	case *constant, *localRef, *globalRef, *lambda:
+		return true
+	}
+	return false
+}
+
+// fixed reports whether the value of n is the same whenever it is computed.
+func fixed(n node) bool {
+	switch n.(type) {
+	case *constant, *lambda:
+		return true
+	}
+	return false
+}
+
+// value returns a Go expression for the value of n, emitting any statements
+// that must come before it.
+func (g *goCompiler) value(n node) string {
+	switch n := n.(type) {
+	case *constant:
+		return g.constant(n.value)
+	case *localRef:
+		name, isParam := g.local(n)
+		if isParam {
+			return name
+		}
+		return fmt.Sprintf("lisp.Defined(%s, %s)", name, strconv.Quote(string(n.name)))
+	case *globalRef:
+		return g.global(n.g) + ".Get()"
+	case *lambda:
+		text := n.written.String()
+		a := lambdaArity(n.arity, n.rest)
+		return fmt.Sprintf("lisp.Lambda(%s, %s, %d, %d, %s)", strconv.Quote(text),
+			strconv.Quote(procName(n.name, n.params)), a.min, a.max, g.function(n, n.code))
+	case *application:
+		return g.call(n, "lisp.Call", "lisp.Apply")
+	}
+	t := g.temp()
+	g.assign(n, t)
+	return t
+}
+
+// call returns a Go expression that makes the application n, by calling the
+// named runtime functions.
+func (g *goCompiler) call(n *application, call, apply string) string {
+	args := make([]string, len(n.parts))
+	for i, part := range n.parts {
+		args[i] = g.value(part)
+		// The operands are evaluated in order, so a variable's value must
+		// be taken before anything later can change it.
+		if !simple(part) || fixed(part) || i == len(n.parts)-1 {
+			continue
+		}
+		for _, later := range n.parts[i+1:] {
+			if !simple(later) {
+				t := g.name("t", "")
+				g.emit("%s := %s", t, args[i])
+				args[i] = t
+				break
+			}
+		}
+	}
+	if n.apply && len(args) == 2 {
+		return fmt.Sprintf("%s(%s)", apply, strings.Join(args, ", "))
+	} else if n.apply {
+		// Let the apply control complain about the arguments.
+		args = append([]string{g.constant(applyControl)}, args...)
+	}
+	return fmt.Sprintf("%s(%s)", call, strings.Join(args, ", "))
+}
+
+// assign emits statements that store the value of n in the Go variable dest.
+func (g *goCompiler) assign(n node, dest string) {
+	switch n := n.(type) {
+	case *ifNode:
+		g.emit("if lisp.IsTrue(%s) {", g.value(n.test))
+		g.assign(n.consequent, dest)
+		g.emit("} else {")
+		g.assign(n.alternative, dest)
+		g.emit("}")
+	case *sequence:
+		for _, x := range n.body[:len(n.body)-1] {
+			g.assign(x, "_")
+		}
+		g.assign(n.body[len(n.body)-1], dest)
+	case *assignment, *definition:
+		result := g.effect(n)
+		if dest != "_" {
+			g.emit("%s = %s", dest, result)
+		}
+	case *constant:
+		if dest != "_" {
+			g.emit("%s = %s", dest, g.value(n))
+		}
+	default:
+		g.emit("%s = %s", dest, g.value(n))
+	}
+}
+
+// effect emits statements that make the assignment or definition n, and
+// returns a Go expression for its result.
+func (g *goCompiler) effect(n node) string {
+	switch n := n.(type) {
+	case *assignment:
+		value := g.value(n.value)
+		switch t := n.target.(type) {
+		case *localRef:
+			name, _ := g.local(t)
+			g.emit("%s = %s", name, value)
+		case *globalRef:
+			g.emit("%s.Set(%s)", g.global(t.g), value)
+		}
+		return g.constant(n.result)
+	case *definition:
+		g.emit("%s.Define(%s)", g.global(n.g), g.value(n.value))
+		return g.constant(n.result)
+	}
+	panic(fmt.Sprintf("compile-go: not an assignment or definition: %T", n))
+}
+
+// tail emits statements that return the value of n, which is in tail
+// position.
+func (g *goCompiler) tail(n node) {
+	switch n := n.(type) {
+	case *ifNode:
+		g.emit("if lisp.IsTrue(%s) {", g.value(n.test))
+		g.tail(n.consequent)
+		g.emit("} else {")
+		g.tail(n.alternative)
+		g.emit("}")
+	case *sequence:
+		for _, x := range n.body[:len(n.body)-1] {
+			g.assign(x, "_")
+		}
+		g.tail(n.body[len(n.body)-1])
+	case *application:
+		g.emit("return %s", g.call(n, "lisp.TailCall", "lisp.TailApply"))
+	case *assignment, *definition:
+		g.emit("return %s", g.effect(n))
+	default:
+		g.emit("return %s", g.value(n))
+	}
+}
@@ -0,0 +1,155 @@
+package lisp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCompileGo compiles a program to Go, builds it with the go command, and
+// checks what the binary prints.
+func TestCompileGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go build in short mode")
+	}
+	dir := t.TempDir()
+	source := `
+		(define (loop n acc) (if (< n 1) acc (loop (- n 1) (+ acc 1))))
+		(loop 1000000 0)
+		(define (make-counter)
+		  (begin
+		    (define count 0)
+		    (lambda () (begin (set! count (+ count 1)) count))))
+		(define tick (make-counter))
+		(begin (tick) (tick))
+		(define x 5)
+		(set! x (+ x 1))
+		(apply list (cons x '(7 8)))
+		` + "`(1 ,x ,@(list 2 3))" + `
+		` + "`#(,x #u8(1))" + `
+		(guard (e ((eq? e 'oops) (list 'caught e))) (raise 'oops))
+		(call/cc (lambda (k) (+ 1 (k 42))))
+		((lambda (a . b) b) 1 2 3)
+		((case-lambda ((a) a) ((a b) (list b a))) 1 2)
+		(lambda (y) y)
+		(define k2 #f)
+		(define n 0)
+		(begin (call/cc (lambda (k) (set! k2 k))) (set! n (+ n 1)) n)
+		(guard (e ((error-object? e) (error-object-message e))) (k2 'again))
+		n
+		(car '())`
+	if err := os.WriteFile(filepath.Join(dir, "prog.scm"), []byte(source), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "prog.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CompileGo([]string{filepath.Join(dir, "prog.scm")}, f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The program is built in a module of its own, which holds a copy of
+	// this package, so that it needs neither a go.mod here nor the network.
+	if err := copyPackage(".", filepath.Join(dir, "lisp")); err != nil {
+		t.Fatal(err)
+	}
+	mod := "module prog\n\nrequire github.com/perlmonger42/LiSP v0.0.0\n\n" +
+		"replace github.com/perlmonger42/LiSP => ./lisp\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0666); err != nil {
+		t.Fatal(err)
+	}
+	build := exec.Command("go", "build", "-o", "prog")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off", "GOPROXY=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	run := exec.Command(filepath.Join(dir, "prog"))
+	out, err := run.Output()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("wanted the program to fail at (car '()), got %v", err)
+	}
+	want := `(#%undef define loop)
+1000000
+(#%undef define make-counter)
+(#%undef define tick)
+2
+(#%undef define x)
+#%set!
+(6 7 8)
+(1 6 2 3)
+#(6 #u8(1))
+(caught oops)
+42
+(2 3)
+(2 1)
+(lambda (y) y)
+(#%undef define k2)
+(#%undef define n)
+1
+"continuation no longer active"
+1
+`
+	if string(out) != want {
+		t.Errorf("wanted output\n%s\ngot\n%s", want, out)
+	}
+}
+
+// copyPackage copies the Go source of this package, and of the packages within
+// it that it imports, other than tests, from the directory from into a new
+// module in the directory to.
+func copyPackage(from, to string) error {
+	for _, pkg := range []string{".", "scan"} {
+		names, err := filepath.Glob(filepath.Join(from, pkg, "*.go"))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(to, pkg), 0777); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			text, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(to, pkg, filepath.Base(name)), text, 0666); err != nil {
+				return err
+			}
+		}
+	}
+	mod := "module github.com/perlmonger42/LiSP\n\ngo 1.20\n"
+	return os.WriteFile(filepath.Join(to, "go.mod"), []byte(mod), 0666)
+}
+
+// TestNativeTailCall checks that tail calls between compiled procedures run
+// in constant space.
+func TestNativeTailCall(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 10^7-iteration loop in short mode")
+	}
+	var ev, od Value
+	ev = Lambda("ev?", "ev?", 1, 1, func(args []Value) Value {
+		if args[0] == fixnum(0) {
+			return boolean(true)
+		}
+		return TailCall(od, args[0].(fixnum)-1)
+	})
+	od = Lambda("od?", "od?", 1, 1, func(args []Value) Value {
+		if args[0] == fixnum(0) {
+			return boolean(false)
+		}
+		return TailCall(ev, args[0].(fixnum)-1)
+	})
+	if got := Call(ev, fixnum(tailCallIterations)); got != boolean(true) {
+		t.Errorf("wanted #t, got %s", got)
+	}
+}
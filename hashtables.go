@@ -0,0 +1,449 @@
+package lisp
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"strings"
+)
+
+/*
+ Hash tables
+
+ Hash tables follow SRFI 69. A table is keyed by an equivalence predicate
+ and a hash function that agrees with it: two keys that are equivalent must
+ hash alike. Tables keyed by eq?, eqv?, equal?, string=? or string-ci=? hash
+ their keys in Go, and need no hash function; any other equivalence can be
+ given one, or uses hash, which agrees with equal?.
+
+ Entries are kept in the order they were added, so that walking a table, or
+ listing its keys, gives the same order each time.
+*/
+
+type hashTable struct {
+	equiv scmer // the equivalence predicate
+	hash  scmer // the hash function, or nil if it is built in
+
+	key     func(x scmer) interface{} // the Go map key that x hashes to
+	same    func(x, y scmer) bool     // whether keys x and y are equivalent
+	buckets map[interface{}][]*entry
+
+	first, last *entry // the entries, in the order they were added
+	count       int
+}
+
+// An entry is a key and its value, linked to the entries before and after it.
+type entry struct {
+	key, value scmer
+	prev, next *entry
+}
+
+func (x *hashTable) String() string {
+	return "#<hash-table>"
+}
+
+// newHashTable returns an empty table keyed by the equivalence predicate
+// equiv and the hash function hash, which is nil if not given.
+func newHashTable(equiv, hash scmer) *hashTable {
+	t := &hashTable{equiv: equiv, hash: hash, buckets: map[interface{}][]*entry{}}
+	t.same = func(x, y scmer) bool { return IsTrue(apply(equiv, []scmer{x, y})) }
+	t.key = func(x scmer) interface{} { return equalHash(x) }
+	if p, ok := equiv.(*primitive); ok {
+		switch p.name {
+		case "eq?":
+			t.same = func(x, y scmer) bool { return x == y }
+			t.key = func(x scmer) interface{} { return x }
+		case "eqv?":
+			t.same = eqv
+			t.key = eqvKey
+		case "equal?", "string=?":
+			t.same = equal
+		case "string-ci=?":
+			t.key = func(x scmer) interface{} {
+				return strings.Map(foldRune, asStr("hash-table", x).text())
+			}
+		}
+	}
+	if hash != nil {
+		t.key = func(x scmer) interface{} {
+			h := apply(hash, []scmer{x})
+			if n, ok := h.(fixnum); ok && n >= 0 {
+				return n
+			} else if n, ok := h.(*bignum); ok && n.String()[0] != '-' {
+				return n.String()
+			}
+			Fail("hash-table: hash function returned %s, not an exact non-negative integer", h)
+			return nil
+		}
+	}
+	return t
+}
+
+// lookup returns the entry for key, or nil if there is none.
+func (t *hashTable) lookup(key scmer) *entry {
+	for _, e := range t.buckets[t.key(key)] {
+		if t.same(e.key, key) {
+			return e
+		}
+	}
+	return nil
+}
+
+// set makes value the value of key.
+func (t *hashTable) set(key, value scmer) {
+	if e := t.lookup(key); e != nil {
+		e.value = value
+		return
+	}
+	e := &entry{key: key, value: value, prev: t.last}
+	if t.last == nil {
+		t.first = e
+	} else {
+		t.last.next = e
+	}
+	t.last = e
+	k := t.key(key)
+	t.buckets[k] = append(t.buckets[k], e)
+	t.count++
+}
+
+// delete removes the entry for key, if there is one.
+func (t *hashTable) delete(key scmer) {
+	k := t.key(key)
+	bucket := t.buckets[k]
+	for i, e := range bucket {
+		if !t.same(e.key, key) {
+			continue
+		}
+		if len(bucket) == 1 {
+			delete(t.buckets, k)
+		} else {
+			t.buckets[k] = append(bucket[:i:i], bucket[i+1:]...)
+		}
+		if e.prev == nil {
+			t.first = e.next
+		} else {
+			e.prev.next = e.next
+		}
+		if e.next == nil {
+			t.last = e.prev
+		} else {
+			e.next.prev = e.prev
+		}
+		t.count--
+		return
+	}
+}
+
+// entries returns the entries of t, in order. Procedures that call back into
+// Scheme walk this copy, so that the callbacks may change t.
+func (t *hashTable) entries() []*entry {
+	entries := make([]*entry, 0, t.count)
+	for e := t.first; e != nil; e = e.next {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// copy returns a new table with the same keying and entries as t.
+func (t *hashTable) copy() *hashTable {
+	c := newHashTable(t.equiv, t.hash)
+	for e := t.first; e != nil; e = e.next {
+		c.set(e.key, e.value)
+	}
+	return c
+}
+
+// A numberKey is the Go map key of a number that is not a fixnum or flonum,
+// whose eqv? numbers are not == in Go.
+type numberKey string
+
+// eqvKey returns a Go map key for x, the same for any keys that are eqv?.
+func eqvKey(x scmer) interface{} {
+	switch x := x.(type) {
+	case fixnum:
+		return x
+	case flonum:
+		if x == 0 {
+			return flonum(0) // eqv? to -0.0
+		}
+		return x
+	}
+	if isNumber(x) {
+		return numberKey(x.String())
+	}
+	return x
+}
+
+// hashBudget is the most parts of a value that equalHash looks at, so that it
+// finishes even on a cyclic list or vector.
+const hashBudget = 64
+
+// equalHash returns a hash of x, the same for any values that are equal?.
+func equalHash(x scmer) uint64 {
+	h := fnv.New64a()
+	budget := hashBudget
+	writeHash(h, x, &budget)
+	return h.Sum64()
+}
+
+// writeHash writes the parts of x that equal? compares to h, until the
+// budget runs out.
+func writeHash(h hash.Hash64, x scmer, budget *int) {
+	if *budget <= 0 {
+		return
+	}
+	*budget--
+	switch x := x.(type) {
+	case *pair:
+		io.WriteString(h, "(")
+		writeHash(h, x.car, budget)
+		writeHash(h, x.cdr, budget)
+	case *vector:
+		io.WriteString(h, "#(")
+		for _, item := range x.items {
+			writeHash(h, item, budget)
+		}
+	case *bytevector:
+		io.WriteString(h, "#u8(")
+		h.Write(x.bytes)
+	case *str:
+		io.WriteString(h, `"`)
+		io.WriteString(h, x.text())
+	case nil:
+		io.WriteString(h, "nil")
+	case fixnum, flonum, symbol, char, boolean, emptyList:
+		fmt.Fprintf(h, "%T %s", x, eqvKey(x))
+	default:
+		if isNumber(x) {
+			fmt.Fprintf(h, "%T %s", x, x)
+		} else {
+			// Anything else is equal? only to itself.
+			fmt.Fprintf(h, "%T %p", x, x)
+		}
+	}
+}
+
+// makeHashTable implements (make-hash-table [equiv [hash]]). The equivalence
+// is equal? by default.
+func makeHashTable(a ...scmer) scmer {
+	var equiv scmer = &primitive{"equal?", arity{2, 2}, func(a ...scmer) scmer {
+		return boolean(equal(a[0], a[1]))
+	}}
+	var hash scmer
+	if len(a) > 0 {
+		equiv = a[0]
+	}
+	if len(a) > 1 {
+		hash = a[1]
+	}
+	return newHashTable(equiv, hash)
+}
+
+// asHashTable returns x as a hash table, or fails on behalf of the named
+// procedure.
+func asHashTable(who string, x scmer) *hashTable {
+	t, ok := x.(*hashTable)
+	if !ok {
+		Fail("%s: expected a hash table, got %s", who, x)
+	}
+	return t
+}
+
+// hashPrimitive returns a primitive that hashes its argument with h, to a
+// fixnum less than the optional bound.
+func hashPrimitive(who string, h func(x scmer) uint64) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		n := h(a[0]) >> 2
+		if len(a) > 1 {
+			bound, ok := a[1].(fixnum)
+			if !ok || bound <= 0 {
+				Fail("%s: expected a positive bound, got %s", who, a[1])
+			}
+			n %= uint64(bound)
+		}
+		return fixnum(n)
+	}
+}
+
+var hashTablePrimitives = map[string]primitiveDef{
+	"make-hash-table": {arity{0, 2}, makeHashTable},
+	"hash-table?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*hashTable)
+		return boolean(ok)
+	}},
+	"alist->hash-table": {arity{1, 3}, func(a ...scmer) scmer {
+		alist, ok := listToSlice(a[0])
+		if !ok {
+			Fail("alist->hash-table: expected an association list, got %s", a[0])
+		}
+		t := makeHashTable(a[1:]...).(*hashTable)
+		for _, x := range alist {
+			p := asPair("alist->hash-table", x)
+			// The first association for a key takes precedence.
+			if t.lookup(p.car) == nil {
+				t.set(p.car, p.cdr)
+			}
+		}
+		return t
+	}},
+	"hash-table-equivalence-function": {arity{1, 1}, func(a ...scmer) scmer {
+		return asHashTable("hash-table-equivalence-function", a[0]).equiv
+	}},
+	"hash-table-hash-function": {arity{1, 1}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-hash-function", a[0])
+		if t.hash != nil {
+			return t.hash
+		}
+		if p, ok := t.equiv.(*primitive); ok {
+			switch p.name {
+			case "eq?", "eqv?":
+				return &primitive{"hash-by-identity", arity{1, 2}, hashPrimitive("hash-by-identity", identityHash)}
+			case "string-ci=?":
+				return &primitive{"string-ci-hash", arity{1, 2}, hashPrimitive("string-ci-hash", stringCIHash)}
+			}
+		}
+		return &primitive{"hash", arity{1, 2}, hashPrimitive("hash", equalHash)}
+	}},
+	"hash-table-ref": {arity{2, 4}, func(a ...scmer) scmer {
+		e := asHashTable("hash-table-ref", a[0]).lookup(a[1])
+		switch {
+		case e != nil && len(a) > 3:
+			return apply(a[3], []scmer{e.value})
+		case e != nil:
+			return e.value
+		case len(a) > 2:
+			return apply(a[2], nil)
+		}
+		Fail("hash-table-ref: no value for key %s", a[1])
+		return nil
+	}},
+	"hash-table-ref/default": {arity{3, 3}, func(a ...scmer) scmer {
+		if e := asHashTable("hash-table-ref/default", a[0]).lookup(a[1]); e != nil {
+			return e.value
+		}
+		return a[2]
+	}},
+	"hash-table-set!": {arity{3, 3}, func(a ...scmer) scmer {
+		asHashTable("hash-table-set!", a[0]).set(a[1], a[2])
+		return symbol("#%set!")
+	}},
+	"hash-table-delete!": {arity{2, 2}, func(a ...scmer) scmer {
+		asHashTable("hash-table-delete!", a[0]).delete(a[1])
+		return symbol("#%set!")
+	}},
+	"hash-table-exists?": {arity{2, 2}, func(a ...scmer) scmer {
+		return boolean(asHashTable("hash-table-exists?", a[0]).lookup(a[1]) != nil)
+	}},
+	"hash-table-contains?": {arity{2, 2}, func(a ...scmer) scmer {
+		return boolean(asHashTable("hash-table-contains?", a[0]).lookup(a[1]) != nil)
+	}},
+	"hash-table-update!": {arity{3, 4}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-update!", a[0])
+		var value scmer
+		if e := t.lookup(a[1]); e != nil {
+			value = e.value
+		} else if len(a) > 3 {
+			value = apply(a[3], nil)
+		} else {
+			Fail("hash-table-update!: no value for key %s", a[1])
+		}
+		t.set(a[1], apply(a[2], []scmer{value}))
+		return symbol("#%set!")
+	}},
+	"hash-table-update!/default": {arity{4, 4}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-update!/default", a[0])
+		value := a[3]
+		if e := t.lookup(a[1]); e != nil {
+			value = e.value
+		}
+		t.set(a[1], apply(a[2], []scmer{value}))
+		return symbol("#%set!")
+	}},
+	"hash-table-count": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(asHashTable("hash-table-count", a[0]).count)
+	}},
+	"hash-table-size": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(asHashTable("hash-table-size", a[0]).count)
+	}},
+	"hash-table-keys": {arity{1, 1}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-keys", a[0])
+		keys := make([]scmer, 0, t.count)
+		for e := t.first; e != nil; e = e.next {
+			keys = append(keys, e.key)
+		}
+		return makeList(keys...)
+	}},
+	"hash-table-values": {arity{1, 1}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-values", a[0])
+		values := make([]scmer, 0, t.count)
+		for e := t.first; e != nil; e = e.next {
+			values = append(values, e.value)
+		}
+		return makeList(values...)
+	}},
+	"hash-table->alist": {arity{1, 1}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table->alist", a[0])
+		alist := make([]scmer, 0, t.count)
+		for e := t.first; e != nil; e = e.next {
+			alist = append(alist, &pair{e.key, e.value})
+		}
+		return makeList(alist...)
+	}},
+	"hash-table-walk": {arity{2, 2}, func(a ...scmer) scmer {
+		for _, e := range asHashTable("hash-table-walk", a[0]).entries() {
+			apply(a[1], []scmer{e.key, e.value})
+		}
+		return symbol("#%void")
+	}},
+	"hash-table-fold": {arity{3, 3}, func(a ...scmer) scmer {
+		acc := a[2]
+		for _, e := range asHashTable("hash-table-fold", a[0]).entries() {
+			acc = apply(a[1], []scmer{e.key, e.value, acc})
+		}
+		return acc
+	}},
+	"hash-table-copy": {arity{1, 2}, func(a ...scmer) scmer {
+		return asHashTable("hash-table-copy", a[0]).copy()
+	}},
+	"hash-table-merge!": {arity{2, 2}, func(a ...scmer) scmer {
+		t := asHashTable("hash-table-merge!", a[0])
+		for _, e := range asHashTable("hash-table-merge!", a[1]).entries() {
+			if t.lookup(e.key) == nil {
+				t.set(e.key, e.value)
+			}
+		}
+		return t
+	}},
+	"hash":             {arity{1, 2}, hashPrimitive("hash", equalHash)},
+	"string-hash":      {arity{1, 2}, hashPrimitive("string-hash", stringHash)},
+	"string-ci-hash":   {arity{1, 2}, hashPrimitive("string-ci-hash", stringCIHash)},
+	"hash-by-identity": {arity{1, 2}, hashPrimitive("hash-by-identity", identityHash)},
+}
+
+// stringHash returns a hash of the string x, the same for any strings that are
+// string=?.
+func stringHash(x scmer) uint64 {
+	return equalHash(asStr("string-hash", x))
+}
+
+// stringCIHash returns a hash of the string x, the same for any strings that
+// are string-ci=?.
+func stringCIHash(x scmer) uint64 {
+	return equalHash(makeStr(strings.Map(foldRune, asStr("string-ci-hash", x).text())))
+}
+
+// identityHash returns a hash of x, the same for any values that are eqv?.
+func identityHash(x scmer) uint64 {
+	h := fnv.New64a()
+	switch k := eqvKey(x).(type) {
+	case numberKey:
+		io.WriteString(h, string(k))
+	case fixnum, flonum, symbol, char, boolean, emptyList:
+		fmt.Fprintf(h, "%T %s", k, k)
+	default:
+		fmt.Fprintf(h, "%T %p", k, k)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,261 @@
+package lisp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+// TestTranscripts runs each test/*_test.scm file through Rercl, as if by
+// `LiSP -test file`.
+func TestTranscripts(t *testing.T) {
+	runTranscripts(t)
+}
+
+// TestTranscriptsVM runs the transcripts again, as if by `LiSP -vm -test file`.
+func TestTranscriptsVM(t *testing.T) {
+	UseVM = true
+	defer func() { UseVM = false }()
+	runTranscripts(t)
+}
+
+func runTranscripts(t *testing.T) {
+	files, err := filepath.Glob("test/*_test.scm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			scanner := scan.NewScanner(name, bufio.NewReader(f))
+			if err := Rercl(scanner, false); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// evalString reads and evaluates each datum in input, returning the value of
+// the last one.
+func evalString(t testing.TB, input string) (value scmer) {
+	t.Helper()
+	scanner := scan.NewScanner("<string>", strings.NewReader(input))
+	for {
+		_, v, err := ReadEval(scanner)
+		if err == io.EOF {
+			return value
+		} else if err != nil {
+			t.Fatalf("evaluating %q: %v", input, err)
+		}
+		value = v
+	}
+}
+
+// tailCallIterations is big enough that the loops below would exhaust the Go
+// stack if calls in tail position were not evaluated in constant space.
+const tailCallIterations = 10000000
+
+func TestTailCallSelfRecursion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 10^7-iteration loop in short mode")
+	}
+	got := evalString(t, `
+		(define (loop n acc) (if (< n 1) acc (loop (- n 1) (+ acc 1))))
+		(loop 10000000 0)`)
+	if got != fixnum(tailCallIterations) {
+		t.Errorf("wanted %d, got %s", tailCallIterations, got)
+	}
+}
+
+func TestTailCallMutualRecursion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 10^7-iteration loop in short mode")
+	}
+	got := evalString(t, `
+		(define (ev? n) (if (< n 1) #t (od? (- n 1))))
+		(define (od? n) (if (< n 1) #f (ev? (- n 1))))
+		(ev? 10000000)`)
+	if got != boolean(true) {
+		t.Errorf("wanted #t, got %s", got)
+	}
+}
+
+func TestTailCallThroughBeginAndApply(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 10^7-iteration loop in short mode")
+	}
+	got := evalString(t, `
+		(define (count n)
+		  (begin
+		    n
+		    (if (< n 1) (quote done) (apply count (cons (- n 1) null)))))
+		(count 10000000)`)
+	if got != symbol("done") {
+		t.Errorf("wanted done, got %s", got)
+	}
+}
+
+func TestTailCallVM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 10^7-iteration loop in short mode")
+	}
+	UseVM = true
+	defer func() { UseVM = false }()
+	got := evalString(t, `
+		(define (ev? n) (if (< n 1) #t (od? (- n 1))))
+		(define (od? n) (if (< n 1) #f (ev? (- n 1))))
+		(ev? 10000000)`)
+	if got != boolean(true) {
+		t.Errorf("wanted #t, got %s", got)
+	}
+}
+
+// TestEscapeFromNestedMachine checks that a continuation captured outside a Go
+// primitive can be invoked from Scheme code called back by that primitive.
+func TestEscapeFromNestedMachine(t *testing.T) {
+	globalVariable("call-twice").value = &primitive{"call-twice", arity{1, 1}, func(a ...scmer) scmer {
+		apply(a[0], nil)
+		return apply(a[0], nil)
+	}}
+	defer func() { globalVariable("call-twice").value = nil }()
+
+	got := evalString(t, `
+		(define calls null)
+		(+ 1 (call/cc (lambda (k)
+		  (call-twice (lambda () (begin (set! calls (cons 1 calls)) (k 41)))))))`)
+	if got != fixnum(42) {
+		t.Errorf("wanted 42, got %s", got)
+	}
+	if calls := evalString(t, "calls"); length(calls) != 1 {
+		t.Errorf("wanted the callback to run once, got %s", calls)
+	}
+}
+
+// TestGuardAroundNestedMachine checks that an error raised by Scheme code
+// called back by a Go primitive can be caught by a guard outside it.
+func TestGuardAroundNestedMachine(t *testing.T) {
+	globalVariable("call-once").value = &primitive{"call-once", arity{1, 1}, func(a ...scmer) scmer {
+		return apply(a[0], nil)
+	}}
+	defer func() { globalVariable("call-once").value = nil }()
+
+	got := evalString(t, `
+		(guard (e ((error-object? e) (error-object-irritants e)))
+		  (call-once (lambda () (error "failed:" 42))))`)
+	if !equal(got, makeList(fixnum(42))) {
+		t.Errorf("wanted (42), got %s", got)
+	}
+	if handlers != nil || len(machines) != 0 {
+		t.Errorf("wanted no handlers or machines left, got %v and %d", handlers, len(machines))
+	}
+}
+
+// TestErrorLocation checks that an uncaught error is reported with where it
+// was raised, and the calls that were in progress, by both the interpreter
+// and the virtual machine.
+func TestErrorLocation(t *testing.T) {
+	const source = `(define (inner x)
+  (car x))
+(define (middle x)
+  (+ 1 (inner x)))
+(list (middle 5))`
+	const want = `<string>:2:3: car: expected a pair, got 5
+  in inner, called at <string>:4:8
+  in middle, called at <string>:5:7`
+	defer func() { UseVM = false }()
+	for _, vm := range []bool{false, true} {
+		UseVM = vm
+		scanner := scan.NewScanner("<string>", strings.NewReader(source))
+		var err error
+		for err == nil {
+			_, _, err = ReadEval(scanner)
+		}
+		if err.Error() != want {
+			t.Errorf("vm=%t: wanted error %q, got %q", vm, want, err)
+		}
+	}
+	UseVM = false
+
+	got := evalString(t, `(guard (e (#t (error-object-message e))) (car 5))`)
+	if want := "car: expected a pair, got 5"; got.(*str).text() != want {
+		t.Errorf("wanted message %q, got %s", want, got)
+	}
+}
+
+// TestGuardReraiseLocation checks that an error that a guard does not handle
+// is reported where it was raised, without the procedures that the guard
+// expands into.
+func TestGuardReraiseLocation(t *testing.T) {
+	const source = `(define (inner x)
+  (raise x))
+(define (outer x)
+  (guard (e ((string? e) e))
+    (+ 1 (inner x))))
+(outer 'boom)`
+	const want = `<string>:2:3: uncaught exception: boom
+  in inner, called at <string>:5:10
+  in outer, called at <string>:6:1`
+	defer func() { UseVM = false }()
+	for _, vm := range []bool{false, true} {
+		UseVM = vm
+		scanner := scan.NewScanner("<string>", strings.NewReader(source))
+		var err error
+		for err == nil {
+			_, _, err = ReadEval(scanner)
+		}
+		if err.Error() != want {
+			t.Errorf("vm=%t: wanted error %q, got %q", vm, want, err)
+		}
+	}
+}
+
+// TestLocationsForgotten checks that the locations of a top-level form, and
+// the sources of its lambda expressions, are not kept once it has been
+// evaluated or compiled, whether or not that succeeded.
+func TestLocationsForgotten(t *testing.T) {
+	const source = `(define (f x) (let ((y (* x 2))) (list y '(a (b)) '#0=(c . #0#))))
+(f 1)
+(lambda (x x) x)
+(let loop ((i 0)) (if (< i 3) (loop (+ i 1)) (lambda () i)))
+(f (g`
+	kept := len(locations)
+	check := func(what string) {
+		t.Helper()
+		if n := len(locations) - kept; n != 0 || len(sources) != 0 || len(expanded) != 0 {
+			t.Errorf("%s: %d locations, %d sources and %d expansions kept",
+				what, n, len(sources), len(expanded))
+		}
+	}
+	defer func() { UseVM = false }()
+	for _, vm := range []bool{false, true} {
+		UseVM = vm
+		scanner := scan.NewScanner("<string>", strings.NewReader(source))
+		for {
+			if _, _, err := ReadEval(scanner); err == io.EOF {
+				break
+			}
+		}
+		check(map[bool]string{false: "interpreting", true: "executing"}[vm])
+	}
+	UseVM = false
+
+	scanner := scan.NewScanner("<string>", strings.NewReader(source))
+	for {
+		datum, err := read(scanner)
+		if err == io.EOF {
+			break
+		} else if err == nil {
+			analyzeTopLevel(datum)
+		}
+	}
+	check("compiling")
+}
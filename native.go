@@ -0,0 +1,195 @@
+package lisp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+/*
+ Compiled Go: the runtime
+
+ A program compiled to Go (see gocode.go) is a main package that uses what is
+ exported here as its runtime. Each lambda expression becomes a Go function
+ literal, and its variables Go variables, so closures are Go closures. A call
+ in tail position returns a tail call to the trampoline in Call instead of
+ making it, so that a chain of tail calls runs in constant space.
+
+ Compiled code runs on the Go stack rather than on a machine, so a
+ continuation captured in it can only be used to escape from the call/cc that
+ captured it, as guard does. Once that call/cc has returned, invoking the
+ continuation is an error.
+*/
+
+// compiled is true while a compiled program is running.
+var compiled bool
+
+// A Value is any Scheme value.
+type Value = scmer
+
+// A Global is a global variable.
+type Global = global
+
+// A native is a procedure compiled to Go.
+type native struct {
+	text string // the lambda expression, for printing
+	name string // its name, for errors
+	arity
+	f func(args []scmer) scmer
+}
+
+func (x *native) String() string {
+	return x.text
+}
+
+// A tailCall is what a native procedure returns in place of the value of a
+// call in tail position.
+type tailCall struct {
+	f    scmer
+	args []scmer
+}
+
+func (x *tailCall) String() string {
+	return fmt.Sprintf("#<tail call %s>", x.f)
+}
+
+// Lambda returns a procedure whose code is f, which takes from min to max
+// arguments (any number from min, if max is negative). It prints as text, the
+// lambda expression it was compiled from, and its errors call it name.
+func Lambda(text, name string, min, max int, f func(args []Value) Value) Value {
+	if max < 0 {
+		max = variadic
+	}
+	return &native{text, name, arity{min, max}, f}
+}
+
+// Call applies the procedure f to args.
+func Call(f Value, args ...Value) Value {
+	for {
+		switch p := f.(type) {
+		case *native:
+			p.check(p.name, args)
+			value := p.f(args)
+			t, ok := value.(*tailCall)
+			if !ok {
+				return value
+			}
+			f, args = t.f, t.args
+		case *primitive:
+			return p.call(args)
+		case *caseLambda:
+			f = p.clause(args)
+		default:
+			return apply(f, args)
+		}
+	}
+}
+
+// TailCall returns a call of f with args, to be made by the trampoline in Call.
+func TailCall(f Value, args ...Value) Value {
+	return &tailCall{f, args}
+}
+
+// Apply implements (apply f args).
+func Apply(f, args Value) Value {
+	return Call(f, spread(args)...)
+}
+
+// TailApply returns the call (apply f args), to be made by the trampoline in
+// Call.
+func TailApply(f, args Value) Value {
+	return &tailCall{f, spread(args)}
+}
+
+// spread returns the elements of args, a list of arguments for apply.
+func spread(args Value) []Value {
+	list, ok := listToSlice(args)
+	if !ok {
+		Fail("apply: expected a list of arguments: %s", args)
+	}
+	return list
+}
+
+// GlobalVariable returns the global variable called name.
+func GlobalVariable(name string) *Global {
+	return globalVariable(symbol(name))
+}
+
+// Get returns the value of g, which must be defined.
+func (g *global) Get() Value {
+	if g.value == nil {
+		Fail("undefined symbol: %s", g.name)
+	}
+	return g.value
+}
+
+// Set assigns value to g, which must be defined.
+func (g *global) Set(value Value) {
+	g.Get()
+	g.value = value
+}
+
+// Define defines g with value.
+func (g *global) Define(value Value) {
+	g.value = value
+}
+
+// Datum returns the datum written as text.
+func Datum(text string) Value {
+	x, err := readData(scan.NewScanner("<datum>", strings.NewReader(text)))
+	if err != nil {
+		panic(fmt.Sprintf("Datum(%q): %v", text, err))
+	}
+	return x
+}
+
+// Embedded returns the procedure embedded[i].
+func Embedded(i int) Value {
+	return embedded[i]
+}
+
+// IsTrue reports whether x counts as true, which it does unless it is #f.
+func IsTrue(x Value) bool {
+	return x != boolean(false)
+}
+
+// Defined returns x, the value of the local variable name, which must have
+// been initialized.
+func Defined(x Value, name string) Value {
+	if x == nil {
+		Fail("variable used before it is initialized: %s", name)
+	}
+	return x
+}
+
+// List returns a list of items.
+func List(items ...Value) Value {
+	return makeList(items...)
+}
+
+// Main runs a compiled program, whose top-level expressions are forms. It
+// prints the value of each, as Repl does, and exits at the first error.
+func Main(forms ...func() Value) {
+	compiled = true
+	for _, form := range forms {
+		value, err := runNative(form)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	}
+}
+
+// runNative runs the compiled top-level expression form.
+func runNative(form func() Value) (value Value, err error) {
+	defer recoverError(&err)
+	winders, handlers, currentOutput = nil, nil, standardOutput
+	value = form()
+	if t, ok := value.(*tailCall); ok {
+		value = Call(t.f, t.args...)
+	}
+	return value, nil
+}
@@ -0,0 +1,1014 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+/*
+ Numbers
+
+ Exact integers are fixnums while they fit in an int64, and bignums when they
+ do not; exact non-integers are ratnums; inexact numbers are flonums; and
+ non-real numbers are compnums. Every operation that produces an exact number
+ normalizes it to the simplest of those representations, so that, for
+ example, (* 1/2 4) is the fixnum 2, and (* +i +i) is the fixnum -1. In
+ particular, the only exact zero is fixnum(0).
+*/
+
+type fixnum int64     // exact integers that fit in 64 bits,
+type bignum big.Int   // ...exact integers that do not,
+type ratnum big.Rat   // ...exact non-integers,
+type compnum struct { // ...and complex numbers, whose parts are both exact
+	re, im scmer //    or both inexact, and whose im is not an exact zero.
+}
+
+func (x fixnum) String() string  { return strconv.FormatInt(int64(x), 10) }
+func (x *bignum) String() string { return (*big.Int)(x).String() }
+func (x *ratnum) String() string { return (*big.Rat)(x).RatString() }
+
+func (x *compnum) String() string {
+	im := x.im.String()
+	if im[0] != '+' && im[0] != '-' {
+		im = "+" + im
+	}
+	return x.re.String() + im + "i"
+}
+
+// numberText returns the number x written in the given radix, or fails on
+// behalf of the named procedure if x is not a number. Only exact numbers can be
+// written in a radix other than 10.
+func numberText(who string, x scmer, radix int) string {
+	if radix == 10 {
+		rank(who, x)
+		return x.String()
+	}
+	switch n := x.(type) {
+	case fixnum:
+		return strconv.FormatInt(int64(n), radix)
+	case *bignum:
+		return (*big.Int)(n).Text(radix)
+	case *ratnum:
+		r := (*big.Rat)(n)
+		return r.Num().Text(radix) + "/" + r.Denom().Text(radix)
+	case *compnum:
+		if isExact(who, n) {
+			im := numberText(who, n.im, radix)
+			if im[0] != '-' {
+				im = "+" + im
+			}
+			return numberText(who, n.re, radix) + im + "i"
+		}
+	}
+	rank(who, x)
+	Fail("%s: cannot write the inexact number %s in radix %d", who, x, radix)
+	return ""
+}
+
+// A flonum always prints with a decimal point or exponent (or as +inf.0,
+// -inf.0 or +nan.0), so that it reads back as an inexact number.
+func (x flonum) String() string {
+	f := float64(x)
+	switch {
+	case math.IsNaN(f):
+		return "+nan.0"
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	}
+	var s string
+	if a := math.Abs(f); a == 0 || (a >= 1e-7 && a < 1e21) {
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	} else {
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// normInt returns the exact integer i as a fixnum if it fits in one, and as a
+// bignum otherwise. The bignum takes ownership of i.
+func normInt(i *big.Int) scmer {
+	if i.IsInt64() {
+		return fixnum(i.Int64())
+	}
+	return (*bignum)(i)
+}
+
+// normRat returns the exact number r in its simplest representation. The
+// ratnum takes ownership of r.
+func normRat(r *big.Rat) scmer {
+	if r.IsInt() {
+		return normInt(new(big.Int).Set(r.Num()))
+	}
+	return (*ratnum)(r)
+}
+
+// makeRectangular returns the complex number re + im i, where re and im are
+// real. If either part is inexact, both are made inexact.
+func makeRectangular(re, im scmer) scmer {
+	if isExact("make-rectangular", re) != isExact("make-rectangular", im) {
+		re, im = inexact("make-rectangular", re), inexact("make-rectangular", im)
+	}
+	if im == fixnum(0) {
+		return re
+	}
+	return &compnum{re, im}
+}
+
+// makePolar returns the complex number with the given magnitude and angle.
+func makePolar(magnitude, angle scmer) scmer {
+	realRank("make-polar", magnitude)
+	realRank("make-polar", angle)
+	if angle == fixnum(0) {
+		return magnitude
+	}
+	m, a := toFloat(magnitude), toFloat(angle)
+	return makeRectangular(flonum(m*math.Cos(a)), flonum(m*math.Sin(a)))
+}
+
+// parts returns the real and imaginary parts of the number x.
+func parts(x scmer) (re, im scmer) {
+	if c, ok := x.(*compnum); ok {
+		return c.re, c.im
+	}
+	return x, fixnum(0)
+}
+
+// toComplex returns the number x as a complex128.
+func toComplex(x scmer) complex128 {
+	re, im := parts(x)
+	return complex(toFloat(re), toFloat(im))
+}
+
+// fromComplex returns c as an inexact complex number.
+func fromComplex(c complex128) scmer {
+	return makeRectangular(flonum(real(c)), flonum(imag(c)))
+}
+
+// Numbers are ranked by generality. An operation on numbers of different
+// ranks converts them both to the higher rank first.
+const (
+	rankFixnum = iota
+	rankBignum
+	rankRatnum
+	rankFlonum
+	rankCompnum
+)
+
+// rank returns the rank of the number x, or fails on behalf of the named
+// procedure if x is not a number.
+func rank(who string, x scmer) int {
+	switch x.(type) {
+	case fixnum:
+		return rankFixnum
+	case *bignum:
+		return rankBignum
+	case *ratnum:
+		return rankRatnum
+	case flonum:
+		return rankFlonum
+	case *compnum:
+		return rankCompnum
+	}
+	Fail("%s: expected a number, got %s", who, x)
+	return 0
+}
+
+// realRank is like rank, but fails if x is not a real number.
+func realRank(who string, x scmer) int {
+	r := rank(who, x)
+	if r == rankCompnum {
+		Fail("%s: expected a real number, got %s", who, x)
+	}
+	return r
+}
+
+func isNumber(x scmer) bool {
+	switch x.(type) {
+	case fixnum, *bignum, *ratnum, flonum, *compnum:
+		return true
+	}
+	return false
+}
+
+// toBig returns the exact integer x as a big.Int, which the caller must not
+// modify.
+func toBig(x scmer) *big.Int {
+	switch n := x.(type) {
+	case fixnum:
+		return big.NewInt(int64(n))
+	case *bignum:
+		return (*big.Int)(n)
+	}
+	panic("toBig: not an exact integer")
+}
+
+// toRat returns the exact number x as a big.Rat, which the caller must not
+// modify.
+func toRat(x scmer) *big.Rat {
+	switch n := x.(type) {
+	case fixnum:
+		return new(big.Rat).SetInt64(int64(n))
+	case *bignum:
+		return new(big.Rat).SetInt((*big.Int)(n))
+	case *ratnum:
+		return (*big.Rat)(n)
+	}
+	panic("toRat: not an exact number")
+}
+
+// toFloat returns the number x as a float64.
+func toFloat(x scmer) float64 {
+	switch n := x.(type) {
+	case fixnum:
+		return float64(n)
+	case *bignum:
+		f, _ := new(big.Float).SetInt((*big.Int)(n)).Float64()
+		return f
+	case *ratnum:
+		f, _ := (*big.Rat)(n).Float64()
+		return f
+	case flonum:
+		return float64(n)
+	}
+	panic("toFloat: not a number")
+}
+
+func isExact(who string, x scmer) bool {
+	switch rank(who, x) {
+	case rankFlonum:
+		return false
+	case rankCompnum:
+		return isExact(who, x.(*compnum).re)
+	}
+	return true
+}
+
+// exact implements (exact x).
+func exact(who string, x scmer) scmer {
+	if c, ok := x.(*compnum); ok {
+		return makeRectangular(exact(who, c.re), exact(who, c.im))
+	}
+	f, ok := x.(flonum)
+	if !ok {
+		rank(who, x)
+		return x
+	}
+	if math.IsInf(float64(f), 0) || math.IsNaN(float64(f)) {
+		Fail("%s: no exact representation for %s", who, x)
+	}
+	return normRat(new(big.Rat).SetFloat64(float64(f)))
+}
+
+// inexact implements (inexact x).
+func inexact(who string, x scmer) scmer {
+	if rank(who, x) == rankCompnum {
+		c := x.(*compnum)
+		return &compnum{inexact(who, c.re), inexact(who, c.im)}
+	}
+	return flonum(toFloat(x))
+}
+
+// An arithmetic is the implementation of a binary operation at each rank.
+// fix reports false if its result overflows, in which case big is used. cpx
+// is given the real and imaginary parts of both operands.
+type arithmetic struct {
+	fix func(x, y int64) (int64, bool)
+	big func(z, x, y *big.Int) *big.Int
+	rat func(z, x, y *big.Rat) *big.Rat
+	flo func(x, y float64) float64
+	cpx func(a, b, c, d scmer) scmer
+}
+
+func (op *arithmetic) apply(who string, a, b scmer) scmer {
+	r := rank(who, a)
+	if rb := rank(who, b); rb > r {
+		r = rb
+	}
+	switch r {
+	case rankFixnum:
+		if op.fix != nil {
+			if z, ok := op.fix(int64(a.(fixnum)), int64(b.(fixnum))); ok {
+				return fixnum(z)
+			}
+		}
+		fallthrough
+	case rankBignum:
+		if op.big != nil {
+			return normInt(op.big(new(big.Int), toBig(a), toBig(b)))
+		}
+		fallthrough
+	case rankRatnum:
+		return normRat(op.rat(new(big.Rat), toRat(a), toRat(b)))
+	case rankCompnum:
+		re1, im1 := parts(a)
+		re2, im2 := parts(b)
+		return op.cpx(re1, im1, re2, im2)
+	}
+	return flonum(op.flo(toFloat(a), toFloat(b)))
+}
+
+var addition = &arithmetic{
+	fix: func(x, y int64) (int64, bool) {
+		z := x + y
+		return z, (x^z)&(y^z) >= 0
+	},
+	big: (*big.Int).Add,
+	rat: (*big.Rat).Add,
+	flo: func(x, y float64) float64 { return x + y },
+}
+
+var subtraction = &arithmetic{
+	fix: func(x, y int64) (int64, bool) {
+		z := x - y
+		return z, (x^y)&(x^z) >= 0
+	},
+	big: (*big.Int).Sub,
+	rat: (*big.Rat).Sub,
+	flo: func(x, y float64) float64 { return x - y },
+}
+
+var multiplication = &arithmetic{
+	fix: func(x, y int64) (int64, bool) {
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		z := x * y
+		return z, z/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+	},
+	big: (*big.Int).Mul,
+	rat: (*big.Rat).Mul,
+	flo: func(x, y float64) float64 { return x * y },
+}
+
+// division has no fix or big operations, because the quotient of two
+// integers need not be an integer.
+var division = &arithmetic{
+	rat: (*big.Rat).Quo,
+	flo: func(x, y float64) float64 { return x / y },
+}
+
+// The complex operations are defined in terms of the real ones, so they are
+// added once the real ones have been initialized.
+func init() {
+	addition.cpx = func(a, b, c, d scmer) scmer {
+		return makeRectangular(add(a, c), add(b, d))
+	}
+	subtraction.cpx = func(a, b, c, d scmer) scmer {
+		return makeRectangular(sub(a, c), sub(b, d))
+	}
+	multiplication.cpx = func(a, b, c, d scmer) scmer {
+		// (a + bi)(c + di) = (ac - bd) + (ad + bc)i
+		return makeRectangular(sub(mul(a, c), mul(b, d)), add(mul(a, d), mul(b, c)))
+	}
+	division.cpx = func(a, b, c, d scmer) scmer {
+		// (a + bi)/(c + di) = ((ac + bd) + (bc - ad)i) / (c² + d²)
+		if !isExact("/", a) || !isExact("/", c) {
+			return fromComplex(complex(toFloat(a), toFloat(b)) / complex(toFloat(c), toFloat(d)))
+		}
+		denominator := add(mul(c, c), mul(d, d))
+		return makeRectangular(
+			div(add(mul(a, c), mul(b, d)), denominator),
+			div(sub(mul(b, c), mul(a, d)), denominator))
+	}
+}
+
+func add(a, b scmer) scmer { return addition.apply("+", a, b) }
+func sub(a, b scmer) scmer { return subtraction.apply("-", a, b) }
+
+// negate returns -x. An inexact part is negated rather than subtracted from
+// zero, so that (- 0.0) is -0.0.
+func negate(x scmer) scmer {
+	switch n := x.(type) {
+	case flonum:
+		return -n
+	case *compnum:
+		return &compnum{negate(n.re), negate(n.im)}
+	}
+	return sub(fixnum(0), x)
+}
+func mul(a, b scmer) scmer { return multiplication.apply("*", a, b) }
+
+func div(a, b scmer) scmer {
+	rank("/", a)
+	if b == fixnum(0) {
+		Fail("/: division by zero")
+	}
+	return division.apply("/", a, b)
+}
+
+// compare returns -1, 0 or +1 as a is less than, equal to or greater than b.
+// The second result is false if either is a NaN, and so a and b are unordered.
+func compare(who string, a, b scmer) (int, bool) {
+	r := realRank(who, a)
+	if rb := realRank(who, b); rb > r {
+		r = rb
+	}
+	switch r {
+	case rankFixnum:
+		x, y := a.(fixnum), b.(fixnum)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case rankBignum:
+		return toBig(a).Cmp(toBig(b)), true
+	case rankRatnum:
+		return toRat(a).Cmp(toRat(b)), true
+	}
+	// An exact number is compared with a flonum exactly, so that = is
+	// transitive.
+	if _, ok := a.(flonum); !ok {
+		return compareExact(a, float64(b.(flonum)))
+	} else if _, ok := b.(flonum); !ok {
+		c, ok := compareExact(b, float64(a.(flonum)))
+		return -c, ok
+	}
+	x, y := a.(flonum), b.(flonum)
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	case x == y:
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareExact is compare for the exact real x and the flonum y.
+func compareExact(x scmer, y float64) (int, bool) {
+	switch {
+	case math.IsNaN(y):
+		return 0, false
+	case math.IsInf(y, 1):
+		return -1, true
+	case math.IsInf(y, -1):
+		return 1, true
+	}
+	return toRat(x).Cmp(new(big.Rat).SetFloat64(y)), true
+}
+
+// sign returns -1, 0 or +1 according to the sign of the number x.
+func sign(who string, x scmer) int {
+	s, _ := compare(who, x, fixnum(0))
+	return s
+}
+
+// numEqual reports whether the numbers a and b are equal, as for (= a b).
+func numEqual(who string, a, b scmer) bool {
+	re1, im1 := parts(a)
+	re2, im2 := parts(b)
+	c, ok := compare(who, re1, re2)
+	if !ok || c != 0 {
+		return false
+	}
+	c, ok = compare(who, im1, im2)
+	return ok && c == 0
+}
+
+// eqvNumbers reports whether a and b are both numbers with the same exactness
+// and the same value, as for (eqv? a b).
+func eqvNumbers(a, b scmer) bool {
+	if !isNumber(a) || !isNumber(b) || isExact("eqv?", a) != isExact("eqv?", b) {
+		return false
+	}
+	return numEqual("eqv?", a, b)
+}
+
+// comparison returns a primitive that reports whether each of its arguments
+// is related to the next by holds.
+func comparison(who string, holds func(c int) bool) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		result := true
+		for i := range a {
+			realRank(who, a[i])
+			if i > 0 && result {
+				c, ok := compare(who, a[i-1], a[i])
+				result = ok && holds(c)
+			}
+		}
+		return boolean(result)
+	}
+}
+
+// asInteger returns the integer x as an exact integer, and reports whether x
+// was exact. It fails on behalf of the named procedure if x is not an integer.
+func asInteger(who string, x scmer) (scmer, bool) {
+	switch n := x.(type) {
+	case fixnum, *bignum:
+		return n, true
+	case flonum:
+		if f := float64(n); f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return exact(who, n), false
+		}
+	}
+	Fail("%s: expected an integer, got %s", who, x)
+	return nil, false
+}
+
+// integerDivision returns a primitive implementing one of quotient,
+// remainder and modulo, given its int64 and big.Int implementations. The
+// result is inexact if either argument is.
+func integerDivision(who string, fix func(x, y int64) int64, op func(z, x, y *big.Int) *big.Int) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		x, xExact := asInteger(who, a[0])
+		y, yExact := asInteger(who, a[1])
+		if sign(who, y) == 0 {
+			Fail("%s: division by zero", who)
+		}
+		var z scmer
+		fx, xOK := x.(fixnum)
+		fy, yOK := y.(fixnum)
+		if xOK && yOK && fy != -1 { // only MinInt64 / -1 overflows
+			z = fixnum(fix(int64(fx), int64(fy)))
+		} else {
+			z = normInt(op(new(big.Int), toBig(x), toBig(y)))
+		}
+		if !xExact || !yExact {
+			return inexact(who, z)
+		}
+		return z
+	}
+}
+
+// modulo returns x modulo y, which has the sign of y.
+func modulo(x, y int64) int64 {
+	m := x % y
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return m
+}
+
+func bigModulo(z, x, y *big.Int) *big.Int {
+	z.Rem(x, y)
+	if z.Sign() != 0 && z.Sign() != y.Sign() {
+		z.Add(z, y)
+	}
+	return z
+}
+
+// rounding returns a primitive that rounds its argument to an integer, given
+// the flonum implementation and the big.Rat one.
+func rounding(who string, flo func(float64) float64, rat func(n, d *big.Int) *big.Int) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		switch x := a[0].(type) {
+		case flonum:
+			return flonum(flo(float64(x)))
+		case *ratnum:
+			r := (*big.Rat)(x)
+			return normInt(rat(r.Num(), r.Denom()))
+		}
+		realRank(who, a[0])
+		return a[0]
+	}
+}
+
+// floorRat returns the largest integer not greater than n/d, for d > 0.
+func floorRat(n, d *big.Int) *big.Int {
+	return new(big.Int).Div(n, d) // Euclidean division, which floors for d > 0
+}
+
+func ceilingRat(n, d *big.Int) *big.Int {
+	q := floorRat(new(big.Int).Neg(n), d)
+	return q.Neg(q)
+}
+
+func truncateRat(n, d *big.Int) *big.Int {
+	return new(big.Int).Quo(n, d)
+}
+
+// roundRat returns the integer closest to n/d, rounding to even on a tie.
+func roundRat(n, d *big.Int) *big.Int {
+	// q = floor(n/d + 1/2) = floor((2n + d) / 2d)
+	twice := new(big.Int).Lsh(n, 1)
+	q, m := new(big.Int).DivMod(twice.Add(twice, d), new(big.Int).Lsh(d, 1), new(big.Int))
+	if m.Sign() == 0 && q.Bit(0) == 1 {
+		// n/d was halfway between q-1 and q, and q is odd.
+		q.Sub(q, big.NewInt(1))
+	}
+	return q
+}
+
+// expt implements (expt base power). The result is exact if base is exact and
+// power is an exact integer.
+func expt(base, power scmer) scmer {
+	rank("expt", base)
+	rank("expt", power)
+	switch p := power.(type) {
+	case fixnum:
+		if _, ok := base.(*compnum); ok && isExact("expt", base) {
+			return exptBySquaring(base, p)
+		} else if isExact("expt", base) {
+			n := p
+			if n < 0 {
+				n = -n
+			}
+			r := toRat(base)
+			z := new(big.Rat).SetFrac(
+				new(big.Int).Exp(r.Num(), big.NewInt(int64(n)), nil),
+				new(big.Int).Exp(r.Denom(), big.NewInt(int64(n)), nil))
+			if p < 0 {
+				if z.Sign() == 0 {
+					Fail("expt: division by zero")
+				}
+				z.Inv(z)
+			}
+			return normRat(z)
+		}
+	case *bignum:
+		if isExact("expt", base) {
+			switch b := base.(type) {
+			case fixnum:
+				switch {
+				case b == 0 || b == 1:
+					return b
+				case b == -1:
+					return fixnum(1 - 2*int64((*big.Int)(p).Bit(0)))
+				}
+			}
+			Fail("expt: exponent too large: %s", power)
+		}
+	}
+	return inexactly(math.Pow, cmplx.Pow, base, power)
+}
+
+// exptBySquaring returns base raised to the power n using only multiplication
+// (and division, if n is negative), so that an exact base gives an exact
+// result.
+func exptBySquaring(base scmer, n fixnum) scmer {
+	u := uint64(n)
+	if n < 0 {
+		u = uint64(-n) // correct even for math.MinInt64
+	}
+	var result scmer = fixnum(1)
+	for ; u > 0; u >>= 1 {
+		if u&1 == 1 {
+			result = mul(result, base)
+		}
+		base = mul(base, base)
+	}
+	if n < 0 {
+		return div(fixnum(1), result)
+	}
+	return result
+}
+
+// inexactly applies f to the real numbers x and y as float64s, or cf to them
+// as complex128s if either is complex, or if f has no real result.
+func inexactly(f func(x, y float64) float64, cf func(x, y complex128) complex128, x, y scmer) scmer {
+	_, xComplex := x.(*compnum)
+	_, yComplex := y.(*compnum)
+	if !xComplex && !yComplex {
+		fx, fy := toFloat(x), toFloat(y)
+		if z := f(fx, fy); !math.IsNaN(z) || math.IsNaN(fx) || math.IsNaN(fy) {
+			return flonum(z)
+		}
+	}
+	return fromComplex(cf(toComplex(x), toComplex(y)))
+}
+
+// exactSqrt returns the exact square root of the exact non-negative number x,
+// if it has one.
+func exactSqrt(x scmer) (scmer, bool) {
+	r := toRat(x)
+	n, d := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+	if new(big.Int).Mul(n, n).Cmp(r.Num()) != 0 || new(big.Int).Mul(d, d).Cmp(r.Denom()) != 0 {
+		return nil, false
+	}
+	return normRat(new(big.Rat).SetFrac(n, d)), true
+}
+
+// numericPrimitives are added to the global environment along with the other
+// primitives.
+var numericPrimitives = map[string]primitiveDef{
+	"+": {arity{0, variadic}, func(a ...scmer) scmer {
+		var v scmer = fixnum(0)
+		for _, x := range a {
+			v = add(v, x)
+		}
+		return v
+	}},
+	"*": {arity{0, variadic}, func(a ...scmer) scmer {
+		var v scmer = fixnum(1)
+		for _, x := range a {
+			v = mul(v, x)
+		}
+		return v
+	}},
+	"-": {arity{1, variadic}, func(a ...scmer) scmer {
+		if len(a) == 1 {
+			return negate(a[0])
+		}
+		v := a[0]
+		for _, x := range a[1:] {
+			v = sub(v, x)
+		}
+		return v
+	}},
+	"/": {arity{1, variadic}, func(a ...scmer) scmer {
+		if len(a) == 1 {
+			return div(fixnum(1), a[0])
+		}
+		v := a[0]
+		for _, x := range a[1:] {
+			v = div(v, x)
+		}
+		return v
+	}},
+	"=": {arity{1, variadic}, func(a ...scmer) scmer {
+		result := true
+		for i := range a {
+			rank("=", a[i])
+			result = result && (i == 0 || numEqual("=", a[i-1], a[i]))
+		}
+		return boolean(result)
+	}},
+	"<":  {arity{1, variadic}, comparison("<", func(c int) bool { return c < 0 })},
+	"<=": {arity{1, variadic}, comparison("<=", func(c int) bool { return c <= 0 })},
+	">":  {arity{1, variadic}, comparison(">", func(c int) bool { return c > 0 })},
+	">=": {arity{1, variadic}, comparison(">=", func(c int) bool { return c >= 0 })},
+	"number?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(isNumber(a[0]))
+	}},
+	"complex?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(isNumber(a[0]))
+	}},
+	"real?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*compnum)
+		return boolean(isNumber(a[0]) && !ok)
+	}},
+	"rational?": {arity{1, 1}, func(a ...scmer) scmer {
+		switch x := a[0].(type) {
+		case fixnum, *bignum, *ratnum:
+			return boolean(true)
+		case flonum:
+			return boolean(!math.IsInf(float64(x), 0) && !math.IsNaN(float64(x)))
+		}
+		return boolean(false)
+	}},
+	"integer?": {arity{1, 1}, func(a ...scmer) scmer {
+		switch x := a[0].(type) {
+		case fixnum, *bignum:
+			return boolean(true)
+		case flonum:
+			f := float64(x)
+			return boolean(f == math.Trunc(f) && !math.IsInf(f, 0))
+		}
+		return boolean(false)
+	}},
+	"exact?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(isExact("exact?", a[0]))
+	}},
+	"inexact?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(!isExact("inexact?", a[0]))
+	}},
+	"exact-integer?": {arity{1, 1}, func(a ...scmer) scmer {
+		switch a[0].(type) {
+		case fixnum, *bignum:
+			return boolean(true)
+		}
+		return boolean(false)
+	}},
+	"exact-rational?": {arity{1, 1}, func(a ...scmer) scmer {
+		switch a[0].(type) {
+		case fixnum, *bignum, *ratnum:
+			return boolean(true)
+		}
+		return boolean(false)
+	}},
+	"nan?": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("nan?", a[0])
+		return boolean(cmplx.IsNaN(toComplex(a[0])))
+	}},
+	"infinite?": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("infinite?", a[0])
+		return boolean(cmplx.IsInf(toComplex(a[0])))
+	}},
+	"finite?": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("finite?", a[0])
+		c := toComplex(a[0])
+		return boolean(!cmplx.IsInf(c) && !cmplx.IsNaN(c))
+	}},
+	"zero?": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("zero?", a[0])
+		return boolean(numEqual("zero?", a[0], fixnum(0)))
+	}},
+	"positive?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(sign("positive?", a[0]) > 0)
+	}},
+	"negative?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(sign("negative?", a[0]) < 0)
+	}},
+	"odd?": {arity{1, 1}, func(a ...scmer) scmer {
+		x, _ := asInteger("odd?", a[0])
+		return boolean(toBig(x).Bit(0) == 1)
+	}},
+	"even?": {arity{1, 1}, func(a ...scmer) scmer {
+		x, _ := asInteger("even?", a[0])
+		return boolean(toBig(x).Bit(0) == 0)
+	}},
+	"max": {arity{1, variadic}, func(a ...scmer) scmer {
+		return extremum("max", a, 1)
+	}},
+	"min": {arity{1, variadic}, func(a ...scmer) scmer {
+		return extremum("min", a, -1)
+	}},
+	"abs": {arity{1, 1}, func(a ...scmer) scmer {
+		return abs("abs", a[0])
+	}},
+	"quotient": {arity{2, 2}, integerDivision("quotient",
+		func(x, y int64) int64 { return x / y }, (*big.Int).Quo)},
+	"remainder": {arity{2, 2}, integerDivision("remainder",
+		func(x, y int64) int64 { return x % y }, (*big.Int).Rem)},
+	"modulo": {arity{2, 2}, integerDivision("modulo", modulo, bigModulo)},
+	"gcd": {arity{0, variadic}, func(a ...scmer) scmer {
+		return gcdLcm("gcd", a)
+	}},
+	"lcm": {arity{0, variadic}, func(a ...scmer) scmer {
+		return gcdLcm("lcm", a)
+	}},
+	"numerator": {arity{1, 1}, func(a ...scmer) scmer {
+		if f, ok := a[0].(flonum); ok {
+			return inexact("numerator", normInt(new(big.Int).Set(toRat(exact("numerator", f)).Num())))
+		}
+		realRank("numerator", a[0])
+		return normInt(new(big.Int).Set(toRat(a[0]).Num()))
+	}},
+	"denominator": {arity{1, 1}, func(a ...scmer) scmer {
+		if f, ok := a[0].(flonum); ok {
+			return inexact("denominator", normInt(new(big.Int).Set(toRat(exact("denominator", f)).Denom())))
+		}
+		realRank("denominator", a[0])
+		return normInt(new(big.Int).Set(toRat(a[0]).Denom()))
+	}},
+	"floor":    {arity{1, 1}, rounding("floor", math.Floor, floorRat)},
+	"ceiling":  {arity{1, 1}, rounding("ceiling", math.Ceil, ceilingRat)},
+	"truncate": {arity{1, 1}, rounding("truncate", math.Trunc, truncateRat)},
+	"round":    {arity{1, 1}, rounding("round", math.RoundToEven, roundRat)},
+	"square": {arity{1, 1}, func(a ...scmer) scmer {
+		return mul(a[0], a[0])
+	}},
+	"sqrt": {arity{1, 1}, func(a ...scmer) scmer {
+		return squareRoot(a[0])
+	}},
+	"expt": {arity{2, 2}, func(a ...scmer) scmer {
+		return expt(a[0], a[1])
+	}},
+	"exp": {arity{1, 1}, floating("exp", math.Exp, cmplx.Exp)},
+	"log": {arity{1, 2}, func(a ...scmer) scmer {
+		if len(a) == 2 {
+			// the logarithm of a[0] to the base a[1]
+			return div(floating("log", math.Log, cmplx.Log)(a[0]),
+				floating("log", math.Log, cmplx.Log)(a[1]))
+		}
+		return floating("log", math.Log, cmplx.Log)(a...)
+	}},
+	"sin":  {arity{1, 1}, floating("sin", math.Sin, cmplx.Sin)},
+	"cos":  {arity{1, 1}, floating("cos", math.Cos, cmplx.Cos)},
+	"tan":  {arity{1, 1}, floating("tan", math.Tan, cmplx.Tan)},
+	"asin": {arity{1, 1}, floating("asin", math.Asin, cmplx.Asin)},
+	"acos": {arity{1, 1}, floating("acos", math.Acos, cmplx.Acos)},
+	"atan": {arity{1, 2}, func(a ...scmer) scmer {
+		if len(a) == 2 {
+			realRank("atan", a[0])
+			realRank("atan", a[1])
+			return flonum(math.Atan2(toFloat(a[0]), toFloat(a[1])))
+		}
+		return floating("atan", math.Atan, cmplx.Atan)(a...)
+	}},
+	"make-rectangular": {arity{2, 2}, func(a ...scmer) scmer {
+		realRank("make-rectangular", a[0])
+		realRank("make-rectangular", a[1])
+		return makeRectangular(a[0], a[1])
+	}},
+	"make-polar": {arity{2, 2}, func(a ...scmer) scmer {
+		return makePolar(a[0], a[1])
+	}},
+	"real-part": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("real-part", a[0])
+		re, _ := parts(a[0])
+		return re
+	}},
+	"imag-part": {arity{1, 1}, func(a ...scmer) scmer {
+		rank("imag-part", a[0])
+		_, im := parts(a[0])
+		return im
+	}},
+	"magnitude": {arity{1, 1}, func(a ...scmer) scmer {
+		re, im := parts(a[0])
+		if im == fixnum(0) {
+			return abs("magnitude", re)
+		}
+		return squareRoot(add(mul(re, re), mul(im, im)))
+	}},
+	"angle": {arity{1, 1}, func(a ...scmer) scmer {
+		re, im := parts(a[0])
+		if im == fixnum(0) && isExact("angle", re) && sign("angle", re) >= 0 {
+			return fixnum(0)
+		}
+		return flonum(math.Atan2(toFloat(im), toFloat(re)))
+	}},
+	"exact": {arity{1, 1}, func(a ...scmer) scmer {
+		return exact("exact", a[0])
+	}},
+	"inexact": {arity{1, 1}, func(a ...scmer) scmer {
+		return inexact("inexact", a[0])
+	}},
+	"inexact->exact": {arity{1, 1}, func(a ...scmer) scmer {
+		return exact("inexact->exact", a[0])
+	}},
+	"exact->inexact": {arity{1, 1}, func(a ...scmer) scmer {
+		return inexact("exact->inexact", a[0])
+	}},
+}
+
+// abs returns the absolute value of the real number x.
+func abs(who string, x scmer) scmer {
+	if sign(who, x) < 0 {
+		return sub(fixnum(0), x)
+	}
+	return x
+}
+
+// extremum returns the greatest of a (if s is 1) or the least (if s is -1).
+// The result is inexact if any argument is.
+func extremum(who string, a []scmer, s int) scmer {
+	v, exact := a[0], isExact(who, a[0])
+	for _, x := range a[1:] {
+		exact = isExact(who, x) && exact
+		if c, ok := compare(who, x, v); !ok || c == s {
+			v = x
+		}
+	}
+	if !exact {
+		return inexact(who, v)
+	}
+	return v
+}
+
+// gcdLcm implements (gcd n ...) and (lcm n ...).
+func gcdLcm(who string, a []scmer) scmer {
+	var v scmer = fixnum(0)
+	if who == "lcm" {
+		v = fixnum(1)
+	}
+	exact := true
+	for _, x := range a {
+		n, ok := asInteger(who, x)
+		exact = exact && ok
+		g := new(big.Int).GCD(nil, nil, new(big.Int).Abs(toBig(v)), new(big.Int).Abs(toBig(n)))
+		if who == "gcd" {
+			v = normInt(g)
+		} else if g.Sign() == 0 {
+			v = fixnum(0)
+		} else {
+			l := new(big.Int).Mul(toBig(v), toBig(n))
+			v = normInt(l.Abs(l.Quo(l, g)))
+		}
+	}
+	if !exact {
+		return inexact(who, v)
+	}
+	return v
+}
+
+// floating returns a primitive that applies the inexact function f to its
+// argument, or cf if the argument is complex or f has no real result for it.
+func floating(who string, f func(float64) float64, cf func(complex128) complex128) func(...scmer) scmer {
+	return func(a ...scmer) scmer {
+		if rank(who, a[0]) != rankCompnum {
+			x := toFloat(a[0])
+			if y := f(x); !math.IsNaN(y) || math.IsNaN(x) {
+				return flonum(y)
+			}
+		}
+		return fromComplex(cf(toComplex(a[0])))
+	}
+}
+
+// squareRoot implements (sqrt x). The square root of an exact number is exact
+// if it can be.
+func squareRoot(x scmer) scmer {
+	if rank("sqrt", x) != rankCompnum && isExact("sqrt", x) {
+		if sign("sqrt", x) < 0 {
+			if root, ok := exactSqrt(sub(fixnum(0), x)); ok {
+				return makeRectangular(fixnum(0), root)
+			}
+		} else if root, ok := exactSqrt(x); ok {
+			return root
+		}
+	}
+	return floating("sqrt", math.Sqrt, cmplx.Sqrt)(x)
+}
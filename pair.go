@@ -0,0 +1,159 @@
+package lisp
+
+/*
+ Pairs and lists
+*/
+
+// makeList returns a proper list of the given items.
+func makeList(items ...scmer) scmer {
+	return makeDottedList(items, empty)
+}
+
+// makeDottedList returns a list of the given items, whose final cdr is tail.
+func makeDottedList(items []scmer, tail scmer) scmer {
+	result := tail
+	for i := len(items) - 1; i >= 0; i-- {
+		result = &pair{items[i], result}
+	}
+	return result
+}
+
+// listToSlice returns the elements of a proper list.
+// The second result is false if x is not a proper list. It fails if x is
+// circular.
+func listToSlice(x scmer) ([]scmer, bool) {
+	items := make([]scmer, 0, length(x))
+	for {
+		switch p := x.(type) {
+		case emptyList:
+			return items, true
+		case *pair:
+			items = append(items, p.car)
+			x = p.cdr
+		default:
+			return items, false
+		}
+	}
+}
+
+// length returns the number of pairs in the spine of x. It fails if the
+// spine is circular, which it finds by following it with a second pointer at
+// half the speed: the two meet only in a cycle.
+func length(x scmer) int {
+	n, slow := 0, x
+	for p, ok := x.(*pair); ok; p, ok = p.cdr.(*pair) {
+		n++
+		if n%2 == 0 {
+			slow = slow.(*pair).cdr
+			if slow == p.cdr {
+				Fail("circular list: %s", x)
+			}
+		}
+	}
+	return n
+}
+
+// asPair returns x as a pair, or fails on behalf of the named procedure.
+func asPair(who string, x scmer) *pair {
+	p, ok := x.(*pair)
+	if !ok {
+		Fail("%s: expected a pair, got %s", who, x)
+	}
+	return p
+}
+
+// eqv reports whether a and b are the same object, as for (eqv? a b).
+// Numbers are the same if they have the same exactness and value.
+func eqv(a, b scmer) bool {
+	return a == b || eqvNumbers(a, b)
+}
+
+// equal reports whether a and b are structurally equal, as for (equal? a b).
+// It finishes even if they are circular.
+func equal(a, b scmer) bool {
+	return (&equalizer{}).equal(a, b)
+}
+
+// equalSteps is how many pairs and vectors equal compares before it starts
+// to look for cycles.
+const equalSteps = 1000
+
+// An equalizer compares values for equal. Once it has compared many pairs
+// and vectors, it records each two it compares, and takes two it meets again
+// to be equal: if they are not, that shows up elsewhere in the comparison. So
+// a cycle is followed only once around.
+type equalizer struct {
+	steps int
+	seen  map[[2]scmer]bool
+}
+
+func (q *equalizer) equal(a, b scmer) bool {
+	for {
+		switch x := a.(type) {
+		case *pair:
+			y, ok := b.(*pair)
+			if !ok {
+				return false
+			} else if x == y || q.again(x, y) {
+				return true
+			} else if !q.equal(x.car, y.car) {
+				return false
+			}
+			a, b = x.cdr, y.cdr
+			continue
+		case *vector:
+			y, ok := b.(*vector)
+			if !ok || len(x.items) != len(y.items) {
+				return false
+			} else if x == y || q.again(x, y) {
+				return true
+			}
+			for i, item := range x.items {
+				if !q.equal(item, y.items[i]) {
+					return false
+				}
+			}
+			return true
+		case *str:
+			y, ok := b.(*str)
+			return ok && x.equal(y)
+		case *bytevector:
+			y, ok := b.(*bytevector)
+			return ok && x.equal(y)
+		}
+		return eqv(a, b)
+	}
+}
+
+// again reports whether a and b, two pairs or two vectors, have been compared
+// before, and records that they have.
+func (q *equalizer) again(a, b scmer) bool {
+	if q.steps++; q.steps < equalSteps {
+		return false
+	} else if q.seen == nil {
+		q.seen = map[[2]scmer]bool{}
+	}
+	k := [2]scmer{a, b}
+	if q.seen[k] {
+		return true
+	}
+	q.seen[k] = true
+	return false
+}
+
+// appendLists implements (append list ...). Every argument but the last is
+// copied, and the last becomes the tail of the result.
+func appendLists(a ...scmer) scmer {
+	if len(a) == 0 {
+		return empty
+	}
+	result := a[len(a)-1]
+	for i := len(a) - 2; i >= 0; i-- {
+		items, ok := listToSlice(a[i])
+		if !ok {
+			Fail("append: expected a list, got %s", a[i])
+		}
+		result = makeDottedList(items, result)
+	}
+	return result
+}
@@ -0,0 +1,349 @@
+package lisp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+/*
+ Ports
+
+ An input port reads text through a scan.Scanner, so that read can take data
+ from it while read-char and the rest take characters from between them. An
+ output port writes text to an io.Writer; a string output port collects it,
+ for get-output-string.
+
+ The current output port is part of the dynamic state, like the exception
+ handlers: with-output-to-string rebinds it for the extent of a thunk, and a
+ continuation restores the one in effect when it was captured.
+*/
+
+type port struct {
+	name   string
+	in     *scan.Scanner    // the text of an input port
+	out    io.Writer        // where an output port writes
+	buf    *strings.Builder // the text of a string output port, or nil
+	closer io.Closer        // the file to close with the port, or nil
+	closed bool
+}
+
+func (x *port) String() string {
+	if x.in != nil {
+		return fmt.Sprintf("#<input-port:%s>", x.name)
+	}
+	return fmt.Sprintf("#<output-port:%s>", x.name)
+}
+
+// An eof is the end-of-file object, which reading returns at the end of the
+// input. There is only one, eofObject.
+type eof struct{}
+
+func (eof) String() string { return "#<eof>" }
+
+var eofObject = eof{}
+
+var (
+	standardInput  = &port{name: "<stdin>", in: scan.NewScanner("<stdin>", bufio.NewReader(os.Stdin))}
+	standardOutput = &port{name: "<stdout>", out: os.Stdout}
+	standardError  = &port{name: "<stderr>", out: os.Stderr}
+)
+
+// SetStandardInput makes the standard input port read through scanner, which
+// must be the one that a REPL reading standard input uses, so that they share
+// the input.
+func SetStandardInput(scanner *scan.Scanner) {
+	standardInput.in = scanner
+}
+
+// currentOutput is the port that output goes to when no port is given.
+var currentOutput = standardOutput
+
+// newInputString returns a port that reads the string s.
+func newInputString(s string) *port {
+	return &port{name: "<string>", in: scan.NewScanner("<string>", strings.NewReader(s))}
+}
+
+// newOutputString returns a port that collects what is written to it.
+func newOutputString() *port {
+	b := &strings.Builder{}
+	return &port{name: "<string>", out: b, buf: b}
+}
+
+// inputPort returns the port given by the optional argument a[i], which is
+// the current input port by default. It fails on behalf of the named
+// procedure if that is not an open input port.
+func inputPort(who string, a []scmer, i int) *port {
+	p := standardInput
+	if len(a) > i {
+		p, _ = a[i].(*port)
+	}
+	if p == nil || p.in == nil {
+		Fail("%s: expected an input port, got %s", who, a[i])
+	} else if p.closed {
+		Fail("%s: port is closed: %s", who, p)
+	}
+	return p
+}
+
+// outputPort returns the port given by the optional argument a[i], which is
+// the current output port by default. It fails on behalf of the named
+// procedure if that is not an open output port.
+func outputPort(who string, a []scmer, i int) *port {
+	p := currentOutput
+	if len(a) > i {
+		p, _ = a[i].(*port)
+	}
+	if p == nil || p.out == nil {
+		Fail("%s: expected an output port, got %s", who, a[i])
+	} else if p.closed {
+		Fail("%s: port is closed: %s", who, p)
+	}
+	return p
+}
+
+// write writes s to the port p, or fails on behalf of the named procedure.
+func (p *port) write(who, s string) {
+	if _, err := io.WriteString(p.out, s); err != nil {
+		Fail("%s: %v", who, err)
+	}
+}
+
+// readRune returns the next char from the port p, or eofObject at the end of
+// its input.
+func (p *port) readRune() scmer {
+	if r, ok := p.in.NextRune(); ok {
+		return char(r)
+	}
+	return eofObject
+}
+
+// close closes the port p, and the file it reads or writes, if any.
+func (p *port) close(who string) {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	if p.closer != nil {
+		if err := p.closer.Close(); err != nil {
+			Fail("%s: %v", who, err)
+		}
+	}
+}
+
+// outputFrame restores the current output port when the thunk of a
+// with-output-to-string returns, and returns what the thunk wrote.
+type outputFrame struct {
+	saved, port *port
+	next        frame
+}
+
+func (f *outputFrame) resume(m *machine) {
+	m.k = f.next
+	currentOutput = f.saved
+	m.ret(makeStr(f.port.buf.String()))
+}
+
+func (f *outputFrame) below() frame { return f.next }
+
+// withOutputToString implements (with-output-to-string thunk).
+func withOutputToString(m *machine, a []scmer) {
+	p := newOutputString()
+	m.push(&outputFrame{currentOutput, p, m.k})
+	currentOutput = p
+	m.apply(a[0], nil)
+}
+
+// portControls are added to the global environment along with the
+// primitives.
+var portControls = map[string]controlDef{
+	"with-output-to-string": {arity{1, 1}, withOutputToString},
+}
+
+var portPrimitives = map[string]primitiveDef{
+	"port?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*port)
+		return boolean(ok)
+	}},
+	"input-port?": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		return boolean(ok && p.in != nil)
+	}},
+	"output-port?": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		return boolean(ok && p.out != nil)
+	}},
+	"textual-port?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*port)
+		return boolean(ok)
+	}},
+	"input-port-open?": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok || p.in == nil {
+			Fail("input-port-open?: expected an input port, got %s", a[0])
+		}
+		return boolean(!p.closed)
+	}},
+	"output-port-open?": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok || p.out == nil {
+			Fail("output-port-open?: expected an output port, got %s", a[0])
+		}
+		return boolean(!p.closed)
+	}},
+	"current-input-port": {arity{0, 0}, func(a ...scmer) scmer {
+		return standardInput
+	}},
+	"current-output-port": {arity{0, 0}, func(a ...scmer) scmer {
+		return currentOutput
+	}},
+	"current-error-port": {arity{0, 0}, func(a ...scmer) scmer {
+		return standardError
+	}},
+	"open-input-file": {arity{1, 1}, func(a ...scmer) scmer {
+		name := asStr("open-input-file", a[0]).text()
+		f, err := os.Open(name)
+		if err != nil {
+			Fail("open-input-file: %v", err)
+		}
+		return &port{name: name, in: scan.NewScanner(name, bufio.NewReader(f)), closer: f}
+	}},
+	"open-output-file": {arity{1, 1}, func(a ...scmer) scmer {
+		name := asStr("open-output-file", a[0]).text()
+		f, err := os.Create(name)
+		if err != nil {
+			Fail("open-output-file: %v", err)
+		}
+		return &port{name: name, out: f, closer: f}
+	}},
+	"open-input-string": {arity{1, 1}, func(a ...scmer) scmer {
+		return newInputString(asStr("open-input-string", a[0]).text())
+	}},
+	"open-output-string": {arity{0, 0}, func(a ...scmer) scmer {
+		return newOutputString()
+	}},
+	"get-output-string": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok || p.buf == nil {
+			Fail("get-output-string: expected a string output port, got %s", a[0])
+		}
+		return makeStr(p.buf.String())
+	}},
+	"close-port": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok {
+			Fail("close-port: expected a port, got %s", a[0])
+		}
+		p.close("close-port")
+		return symbol("#%void")
+	}},
+	"close-input-port": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok || p.in == nil {
+			Fail("close-input-port: expected an input port, got %s", a[0])
+		}
+		p.close("close-input-port")
+		return symbol("#%void")
+	}},
+	"close-output-port": {arity{1, 1}, func(a ...scmer) scmer {
+		p, ok := a[0].(*port)
+		if !ok || p.out == nil {
+			Fail("close-output-port: expected an output port, got %s", a[0])
+		}
+		p.close("close-output-port")
+		return symbol("#%void")
+	}},
+	"eof-object": {arity{0, 0}, func(a ...scmer) scmer {
+		return eofObject
+	}},
+	"eof-object?": {arity{1, 1}, func(a ...scmer) scmer {
+		return boolean(a[0] == eofObject)
+	}},
+	"read": {arity{0, 1}, func(a ...scmer) scmer {
+		x, err := readData(inputPort("read", a, 0).in)
+		if err == io.EOF {
+			return eofObject
+		} else if err != nil {
+			Fail("read: %v", err)
+		}
+		return x
+	}},
+	"read-char": {arity{0, 1}, func(a ...scmer) scmer {
+		return inputPort("read-char", a, 0).readRune()
+	}},
+	"peek-char": {arity{0, 1}, func(a ...scmer) scmer {
+		if r, ok := inputPort("peek-char", a, 0).in.PeekRune(); ok {
+			return char(r)
+		}
+		return eofObject
+	}},
+	"read-line": {arity{0, 1}, func(a ...scmer) scmer {
+		p := inputPort("read-line", a, 0)
+		var runes []rune
+		for {
+			c := p.readRune()
+			if c == eofObject && runes == nil {
+				return eofObject
+			} else if c == eofObject || c == char('\n') {
+				return &str{runes}
+			}
+			runes = append(runes, rune(c.(char)))
+		}
+	}},
+	"read-string": {arity{1, 2}, func(a ...scmer) scmer {
+		k := asLength("read-string", a[0])
+		p := inputPort("read-string", a, 1)
+		var runes []rune
+		for len(runes) < k {
+			c := p.readRune()
+			if c == eofObject {
+				break
+			}
+			runes = append(runes, rune(c.(char)))
+		}
+		if runes == nil && k > 0 {
+			return eofObject
+		}
+		return &str{runes}
+	}},
+	"write-char": {arity{1, 2}, func(a ...scmer) scmer {
+		c := asChar("write-char", a[0])
+		outputPort("write-char", a, 1).write("write-char", string(c))
+		return symbol("#%void")
+	}},
+	"write-string": {arity{1, 4}, func(a ...scmer) scmer {
+		s := asStr("write-string", a[0])
+		p := outputPort("write-string", a, 1)
+		start, end := span("write-string", a, 2, len(s.runes))
+		p.write("write-string", string(s.runes[start:end]))
+		return symbol("#%void")
+	}},
+	"display": {arity{1, 2}, func(a ...scmer) scmer {
+		outputPort("display", a, 1).write("display", display(a[0]))
+		return symbol("#%void")
+	}},
+	"write": {arity{1, 2}, func(a ...scmer) scmer {
+		outputPort("write", a, 1).write("write", printValue(a[0], labelCycles, false))
+		return symbol("#%void")
+	}},
+	"write-shared": {arity{1, 2}, func(a ...scmer) scmer {
+		outputPort("write-shared", a, 1).write("write-shared", printValue(a[0], labelShared, false))
+		return symbol("#%void")
+	}},
+	"write-simple": {arity{1, 2}, func(a ...scmer) scmer {
+		outputPort("write-simple", a, 1).write("write-simple", printValue(a[0], labelNone, false))
+		return symbol("#%void")
+	}},
+	"newline": {arity{0, 1}, func(a ...scmer) scmer {
+		outputPort("newline", a, 0).write("newline", "\n")
+		return symbol("#%void")
+	}},
+	"flush-output-port": {arity{0, 1}, func(a ...scmer) scmer {
+		outputPort("flush-output-port", a, 0)
+		return symbol("#%void")
+	}},
+}
@@ -0,0 +1,65 @@
+package lisp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+// TestFilePorts writes a file through an output port, and reads it back
+// through an input port.
+func TestFilePorts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	name := strconv.Quote(path)
+	evalString(t, `
+		(define out (open-output-file `+name+`))
+		(write '(1 "two" #\3) out)
+		(newline out)
+		(write-string "λ line" out)
+		(close-port out)`)
+	text, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(1 \"two\" #\\3)\nλ line"; string(text) != want {
+		t.Errorf("wanted the file to hold %q, got %q", want, text)
+	}
+
+	got := evalString(t, `
+		(define in (open-input-file `+name+`))
+		(define datum (read in))
+		(define rest (begin (read-char in) (read-line in)))
+		(close-input-port in)
+		(list datum rest (input-port-open? in))`)
+	if want := `((1 "two" #\3) "λ line" #f)`; got.String() != want {
+		t.Errorf("wanted %s, got %s", want, got)
+	}
+}
+
+// TestSharedStandardInput checks that a program reading the current input
+// port reads what follows the expression in the input the REPL reads.
+func TestSharedStandardInput(t *testing.T) {
+	saved := standardInput.in
+	defer func() { standardInput.in = saved }()
+	scanner := scan.NewScanner("<stdin>", strings.NewReader(
+		"(read-line)\nhello world\n(read)  \n(a b) (read-char)x\n(+ 1 2)\n"))
+	SetStandardInput(scanner)
+	var got []string
+	for {
+		_, value, err := ReadEval(scanner)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, value.String())
+	}
+	if want := `"hello world" (a b) #\x 3`; strings.Join(got, " ") != want {
+		t.Errorf("wanted %s, got %s", want, strings.Join(got, " "))
+	}
+}
@@ -0,0 +1,169 @@
+package lisp
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+ Printing
+
+ A pair or vector may be part of a cycle, or shared by several parts of a
+ structure. R7RS's write procedures differ in how they show that: with
+ datum labels, #0= before a node's first appearance and #0# in place of the
+ ones after it. write-shared labels every node that appears more than once,
+ write labels only the nodes that would otherwise be printed forever, and
+ write-simple labels nothing (and never finishes on a cycle). display labels
+ as write does, but prints strings, chars and symbols as their raw contents.
+*/
+
+// A labelMode says which pairs and vectors a printer labels.
+type labelMode int
+
+const (
+	labelNone   labelMode = iota // as write-simple does
+	labelCycles                  // as write and display do
+	labelShared                  // as write-shared does
+)
+
+// display returns x as display prints it.
+func display(x scmer) string {
+	return printValue(x, labelCycles, true)
+}
+
+// printValue returns the printed form of x: written, with labels as mode
+// says, or displayed, if raw is true.
+func printValue(x scmer, mode labelMode, raw bool) string {
+	p := &printer{raw: raw}
+	if mode != labelNone {
+		p.labels = map[scmer]int{}
+		p.mark(x, mode == labelShared)
+	}
+	p.print(x)
+	return p.b.String()
+}
+
+// A printer prints a value.
+type printer struct {
+	b   strings.Builder
+	raw bool // whether strings, chars and symbols are printed as their contents
+
+	// The nodes that need labels, each mapped to its label, or to -1 until
+	// it has been printed once.
+	labels map[scmer]int
+	count  int // the number of labels printed so far
+}
+
+// mark finds the nodes of x that need labels: those that are reached again
+// while printing themselves, and, if shared is true, those that are reached
+// again at all.
+func (p *printer) mark(x scmer, shared bool) {
+	const (
+		visiting = 1 // inside the node
+		visited  = 2 // done with it
+	)
+	state := map[scmer]int{}
+	var walk func(x scmer)
+	walk = func(x scmer) {
+		// The pairs along a list's cdrs are all being visited until the end
+		// of the list.
+		var inside []scmer
+		for isNode(x) {
+			if s := state[x]; s == visiting || s == visited && shared {
+				p.labels[x] = -1
+				break
+			} else if s == visited {
+				break
+			}
+			state[x] = visiting
+			inside = append(inside, x)
+			if v, ok := x.(*vector); ok {
+				for _, item := range v.items {
+					walk(item)
+				}
+				break
+			}
+			walk(x.(*pair).car)
+			x = x.(*pair).cdr
+		}
+		for _, node := range inside {
+			state[node] = visited
+		}
+	}
+	walk(x)
+}
+
+// isNode reports whether x is a pair or vector, which may need a label.
+func isNode(x scmer) bool {
+	switch x.(type) {
+	case *pair, *vector:
+		return true
+	}
+	return false
+}
+
+// labeled reports whether x needs a label.
+func (p *printer) labeled(x scmer) bool {
+	_, ok := p.labels[x]
+	return ok
+}
+
+// print prints x, and labels it if it needs one.
+func (p *printer) print(x scmer) {
+	if n, ok := p.labels[x]; ok {
+		if n >= 0 {
+			p.b.WriteString("#" + strconv.Itoa(n) + "#")
+			return
+		}
+		p.labels[x] = p.count
+		p.b.WriteString("#" + strconv.Itoa(p.count) + "=")
+		p.count++
+	}
+	switch x := x.(type) {
+	case *pair:
+		p.b.WriteString("(")
+		p.print(x.car)
+		for tail := x.cdr; tail != empty; {
+			if next, ok := tail.(*pair); ok && !p.labeled(next) {
+				p.b.WriteString(" ")
+				p.print(next.car)
+				tail = next.cdr
+			} else {
+				// A labeled pair cannot be printed as part of this list.
+				p.b.WriteString(" . ")
+				p.print(tail)
+				break
+			}
+		}
+		p.b.WriteString(")")
+	case *vector:
+		p.b.WriteString("#(")
+		for i, item := range x.items {
+			if i > 0 {
+				p.b.WriteString(" ")
+			}
+			p.print(item)
+		}
+		p.b.WriteString(")")
+	case *str:
+		if p.raw {
+			p.b.WriteString(x.text())
+		} else {
+			p.b.WriteString(x.String())
+		}
+	case symbol:
+		if p.raw {
+			p.b.WriteString(string(x))
+		} else {
+			p.b.WriteString(x.String())
+		}
+	case char:
+		if p.raw {
+			p.b.WriteRune(rune(x))
+		} else {
+			p.b.WriteString(x.String())
+		}
+	default:
+		p.b.WriteString(x.String())
+	}
+}
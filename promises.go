@@ -0,0 +1,105 @@
+package lisp
+
+/*
+ Promises
+
+ A promise holds a thunk, which force calls once, the first time the
+ promise is forced, and whose value it then keeps. A promise made by
+ delay-force holds a thunk that returns another promise, whose value is to
+ be the first's. Forcing it forces the other in its place, without waiting
+ for its value, so that an iterative lazy algorithm runs in constant space.
+
+ This is the implementation of R7RS (section 7.3): a promise refers to a
+ box, which holds its state, and when a delay-force's promise is forced, the
+ promise its thunk returns is made to share the first's box.
+*/
+
+type promise struct {
+	box *promiseBox
+}
+
+type promiseBox struct {
+	done  bool
+	value scmer // the value, if done; otherwise the thunk
+	force bool  // true if the thunk returns a promise to be forced in turn
+}
+
+func (x *promise) String() string {
+	return "#<promise>"
+}
+
+// forceFrame receives the value of a promise's thunk.
+type forceFrame struct {
+	p    *promise
+	next frame
+}
+
+func (f *forceFrame) resume(m *machine) {
+	m.k = f.next
+	box := f.p.box
+	if box.done {
+		// The thunk forced the promise itself, and the first value wins.
+		m.ret(box.value)
+		return
+	}
+	if !box.force {
+		box.done, box.value = true, m.value
+		m.ret(m.value)
+		return
+	}
+	next, ok := m.value.(*promise)
+	if !ok {
+		Fail("force: delay-force expression returned a non-promise: %s", m.value)
+	}
+	*box = *next.box
+	next.box = box
+	force(m, []scmer{f.p})
+}
+
+func (f *forceFrame) below() frame { return f.next }
+
+// force implements (force obj). obj is returned as it is if it is not a
+// promise.
+func force(m *machine, a []scmer) {
+	p, ok := a[0].(*promise)
+	if !ok {
+		m.ret(a[0])
+		return
+	}
+	if p.box.done {
+		m.ret(p.box.value)
+		return
+	}
+	m.push(&forceFrame{p, m.k})
+	m.apply(p.box.value, nil)
+}
+
+// delayPromise and delayForcePromise make the promises of delay and
+// delay-force expressions, given their thunks.
+var (
+	delayPromise = &primitive{"delay", arity{1, 1}, func(a ...scmer) scmer {
+		return &promise{&promiseBox{value: a[0]}}
+	}}
+	delayForcePromise = &primitive{"delay-force", arity{1, 1}, func(a ...scmer) scmer {
+		return &promise{&promiseBox{value: a[0], force: true}}
+	}}
+)
+
+// promiseControls are added to the global environment along with the
+// primitives.
+var promiseControls = map[string]controlDef{
+	"force": {arity{1, 1}, force},
+}
+
+var promisePrimitives = map[string]primitiveDef{
+	"promise?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*promise)
+		return boolean(ok)
+	}},
+	"make-promise": {arity{1, 1}, func(a ...scmer) scmer {
+		if p, ok := a[0].(*promise); ok {
+			return p
+		}
+		return &promise{&promiseBox{done: true, value: a[0]}}
+	}},
+}
@@ -1,54 +1,169 @@
-package main
+package lisp
 
 import (
 	"fmt"
 	"io"
-	"os"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/perlmonger42/LiSP/scan"
 )
 
+// abbreviations maps each quoting token to the symbol it abbreviates.
+var abbreviations = map[scan.Type]symbol{
+	scan.Quote:           "quote",
+	scan.QuasiQuote:      "quasiquote",
+	scan.Unquote:         "unquote",
+	scan.UnquoteSplicing: "unquote-splicing",
+}
+
 // Parser / Syntactic Analysis
+
+// read reads a datum, which may be code, and so records where each list in it
+// begins (see locations). If it fails, it records none.
 func read(scanner *scan.Scanner) (scmer, error) {
+	reading = nil
+	datum, err := readDatum(scanner, map[string]*placeholder{}, true)
+	if err != nil {
+		for _, p := range reading {
+			delete(locations, p)
+		}
+	}
+	reading = nil
+	return datum, err
+}
+
+// reading lists the lists that read has recorded the locations of, in the
+// datum it is reading.
+var reading []*pair
+
+// readData reads a datum without recording any locations, since it is data,
+// such as the read procedure reads, which would otherwise keep them forever.
+func readData(scanner *scan.Scanner) (scmer, error) {
+	return readDatum(scanner, map[string]*placeholder{}, false)
+}
+
+// A placeholder stands for a labeled datum, #n=datum, in references to it,
+// #n#, while the datum is being read. Once it has been, the references are
+// replaced by the datum.
+type placeholder struct {
+	label string
+	value scmer // the datum, once it has been read
+}
+
+func (x *placeholder) String() string {
+	return "#" + x.label + "#"
+}
+
+// readDatum reads a datum, in which the labels given earlier in the same
+// top-level datum are in effect. If locate is true, it records the location of
+// each list it reads.
+func readDatum(scanner *scan.Scanner, labels map[string]*placeholder, locate bool) (scmer, error) {
 	tok := scanner.Next()
+	start := tok
+	at := func(list scmer) {
+		if p, ok := list.(*pair); ok && locate {
+			locations[p] = &location{scanner.Name(), start.Line, start.Col}
+			reading = append(reading, p)
+		}
+	}
 	switch tok.Type {
-	case scan.Quote:
-		if item, err := read(scanner); err != nil {
+	case scan.Quote, scan.QuasiQuote, scan.Unquote, scan.UnquoteSplicing:
+		if item, err := readDatum(scanner, labels, locate); err != nil {
 			return nil, err
 		} else {
-			return array{symbol("quote"), item}, nil
+			list := makeList(abbreviations[tok.Type], item)
+			at(list)
+			return list, nil
 		}
 	case scan.LeftParen:
-		var list array
+		var list scmer = empty
+		cdrRef := &list
+		defer func() { at(list) }()
 		for {
 			tok = scanner.Peek()
 			if tok.Type == scan.RightParen {
 				scanner.Next() // consume ")"
 				return list, nil
-				//// dotted pairs are not yet implemented
-				// } else if tok.Type == scan.Dot {
-				// 	scanner.Next() // consume "."
-				// 	if tail, err := Read(scanner); err != nil {
-				// 		return nil, err
-				// 	} else {
-				// 		*cdrRef = tail
-				// 	}
-				// 	if tok = scanner.Peek(); tok.Type == scan.RightParen {
-				// 		scanner.Next() // consume ")"
-				// 		return list, nil
-				// 	}
-				// 	return nil, fmt.Errorf("unterminated list: %s", list)
+			} else if tok.Type == scan.Dot {
+				dot := scanner.Next() // consume "."
+				if list == empty {
+					return nil, readErrorf(scanner, tok, "illegal use of `.`: nothing precedes it in list")
+				}
+				if tok = scanner.Peek(); tok.Type == scan.RightParen {
+					scanner.Next() // consume ")"
+					return nil, readErrorf(scanner, dot, "illegal use of `.`: nothing follows it in list: %s", list)
+				} else if tok.Type == scan.EOF {
+					return list, readErrorf(scanner, start, "unterminated list: %s", list)
+				}
+				if tail, err := readDatum(scanner, labels, locate); err != nil {
+					return nil, err
+				} else {
+					*cdrRef = tail
+				}
+				if tok = scanner.Peek(); tok.Type == scan.RightParen {
+					scanner.Next() // consume ")"
+					return list, nil
+				} else if tok.Type == scan.EOF {
+					return list, readErrorf(scanner, start, "unterminated list: %s", list)
+				}
+				return nil, readErrorf(scanner, tok, "illegal use of `.`: more than one datum follows it in list: %s", list)
 			} else if tok.Type == scan.EOF {
-				list = append(list, symbol("#%EOF"))
-				return list, fmt.Errorf("unterminated list: %s", list)
-			} else if item, err := read(scanner); err != nil {
+				*cdrRef = makeList(symbol("#%EOF"))
+				return list, readErrorf(scanner, start, "unterminated list: %s", list)
+			} else if item, err := readDatum(scanner, labels, locate); err != nil {
 				return nil, err
 			} else {
-				list = append(list, item)
+				cell := &pair{item, empty}
+				*cdrRef = cell
+				cdrRef = &cell.cdr
+			}
+		}
+	case scan.Vector:
+		items, err := readItems(scanner, labels, locate, "vector", tok)
+		if err != nil {
+			return nil, err
+		}
+		return &vector{items}, nil
+	case scan.Bytevector:
+		items, err := readItems(scanner, labels, locate, "bytevector", tok)
+		if err != nil {
+			return nil, err
+		}
+		bytes := make([]byte, len(items))
+		for i, x := range items {
+			n, ok := x.(fixnum)
+			if !ok || n < 0 || n > 255 {
+				return nil, readErrorf(scanner, tok, "bad bytevector element: %s", x)
 			}
+			bytes[i] = byte(n)
+		}
+		return &bytevector{bytes}, nil
+	case scan.DatumLabel:
+		label := tok.Text[1 : len(tok.Text)-1]
+		if _, ok := labels[label]; ok {
+			return nil, readErrorf(scanner, tok, "datum label defined twice: %s", tok.Text)
 		}
-		return list, nil
+		ph := &placeholder{label: label}
+		labels[label] = ph
+		x, err := readDatum(scanner, labels, locate)
+		if err != nil {
+			return nil, err
+		} else if x == ph {
+			return nil, readErrorf(scanner, tok, "datum label refers only to itself: %s", tok.Text)
+		}
+		ph.value = x
+		return patchLabels(x, map[scmer]bool{}), nil
+	case scan.DatumRef:
+		ph, ok := labels[tok.Text[1:len(tok.Text)-1]]
+		if !ok {
+			return nil, readErrorf(scanner, tok, "undefined datum label: %s", tok.Text)
+		} else if ph.value != nil {
+			return ph.value, nil
+		}
+		return ph, nil
 	case scan.False:
 		return boolean(false), nil
 	case scan.True:
@@ -57,25 +172,146 @@ func read(scanner *scan.Scanner) (scmer, error) {
 		r := scan.CharLiteralToRune(tok.Text)
 		return char(r), nil
 	case scan.String:
-		return str(tok.Text), nil
-	//// fixnums are not yet implemented
-	// case scan.Fixnum:
-	// 	if integer, err := strconv.ParseInt(tok.Text, 10, 64); err == nil {
-	// 		return expr.NewFixnum(integer), nil
-	// 	}
-	// 	return nil, fmt.Errorf("int too big: %s", tok.Text)
-	case scan.Flonum, scan.Fixnum:
-		if float, err := strconv.ParseFloat(tok.Text, 64); err == nil {
-			return flonum(float), nil
+		return makeStr(scan.StringLiteralToString(tok.Text)), nil
+	case scan.Fixnum, scan.Flonum, scan.Rational, scan.Complex:
+		x, err := readNumber(tok)
+		if err != nil {
+			return nil, readErrorf(scanner, tok, "%v", err)
 		}
-		return nil, fmt.Errorf("invalid floating-point number: %s", tok.Text)
+		return x, nil
 	case scan.Symbol:
+		return symbol(scan.SymbolLiteralToString(tok.Text, tok.FoldCase)), nil
+	case scan.Ellipsis:
 		return symbol(tok.Text), nil
+	case scan.Dot:
+		return nil, readErrorf(scanner, tok, "illegal use of `.` outside of a list")
+	case scan.Error:
+		return nil, readErrorf(scanner, tok, "%s", tok.Text)
 	case scan.EOF:
 		return nil, io.EOF
 	default:
-		fmt.Fprintf(os.Stderr, "unexpected token: %s\n", tok)
-		return symbol(tok.Text), nil
-		////return nil, fmt.Errorf("unexpected token: %s", tok)
+		return nil, readErrorf(scanner, tok, "unexpected token: %s", tok.Text)
+	}
+}
+
+// readErrorf returns an error, formatted as by fmt.Errorf, that says it was
+// found at the token tok that scanner read.
+func readErrorf(scanner *scan.Scanner, tok scan.Token, format string, a ...interface{}) error {
+	where := &location{scanner.Name(), tok.Line, tok.Col}
+	return fmt.Errorf("%s: %s", where, fmt.Sprintf(format, a...))
+}
+
+// patchLabels replaces the placeholders in x (which may be cyclic, and which
+// seen holds the parts of that have been patched) by the data they stand
+// for, and returns the result.
+func patchLabels(x scmer, seen map[scmer]bool) scmer {
+	switch y := x.(type) {
+	case *placeholder:
+		if y.value != nil {
+			return y.value
+		}
+	case *pair:
+		for p := y; p != nil && !seen[p]; p, _ = p.cdr.(*pair) {
+			seen[p] = true
+			p.car = patchLabels(p.car, seen)
+			if _, ok := p.cdr.(*pair); !ok {
+				p.cdr = patchLabels(p.cdr, seen)
+			}
+		}
+	case *vector:
+		if !seen[y] {
+			seen[y] = true
+			for i, item := range y.items {
+				y.items[i] = patchLabels(item, seen)
+			}
+		}
+	}
+	return x
+}
+
+// readItems reads the data that follow "#(" or "#u8(", up to the closing ")".
+// start is the token that opened them.
+func readItems(scanner *scan.Scanner, labels map[string]*placeholder, locate bool, what string, start scan.Token) ([]scmer, error) {
+	var items []scmer
+	for {
+		switch tok := scanner.Peek(); tok.Type {
+		case scan.RightParen:
+			scanner.Next() // consume ")"
+			return items, nil
+		case scan.Dot:
+			scanner.Next() // consume "."
+			return nil, readErrorf(scanner, tok, "illegal use of `.` in a %s", what)
+		case scan.EOF:
+			return nil, readErrorf(scanner, start, "unterminated %s", what)
+		}
+		item, err := readDatum(scanner, labels, locate)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// readNumber returns the number that tok represents.
+func readNumber(tok scan.Token) (scmer, error) {
+	n, ok := scan.ParseNumber(tok.Text)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", tok.Text)
+	}
+	re, err := readReal(n.Real, n.Radix, n.Exactness)
+	if err != nil || n.Imag == "" {
+		return re, err
+	}
+	im, err := readReal(n.Imag, n.Radix, n.Exactness)
+	if err != nil {
+		return nil, err
+	}
+	if n.Polar {
+		return makePolar(re, im), nil
+	}
+	return makeRectangular(re, im), nil
+}
+
+// readReal returns the real number that text represents. The text is a part
+// of a number, as split out by scan.ParseNumber, so it is known to be valid.
+func readReal(text string, radix int, exactness scan.Exactness) (scmer, error) {
+	var x scmer
+	switch {
+	case text == "":
+		x = fixnum(0) // the omitted real part of a number like +2i
+	case strings.HasSuffix(text, "inf.0"), strings.HasSuffix(text, "nan.0"):
+		if exactness == scan.Exact {
+			return nil, fmt.Errorf("no exact representation for %s", text)
+		}
+		if strings.HasSuffix(text, "nan.0") {
+			return flonum(math.NaN()), nil
+		} else if text[0] == '-' {
+			return flonum(math.Inf(-1)), nil
+		}
+		return flonum(math.Inf(1)), nil
+	case strings.Contains(text, "/"):
+		slash := strings.IndexByte(text, '/')
+		numerator, _ := new(big.Int).SetString(text[:slash], radix)
+		denominator, _ := new(big.Int).SetString(text[slash+1:], radix)
+		if denominator.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero: %s", text)
+		}
+		x = normRat(new(big.Rat).SetFrac(numerator, denominator))
+	case radix == 10 && strings.ContainsAny(text, ".e"):
+		if exactness != scan.Exact {
+			// ParseFloat reports a range error for literals such as 1e400,
+			// and returns the appropriately signed infinity (or zero).
+			float, _ := strconv.ParseFloat(text, 64)
+			return flonum(float), nil
+		}
+		rational, _ := new(big.Rat).SetString(text)
+		x = normRat(rational)
+	default:
+		integer, _ := new(big.Int).SetString(text, radix)
+		x = normInt(integer)
+	}
+	if exactness == scan.Inexact {
+		return inexact("read", x), nil
 	}
+	return x, nil
 }
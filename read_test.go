@@ -0,0 +1,155 @@
+package lisp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+func readString(input string) (scmer, error) {
+	return read(scan.NewScanner("<string>", strings.NewReader(input)))
+}
+
+func TestReadDottedList(t *testing.T) {
+	for input, want := range map[string]string{
+		"(a . b)":          "(a . b)",
+		"(x y . rest)":     "(x y . rest)",
+		"(a . (b . (c)))":  "(a b c)",
+		"(a . ())":         "(a)",
+		"((a . b) . c)":    "((a . b) . c)",
+		"(lambda (a . r))": "(lambda (a . r))",
+	} {
+		if got, err := readString(input); err != nil {
+			t.Errorf("read %q: unexpected error: %v", input, err)
+		} else if got.String() != want {
+			t.Errorf("read %q: wanted %s, got %s", input, want, got)
+		}
+	}
+}
+
+func TestReadMalformedDottedList(t *testing.T) {
+	for _, input := range []string{
+		"(. a)",
+		"(a .)",
+		"(a . b c)",
+		"(a . b",
+		"(a .",
+		".",
+	} {
+		if got, err := readString(input); err == nil {
+			t.Errorf("read %q: wanted an error, got %s", input, got)
+		}
+	}
+}
+
+func TestReadNumber(t *testing.T) {
+	for input, want := range map[string]string{
+		"#xff":                 "255",
+		"#e1.25":               "5/4",
+		"#i1/8":                "0.125",
+		"-0.0":                 "-0.0",
+		"1e400":                "+inf.0",
+		"-1e400":               "-inf.0",
+		"1e-400":               "0.0",
+		"6/4":                  "3/2",
+		"#b-1/10":              "-1/2",
+		"1+i":                  "1+1i",
+		"#i1+i":                "1.0+1.0i",
+		"1.5+0i":               "1.5+0.0i",
+		"1+0i":                 "1",
+		"12345678901234567890": "12345678901234567890",
+	} {
+		if got, err := readString(input); err != nil {
+			t.Errorf("read %q: unexpected error: %v", input, err)
+		} else if got.String() != want {
+			t.Errorf("read %q: wanted %s, got %s", input, want, got)
+		}
+	}
+}
+
+func TestReadMalformedNumber(t *testing.T) {
+	for _, input := range []string{
+		"#xZZ",
+		"#e+inf.0",
+		"#e+nan.0",
+		"1/0",
+		"#b12",
+		"#e#i1",
+	} {
+		if got, err := readString(input); err == nil {
+			t.Errorf("read %q: wanted an error, got %s", input, got)
+		}
+	}
+}
+
+func TestReadString(t *testing.T) {
+	for input, want := range map[string]string{
+		`"abc"`:             "abc",
+		`"a\nb"`:            "a\nb",
+		`"say \"hi\"\\"`:    `say "hi"\`,
+		`"\x3bb; λ"`:        "λ λ",
+		"\"one \\\n  two\"": "one two",
+	} {
+		if got, err := readString(input); err != nil {
+			t.Errorf("read %s: unexpected error: %v", input, err)
+		} else if !equal(got, makeStr(want)) {
+			t.Errorf("read %s: wanted %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	for _, s := range []string{"", "abc", "a\nb\tc", `"\`, "\x00\x7f\u0085", "λ"} {
+		written := makeStr(s).String()
+		if got, err := readString(written); err != nil {
+			t.Errorf("read %s: unexpected error: %v", written, err)
+		} else if !equal(got, makeStr(s)) {
+			t.Errorf("wrote %q as %s, which read back as %q", s, written, got)
+		}
+	}
+	if got := display(makeList(makeStr("a b"), char('c'))); got != "(a b c)" {
+		t.Errorf(`display of ("a b" #\c) = %s; wanted (a b c)`, got)
+	}
+}
+
+func TestReadVector(t *testing.T) {
+	for _, input := range []string{
+		"#()",
+		"#(1 (2 3) #(4) \"five\" #\\6)",
+		"#u8()",
+		"#u8(0 127 255)",
+	} {
+		if got, err := readString(input); err != nil {
+			t.Errorf("read %s: unexpected error: %v", input, err)
+		} else if got.String() != input {
+			t.Errorf("read %s: printed back as %s", input, got)
+		}
+	}
+	for _, input := range []string{"#(1 . 2)", "#(1 2", "#u8(256)", "#u8(1.0)", "#u8(a)"} {
+		if got, err := readString(input); err == nil {
+			t.Errorf("read %q: wanted an error, got %s", input, got)
+		}
+	}
+}
+
+// TestReadErrorPosition checks that a read error says where in the input it
+// was found.
+func TestReadErrorPosition(t *testing.T) {
+	for input, want := range map[string]string{
+		`"a\q"`:       "<string>:1:1: bad escape sequence `\\q` in string",
+		"(1\n . 2 3)": "<string>:2:6: illegal use of `.`: more than one datum follows it in list: (1 . 2)",
+		" #u8(1 256)": "<string>:1:2: bad bytevector element: 256",
+		"(a\n  (b c)": "<string>:1:1: unterminated list: (a (b c) #%EOF)",
+		"(a . )":      "<string>:1:4: illegal use of `.`: nothing follows it in list: (a)",
+		"  )":         "<string>:1:3: unexpected token: )",
+		"#(1 #0#)":    "<string>:1:5: undefined datum label: #0#",
+		"(1/0)":       "<string>:1:2: division by zero: 1/0",
+	} {
+		if got, err := readString(input); err == nil {
+			t.Errorf("read %q: wanted an error, got %s", input, got)
+		} else if err.Error() != want {
+			t.Errorf("read %q: wanted error %q, got %q", input, want, err)
+		}
+	}
+}
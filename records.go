@@ -0,0 +1,175 @@
+package lisp
+
+import "strings"
+
+/*
+ Records
+
+ define-record-type (R7RS section 5.5) defines a record type, with a
+ constructor, a predicate, and an accessor and perhaps a modifier for each
+ field. The definition expands into a define of each of them, whose values
+ are made by procedures that the expansion contains, so that a record type
+ can be defined wherever a variable can: at top level, or in a body.
+*/
+
+type recordType struct {
+	name   symbol
+	fields []symbol
+}
+
+type record struct {
+	rtype  *recordType
+	fields []scmer
+}
+
+func (x *recordType) String() string {
+	return "#<record-type:" + string(x.name) + ">"
+}
+
+// String returns x as #<name>, where name is that of x's type, without the
+// angle brackets that conventionally surround it.
+func (x *record) String() string {
+	return "#<" + strings.TrimSuffix(strings.TrimPrefix(string(x.rtype.name), "<"), ">") + ">"
+}
+
+// field returns the index of the named field of t, or fails on behalf of the
+// named procedure.
+func (t *recordType) field(who, name scmer) int {
+	for i, f := range t.fields {
+		if f == name {
+			return i
+		}
+	}
+	Fail("define-record-type: %s: %s is not a field of %s", who, name, t.name)
+	return -1
+}
+
+// asRecord returns x as a record of type t, or fails on behalf of the named
+// procedure.
+func (t *recordType) asRecord(who, x scmer) *record {
+	r, ok := x.(*record)
+	if !ok || r.rtype != t {
+		Fail("%s: expected a record of type %s, got %s", who, t.name, x)
+	}
+	return r
+}
+
+// recordTypeNames returns the identifiers that (define-record-type type
+// (constructor field ...) predicate (field accessor [modifier]) ...) defines,
+// as far as the form has them.
+func recordTypeNames(x *pair) []scmer {
+	form, ok := listToSlice(x)
+	if !ok || len(form) < 4 {
+		return nil
+	}
+	names := []scmer{form[1], form[3]}
+	if ctor, ok := form[2].(*pair); ok {
+		names = append(names, ctor.car)
+	}
+	for _, spec := range form[4:] {
+		if spec, ok := listToSlice(spec); ok && len(spec) > 1 {
+			names = append(names, spec[1:]...)
+		}
+	}
+	return names
+}
+
+// expandDefineRecordType expands (define-record-type type (constructor
+// field ...) predicate (field accessor [modifier]) ...) as
+//
+//	(begin (define type (make-record-type 'type '(field ...)))
+//	       (define constructor (record-constructor type 'constructor '(field ...)))
+//	       (define predicate (record-predicate type 'predicate))
+//	       (define accessor (record-accessor type 'accessor 'field))
+//	       (define modifier (record-modifier type 'modifier 'field)) ...)
+func expandDefineRecordType(form []scmer, e *senv) scmer {
+	if len(form) < 4 {
+		Fail("bad syntax: define-record-type requires a type, a constructor and a predicate: %s", syntaxToDatum(makeList(form...)))
+	}
+	quote := func(x scmer) scmer { return makeList(introduce("quote"), syntaxToDatum(x)) }
+	define := func(name, value scmer) scmer {
+		if !isIdentifier(name) {
+			Fail("bad syntax: define-record-type: not an identifier: %s", syntaxToDatum(name))
+		}
+		return makeList(introduce("define"), name, value)
+	}
+	typ := form[1]
+	var fields []scmer
+	var specs [][]scmer
+	for _, x := range form[4:] {
+		spec, ok := listToSlice(x)
+		if !ok || len(spec) < 2 || len(spec) > 3 || !isIdentifier(spec[0]) {
+			Fail("bad syntax: define-record-type: bad field: %s", syntaxToDatum(x))
+		}
+		fields, specs = append(fields, spec[0]), append(specs, spec)
+	}
+	ctor, ok := listToSlice(form[2])
+	if !ok || len(ctor) == 0 {
+		Fail("bad syntax: define-record-type: bad constructor: %s", syntaxToDatum(form[2]))
+	}
+	defines := []scmer{
+		define(typ, makeList(makeRecordType, quote(typ), quote(makeList(fields...)))),
+		define(ctor[0], makeList(recordConstructor, typ, quote(ctor[0]), quote(makeList(ctor[1:]...)))),
+		define(form[3], makeList(recordPredicate, typ, quote(form[3]))),
+	}
+	for _, spec := range specs {
+		defines = append(defines, define(spec[1], makeList(recordAccessor, typ, quote(spec[1]), quote(spec[0]))))
+		if len(spec) == 3 {
+			defines = append(defines, define(spec[2], makeList(recordModifier, typ, quote(spec[2]), quote(spec[0]))))
+		}
+	}
+	return expand(&pair{introduce("begin"), makeList(defines...)}, e)
+}
+
+// makeRecordType, recordConstructor, recordPredicate, recordAccessor and
+// recordModifier make the record type and procedures of a define-record-type.
+var (
+	makeRecordType = &primitive{"make-record-type", arity{2, 2}, func(a ...scmer) scmer {
+		t := &recordType{name: a[0].(symbol)}
+		names, _ := listToSlice(a[1])
+		for _, name := range names {
+			t.fields = append(t.fields, name.(symbol))
+		}
+		return t
+	}}
+	recordConstructor = &primitive{"record-constructor", arity{3, 3}, func(a ...scmer) scmer {
+		t, who := a[0].(*recordType), a[1]
+		names, _ := listToSlice(a[2])
+		indexes := make([]int, len(names))
+		for i, name := range names {
+			indexes[i] = t.field(who, name)
+		}
+		return &primitive{who.(symbol), arity{len(indexes), len(indexes)}, func(a ...scmer) scmer {
+			r := &record{t, make([]scmer, len(t.fields))}
+			for i := range r.fields {
+				r.fields[i] = boolean(false)
+			}
+			for i, x := range a {
+				r.fields[indexes[i]] = x
+			}
+			return r
+		}}
+	}}
+	recordPredicate = &primitive{"record-predicate", arity{2, 2}, func(a ...scmer) scmer {
+		t := a[0].(*recordType)
+		return &primitive{a[1].(symbol), arity{1, 1}, func(a ...scmer) scmer {
+			r, ok := a[0].(*record)
+			return boolean(ok && r.rtype == t)
+		}}
+	}}
+	recordAccessor = &primitive{"record-accessor", arity{3, 3}, func(a ...scmer) scmer {
+		t, who := a[0].(*recordType), a[1]
+		i := t.field(who, a[2])
+		return &primitive{who.(symbol), arity{1, 1}, func(a ...scmer) scmer {
+			return t.asRecord(who, a[0]).fields[i]
+		}}
+	}}
+	recordModifier = &primitive{"record-modifier", arity{3, 3}, func(a ...scmer) scmer {
+		t, who := a[0].(*recordType), a[1]
+		i := t.field(who, a[2])
+		return &primitive{who.(symbol), arity{2, 2}, func(a ...scmer) scmer {
+			t.asRecord(who, a[0]).fields[i] = a[1]
+			return symbol("#%set!")
+		}}
+	}}
+)
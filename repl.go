@@ -1,106 +1,15 @@
-// Based on https://github.com/robpike/ivy/blob/master/ivy.go
-package main
+package lisp
 
 import (
-	"bufio"
-	"flag"
 	"fmt"
 	"io"
-	"os"
-	"reflect"
-	"strings"
 
 	"github.com/perlmonger42/LiSP/scan"
 )
 
-var (
-	execute = flag.Bool("e", false, "execute arguments as a single expression")
-	testing = flag.Bool("test", false, "execute Read Eval Read Compare Loop")
-	// format  = flag.String("format", "", "use `fmt` as format for printing numbers; empty sets default format")
-	// gformat = flag.Bool("g", false, `shorthand for -format="%.12g"`)
-	// maxbits   = flag.Uint("maxbits", 1e9, "maximum size of an integer, in bits; 0 means no limit")
-	// maxdigits = flag.Uint("maxdigits", 1e4, "above this many `digits`, integers print as floating point; 0 disables")
-	prompt  = flag.String("prompt", "> ", "command `prompt`")
-	prompt2 = flag.String("prompt2", "? ", "continued command `prompt`")
-	// debugFlag = flag.String("debug", "", "comma-separated `names` of debug settings to enable")
-)
-
-func init() {
-	flag.BoolVar(&Tracing, "trace", false, "print exprs before and after eval")
-}
-
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: LiSP [options] [file ...]\n")
-	fmt.Fprintf(os.Stderr, "Flags:\n")
-	flag.PrintDefaults()
-	os.Exit(2)
-}
-
-func main() {
-	flag.Usage = usage
-	flag.Parse()
-
-	if *execute {
-		stringReader := strings.NewReader(strings.Join(flag.Args(), " "))
-		scanner := scan.NewScanner("<args>", stringReader)
-		Run(scanner, false)
-		return
-	}
-
-	if flag.NArg() > 0 {
-		for i := 0; i < flag.NArg(); i++ {
-			name := flag.Arg(i)
-			var reader io.ByteReader
-			interactive := false
-			if name == "-" {
-				interactive = true
-				name = "<stdin>"
-				reader = scan.NewConsoleReader()
-			} else {
-				if f, err := os.Open(name); err != nil {
-					fmt.Fprintf(os.Stderr, "LiSP: %s\n", err)
-					os.Exit(1)
-				} else {
-					reader = bufio.NewReader(f)
-				}
-			}
-			scanner := scan.NewScanner(name, reader)
-			if ok := Run(scanner, interactive); !ok {
-				break
-			}
-		}
-		return
-	}
-
-	scanner := scan.NewScanner("<stdin>", scan.NewConsoleReader())
-	Run(scanner, true)
-}
-
-func Run(scanner *scan.Scanner, interactive bool) bool {
-	var err error
-	if *testing {
-		err = Rercl(scanner, interactive)
-	} else {
-		err = Repl(scanner, interactive)
-	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-	}
-	return err == nil
-}
-
-// runArgs executes the text of the command-line arguments as a LiSP program.
-func runArgs() {
-	stringReader := strings.NewReader(strings.Join(flag.Args(), " "))
-	scanner := scan.NewScanner("<args>", stringReader)
-	Repl(scanner, false)
-}
-
-var Failure error
-
+// Fail raises an error whose message is formatted as by fmt.Sprintf.
 func Fail(format string, a ...interface{}) {
-	Failure = fmt.Errorf(format, a...)
-	panic(Failure)
+	panic(&errorObject{makeStr(fmt.Sprintf(format, a...)), empty, nil})
 }
 
 // Repl is a Read, Eval, Print Loop.
@@ -136,29 +45,50 @@ func Rep(scanner *scan.Scanner, interactive bool) error {
 //   then err is the evaluation error; else datum is what was read and value
 //   is the result of evaluating datum.
 func ReadEval(scanner *scan.Scanner) (datum scmer, value scmer, err error) {
-	defer func() {
-		if Failure != nil {
-			r := recover()
-			if r == nil {
-				err = Failure
-			} else if e, ok := r.(error); ok && e == Failure {
-				err = Failure
-			} else {
-				fmt.Printf("Error: %s\n", Failure)
-				panic(r)
-			}
-		}
-	}()
+	defer recoverError(&err)
 
-	Failure = nil
+	winders = nil                  // in case an error escaped from a dynamic-wind
+	handlers = nil                 // or from a with-exception-handler
+	currentOutput = standardOutput // or from a with-output-to-string
 	if datum, err = read(scanner); err != nil {
 		// Read error, so skip evaluation (includes err == io.EOF)
-	} else if value = TopLevelEvaluate(datum); value == nil {
+		return
+	}
+	skipBlankRest(scanner)
+	if value = TopLevelEvaluate(datum); value == nil {
 		err = fmt.Errorf("Evaluate failed (returned nil)")
 	}
 	return
 }
 
+// skipBlankRest skips the rest of the line that scanner is on, if it is blank,
+// so that a program reading the same input (as the current input port) starts
+// on the line after the expression.
+func skipBlankRest(scanner *scan.Scanner) {
+	for {
+		r, ok := scanner.PeekRune()
+		if !ok || r != ' ' && r != '\t' && r != '\r' && r != '\n' {
+			return
+		}
+		scanner.NextRune()
+		if r == '\n' {
+			return
+		}
+	}
+}
+
+// recoverError, when deferred, reports an uncaught exception, a failure (see
+// Fail), or a Go runtime error in *err.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok {
+			*err = e
+		} else {
+			panic(r)
+		}
+	}
+}
+
 var expectError, dontCare symbol
 
 // Rercl is a Read, Eval, Read, Compare LOOP.
@@ -182,6 +112,12 @@ func Rercl(scanner *scan.Scanner, interactive bool) error {
 			failures += 1
 		}
 	}
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil && failures > 0 {
+		err = fmt.Errorf("%d test(s) failed", failures)
+	}
 	return err
 }
 
@@ -208,7 +144,7 @@ func Rerc(scanner *scan.Scanner) (failed bool, err error) {
 		// end of input; do nothing
 	} else if datum == nil {
 		// read error; do nothing more
-	} else if expect, err2 = read(scanner); err2 != nil {
+	} else if expect, err2 = readData(scanner); err2 != nil {
 		err = fmt.Errorf("RERC: failed while reading expected value: %s", err2)
 	} else if expect == dontCare {
 		if err != nil {
@@ -221,7 +157,7 @@ func Rerc(scanner *scan.Scanner) (failed bool, err error) {
 		err = nil
 	} else if err != nil {
 		fail("unexpected error")
-	} else if !reflect.DeepEqual(value, expect) { // TODO: implement (equal? ...)
+	} else if !equal(value, expect) {
 		fail("unexpected value")
 	}
 	return
@@ -0,0 +1,180 @@
+package scan
+
+import "strings"
+
+// Exactness is the exactness a number's prefix asks for.
+type Exactness int
+
+const (
+	Unmarked Exactness = iota // no prefix: exact unless written as a decimal
+	Exact                     // #e
+	Inexact                   // #i
+)
+
+// A Numeral is the syntax of a numeric literal, split into its parts.
+//
+// This uses the definition from R7RS (section 7.1.1):
+//
+//	<number>  ::= <prefix> <complex>
+//	<prefix>  ::= <radix> <exactness> | <exactness> <radix>
+//	<complex> ::= <real> | <real> @ <real>
+//	            | <real>? + <ureal>? i | <real>? - <ureal>? i
+//	            | <real>? <infnan> i
+//	<real>    ::= <sign> <ureal> | <infnan>
+//	<ureal>   ::= <uinteger> | <uinteger> / <uinteger> | <decimal>
+//	<infnan>  ::= +inf.0 | -inf.0 | +nan.0 | -nan.0
+//
+// where a <radix> is #b, #o, #d or #x, an <exactness> is #e or #i, and
+// decimals (such as 1.5, .5, 5. and 1e-3) may only be written in radix 10.
+// Letters may be upper or lower case.
+type Numeral struct {
+	Radix     int // 2, 8, 10 or 16
+	Exactness Exactness
+	Real      string // the real part, or magnitude; "" if it was omitted
+	Imag      string // the imaginary part (with its sign, without the i), or angle
+	Polar     bool   // true if the number was written as Real@Imag
+}
+
+// ParseNumber splits text into the parts of a Numeral. The second result is
+// false if text is not a number.
+func ParseNumber(text string) (Numeral, bool) {
+	n := Numeral{Radix: 10}
+	text = strings.ToLower(text)
+	radixSeen, exactnessSeen := false, false
+	for len(text) >= 2 && text[0] == '#' {
+		switch c := text[1]; {
+		case strings.IndexByte("bodx", c) >= 0 && !radixSeen:
+			n.Radix = map[byte]int{'b': 2, 'o': 8, 'd': 10, 'x': 16}[c]
+			radixSeen = true
+		case c == 'e' && !exactnessSeen:
+			n.Exactness = Exact
+			exactnessSeen = true
+		case c == 'i' && !exactnessSeen:
+			n.Exactness = Inexact
+			exactnessSeen = true
+		default:
+			return n, false
+		}
+		text = text[2:]
+	}
+
+	r := realLength(text, n.Radix, false)
+	switch {
+	case r > 0 && r == len(text):
+		n.Real = text
+		return n, true
+	case r > 0 && text[r] == '@':
+		angle := text[r+1:]
+		if a := realLength(angle, n.Radix, false); a > 0 && a == len(angle) {
+			n.Real, n.Imag, n.Polar = text[:r], angle, true
+			return n, true
+		}
+		return n, false
+	case !strings.HasSuffix(text, "i"):
+		return n, false
+	}
+
+	// The imaginary part begins with a sign. If the real part is omitted, a
+	// greedy scan of it may have taken the whole imaginary part, as in +2i.
+	body := text[:len(text)-1]
+	for _, r := range []int{r, 0} {
+		imag := body[r:]
+		if imag == "+" || imag == "-" {
+			n.Real, n.Imag = body[:r], imag+"1"
+			return n, true
+		}
+		if i := realLength(imag, n.Radix, true); i > 0 && i == len(imag) {
+			n.Real, n.Imag = body[:r], imag
+			return n, true
+		}
+	}
+	return n, false
+}
+
+// realLength returns the length of the longest prefix of s that is a real
+// number in the given radix, or 0 if there is none. If signed is true, the
+// number must begin with a sign.
+func realLength(s string, radix int, signed bool) int {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+		if strings.HasPrefix(s[i:], "inf.0") || strings.HasPrefix(s[i:], "nan.0") {
+			return i + len("inf.0")
+		}
+	} else if signed {
+		return 0
+	}
+
+	integer := digitsLength(s[i:], radix)
+	i += integer
+	if integer > 0 && i < len(s) && s[i] == '/' {
+		if d := digitsLength(s[i+1:], radix); d > 0 {
+			return i + 1 + d
+		}
+		return i
+	}
+	if radix != 10 {
+		if integer == 0 {
+			return 0
+		}
+		return i // an integer
+	}
+
+	// a decimal: digits with an optional point, then an optional exponent
+	if i < len(s) && s[i] == '.' {
+		fraction := digitsLength(s[i+1:], 10)
+		if integer == 0 && fraction == 0 {
+			return 0
+		}
+		i += 1 + fraction
+	} else if integer == 0 {
+		return 0
+	}
+	if i < len(s) && s[i] == 'e' {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if d := digitsLength(s[j:], 10); d > 0 {
+			i = j + d
+		}
+	}
+	return i
+}
+
+// digitsLength returns the length of the run of digits in the given radix
+// at the start of s.
+func digitsLength(s string, radix int) int {
+	for i := 0; i < len(s); i++ {
+		if digitValue(s[i]) >= radix {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// digitValue returns the value of the (lower case) digit c, or 36 if c is not
+// a digit.
+func digitValue(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'z':
+		return int(c-'a') + 10
+	}
+	return 36
+}
+
+// numberType returns the type of token for a number.
+func (n Numeral) numberType() Type {
+	switch {
+	case n.Imag != "":
+		return Complex
+	case strings.Contains(n.Real, "/"):
+		return Rational
+	case n.Radix == 10 && strings.ContainsAny(n.Real, ".e"),
+		strings.HasSuffix(n.Real, "inf.0"), strings.HasSuffix(n.Real, "nan.0"):
+		return Flonum
+	}
+	return Fixnum
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want Numeral
+	}{
+		{"42", Numeral{Radix: 10, Real: "42"}},
+		{"-17", Numeral{Radix: 10, Real: "-17"}},
+		{"#xFF", Numeral{Radix: 16, Real: "ff"}},
+		{"#e#b101", Numeral{Radix: 2, Exactness: Exact, Real: "101"}},
+		{"#o#i17", Numeral{Radix: 8, Exactness: Inexact, Real: "17"}},
+		{"1.5e-3", Numeral{Radix: 10, Real: "1.5e-3"}},
+		{".5", Numeral{Radix: 10, Real: ".5"}},
+		{"5.", Numeral{Radix: 10, Real: "5."}},
+		{"-3/4", Numeral{Radix: 10, Real: "-3/4"}},
+		{"+inf.0", Numeral{Radix: 10, Real: "+inf.0"}},
+		{"1+2i", Numeral{Radix: 10, Real: "1", Imag: "+2"}},
+		{"1.5-i", Numeral{Radix: 10, Real: "1.5", Imag: "-1"}},
+		{"+2i", Numeral{Radix: 10, Imag: "+2"}},
+		{"+i", Numeral{Radix: 10, Imag: "+1"}},
+		{"-inf.0i", Numeral{Radix: 10, Imag: "-inf.0"}},
+		{"1/2+nan.0i", Numeral{Radix: 10, Real: "1/2", Imag: "+nan.0"}},
+		{"#x-a+bi", Numeral{Radix: 16, Real: "-a", Imag: "+b"}},
+		{"1e3+1e-3i", Numeral{Radix: 10, Real: "1e3", Imag: "+1e-3"}},
+		{"2@-1.5", Numeral{Radix: 10, Real: "2", Imag: "-1.5", Polar: true}},
+	} {
+		got, ok := ParseNumber(c.text)
+		if !ok || got != c.want {
+			t.Errorf("ParseNumber(%q) = %+v, %v; want %+v", c.text, got, ok, c.want)
+		}
+	}
+}
+
+func TestParseNumberRejects(t *testing.T) {
+	for _, text := range strings.Fields(`
+		+ - . ... i 1+ 1/ /2 1/2/3 1.2.3 1e 1e+ 0x1F 1_000 inf nan.0
+		#x1.5 #b2 #e#e1 #x#d1 #q1 1+2 1@ @1 1+2j ++1 1/-2 1.5/2 +inf.0x`) {
+		if n, ok := ParseNumber(text); ok {
+			t.Errorf("ParseNumber(%q) = %+v; want failure", text, n)
+		}
+	}
+}
+
+func TestNumberPrefix(t *testing.T) {
+	scanner := NewScanner("<string>", strings.NewReader("#x#i10 #E#o7 12"))
+	for _, want := range []Token{
+		{Type: Fixnum, Col: 1, Text: "#x#i10", Radix: 16, Exactness: Inexact},
+		{Type: Fixnum, Col: 8, Offset: 7, Text: "#E#o7", Radix: 8, Exactness: Exact},
+		{Type: Fixnum, Col: 14, Offset: 13, Text: "12", Radix: 10, Exactness: Unmarked},
+	} {
+		got := scanner.Next()
+		got.Line = 0
+		if got != want {
+			t.Errorf("got token %+v, want %+v", got, want)
+		}
+	}
+}
@@ -11,15 +11,26 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 	// "github.com/perlmonger42/LiSP/config" //// config not yet supported
 )
 
 // Token represents a token or text string returned from the scanner.
 type Token struct {
-	Type Type   // The type of this item.
-	Line int    // The line number on which this token appears
-	Text string // The text of this item.
+	Type   Type   // The type of this item.
+	Line   int    // The line on which it begins, counting from 1
+	Col    int    // The column, in runes, at which it begins, counting from 1
+	Offset int    // The offset, in bytes, of its beginning in the input
+	Text   string // The text of this item.
+
+	// For a number, the radix and exactness given by its prefix.
+	Radix     int
+	Exactness Exactness
+
+	// Whether #!fold-case was in effect, so that a symbol's name is to be
+	// case-folded.
+	FoldCase bool
 }
 
 // Type identifies the type of lex items.
@@ -41,14 +52,20 @@ const (
 	True            // "#t"
 	Dot             // "."
 	Ellipsis        // "..."
-	Fixnum          // a number with no fractional component
-	Flonum          // a number with a fractional component
-	String          // quoted string (includes quotes)
+	Fixnum          // an integer, such as 42 or #x-1F
+	Flonum          // a decimal, infinity or NaN, such as 1.5e3 or +inf.0
+	Rational        // a ratio of integers, such as 2/3
+	Complex         // a complex number, such as 1+2i or 1@1.57
+	String          // quoted string (includes quotes and escapes)
 	Symbol          // a Scheme symbol
 	RightParen      // ')'
 	RightBrack      // ']'
 	RightBrace      // '}'
 	CharLiteral     // '#\space', e.g.
+	Vector          // "#(", which opens a vector
+	Bytevector      // "#u8(", which opens a bytevector
+	DatumLabel      // "#0=", which labels the datum that follows
+	DatumRef        // "#0#", which refers to a labeled datum
 
 	// Ivy tokens
 	Assign         // '='
@@ -58,7 +75,6 @@ const (
 	Number         // simple number
 	Operator       // known operator
 	Op             // "op", operator definition keyword
-	Rational       // rational number like 2/3
 	Semicolon      // ';'
 	Space          // run of spaces separating
 )
@@ -90,11 +106,16 @@ type Scanner struct {
 	buf    []byte
 	input  string  // the line of text being scanned.
 	state  stateFn // the next lexing function to enter
-	line   int     // line number in input
+	line   int     // line number of the start of this item
+	col    int     // column, in runes, of the start of this item
+	offset int     // offset, in bytes, of the start of this item in the whole input
+	crs    []int   // the positions in input just after carriage returns stripped from it
 	pos    int     // current position in the input
 	start  int     // start position of this item
 	width  int     // width of last rune read from input
 
+	foldCase bool // whether #!fold-case is in effect
+
 	lookahead bool  // Peek is usable
 	Lookahead Token // The lookahead token
 }
@@ -104,20 +125,27 @@ type Scanner struct {
 // It strips carriage returns to make subsequent processing simpler.
 func (l *Scanner) loadLine() {
 	l.buf = l.buf[:0]
+	text := l.tokenText()
+	for i := range l.crs {
+		l.crs[i] -= l.start
+	}
 	for {
 		c, err := l.r.ReadByte()
 		if err != nil {
 			l.done = true
 			break
 		}
-		if c != '\r' {
-			l.buf = append(l.buf, c)
+		if c == '\r' {
+			// advance counts it in the offset
+			l.crs = append(l.crs, len(text)+len(l.buf))
+			continue
 		}
+		l.buf = append(l.buf, c)
 		if c == '\n' {
 			break
 		}
 	}
-	l.input = l.tokenText() + string(l.buf)
+	l.input = text + string(l.buf)
 	l.pos -= l.start
 	l.start = 0
 }
@@ -160,8 +188,24 @@ func (l *Scanner) backup() {
 	l.pos -= l.width
 }
 
-func (l *Scanner) newline() {
-	l.line++
+// advance moves the start of the next item to the current position, keeping
+// track of the line, column and offset at which it begins.
+func (l *Scanner) advance() {
+	text := l.input[l.start:l.pos]
+	for _, r := range text {
+		if isLineEnding(r) {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.offset += len(text)
+	for len(l.crs) > 0 && l.crs[0] <= l.pos {
+		l.crs = l.crs[1:]
+		l.offset++
+	}
+	l.start = l.pos
 }
 
 func (l *Scanner) tokenText() string {
@@ -177,17 +221,32 @@ func (l *Scanner) emit(t Type) {
 	//	fmt.Fprintf(config.Output(), "%s:%d: emit %s\n", l.name, l.line, Token{t, l.line, s})
 	//}
 	//fmt.Printf("%s:%d: emit %s\n", l.name, l.line, Token{t, l.line, s})
-	token := Token{t, l.line, s}
+	token := Token{Type: t, Line: l.line, Col: l.col, Offset: l.offset, Text: s, FoldCase: l.foldCase}
 	//fmt.Printf("    emit %s:%d: emit %s\n", l.name, l.line, token) //DEBUG
 	l.tokens <- token
-	l.start = l.pos
+	l.advance()
+	l.width = 0
+}
+
+// emitNumber passes a number back to the client.
+func (l *Scanner) emitNumber(n Numeral) {
+	l.tokens <- Token{
+		Type:      n.numberType(),
+		Line:      l.line,
+		Col:       l.col,
+		Offset:    l.offset,
+		Text:      l.tokenText(),
+		Radix:     n.Radix,
+		Exactness: n.Exactness,
+	}
+	l.advance()
 	l.width = 0
 }
 
 // ignore skips over the pending input before this point.
 func (l *Scanner) ignore() {
 	//fmt.Printf("    ignore text\n") //DEBUG
-	l.start = l.pos
+	l.advance()
 }
 
 // accept consumes the next rune if it's from the valid set.
@@ -250,9 +309,11 @@ func (l *Scanner) error(msg string) stateFn {
 	return l.errorf("%s `%s`", msg, l.tokenText())
 }
 
-// errorf returns an error token and continues to scan.
+// errorf returns an error token, at the start of the bad item, and continues
+// to scan after it.
 func (l *Scanner) errorf(format string, args ...interface{}) stateFn {
-	l.tokens <- Token{Error, l.start, fmt.Sprintf(format, args...)}
+	l.tokens <- Token{Type: Error, Line: l.line, Col: l.col, Offset: l.offset, Text: fmt.Sprintf(format, args...)}
+	l.advance()
 	return lexAny
 }
 
@@ -263,12 +324,18 @@ func NewScanner( /* conf *config.T // config not yet supported, */ name string,
 		name: name,
 		//// conf:   conf, // config not yet supported
 		line:   1,
+		col:    1,
 		tokens: make(chan Token, 2), // We need a little room to save tokens.
 		state:  lexAny,
 	}
 	return l
 }
 
+// Name returns the name of the input.
+func (l *Scanner) Name() string {
+	return l.name
+}
+
 // Next returns the next token.
 func (l *Scanner) Next() (result Token) {
 	// We have up to one token of lookahead.
@@ -292,7 +359,27 @@ func (l *Scanner) Next() (result Token) {
 		close(l.tokens)
 		l.tokens = nil
 	}
-	return Token{EOF, l.pos, "<EOF>"}
+	l.advance()
+	return Token{Type: EOF, Line: l.line, Col: l.col, Offset: l.offset, Text: "<EOF>"}
+}
+
+// NextRune returns the next rune of the input that has not been scanned, and
+// advances past it. It reports false at the end of the input. It lets a
+// reader of characters share the input with Next, between tokens: it must
+// not be called while Peek is holding a token.
+func (l *Scanner) NextRune() (rune, bool) {
+	r := l.next()
+	if r == eof {
+		return 0, false
+	}
+	l.ignore()
+	return r, true
+}
+
+// PeekRune returns the rune that NextRune would, without advancing past it.
+func (l *Scanner) PeekRune() (rune, bool) {
+	r := l.peek()
+	return r, r != eof
 }
 
 func (l *Scanner) Peek() (result Token) {
@@ -328,7 +415,6 @@ func lexLineComment(l *Scanner) stateFn {
 			return lexAny
 		}
 	}
-	l.newline()
 	l.ignore()
 	return lexAny
 }
@@ -364,7 +450,6 @@ func lexAny(l *Scanner) stateFn {
 	case r == eof:
 		return nil
 	case l.isLineSeparator(r):
-		l.newline()
 		l.ignore()
 		return lexAny
 	case unicode.IsSpace(r):
@@ -391,7 +476,7 @@ func lexAny(l *Scanner) stateFn {
 	case r == '"':
 		return lexString
 	case r == '.':
-		if l.isDelimiter(l.peek()) {
+		if r := l.peek(); l.isDelimiter(r) && r != '|' && r != '\\' {
 			l.emit(Dot)
 			return lexAny
 		}
@@ -416,8 +501,12 @@ func lexAny(l *Scanner) stateFn {
 		return lexPoundSign
 	case r == '|':
 		return lexBarSymbol
+	case r == '\\':
+		// lexSymbol scans the escape.
+		l.backup()
+		return lexSymbol
 	default:
-		//  \, or anything else not listed above
+		// anything else not listed above
 		return lexSymbol
 	}
 }
@@ -427,11 +516,7 @@ func lexAny(l *Scanner) stateFn {
 func lexSpace(l *Scanner) stateFn {
 	//	fmt.Printf("lexSpace\n")//DEBUG
 	for unicode.IsSpace(l.peek()) {
-		r := l.next()
-		//		fmt.Printf("lexSpace: consuming '%c'\n", r)//DEBUG
-		if l.isLineSeparator(r) {
-			l.line++
-		}
+		l.next()
 	}
 	l.ignore()
 	return lexAny
@@ -440,13 +525,31 @@ func lexSpace(l *Scanner) stateFn {
 func lexPoundSign(l *Scanner) stateFn {
 	//	fmt.Printf("lexPoundSign\n")//DEBUG
 	r := l.next()
+	if '0' <= r && r <= '9' {
+		return lexDatumLabel
+	}
 	switch r {
 	case '%':
 		return lexSymbol
+	case 'x', 'X', 'b', 'B', 'o', 'O', 'd', 'D', 'e', 'E', 'i', 'I':
+		// a number prefix
+		return lexSymbol
 	case '|':
 		return lexBlockComment
+	case '!':
+		return lexDirective
 	case '\\':
 		return lexChar
+	case '(':
+		l.emit(Vector)
+		return lexAny
+	case 'u', 'U':
+		if l.accept("8") && l.accept("(") {
+			l.emit(Bytevector)
+			return lexAny
+		}
+		l.acceptIsRun(func(r rune) bool { return !l.isDelimiter(r) })
+		return l.error("bad # syntax")
 	case 't', 'f':
 		if l.isDelimiter(l.peek()) {
 			if r == 'f' {
@@ -462,6 +565,40 @@ func lexPoundSign(l *Scanner) stateFn {
 	return l.errorf("bad character following #: %#U", r)
 }
 
+// lexDatumLabel scans a datum label, #n= or #n#.
+// The # and the first digit have been consumed.
+func lexDatumLabel(l *Scanner) stateFn {
+	l.acceptRun("0123456789")
+	switch l.next() {
+	case '=':
+		l.emit(DatumLabel)
+		return lexAny
+	case '#':
+		l.emit(DatumRef)
+		return lexAny
+	}
+	l.backup()
+	l.acceptIsRun(func(r rune) bool { return !l.isDelimiter(r) })
+	return l.error("bad datum label")
+}
+
+// lexDirective scans a directive, #!fold-case or #!no-fold-case, which
+// turns case-folding of symbols and char names on or off for the rest of the
+// input. The #! has been consumed.
+func lexDirective(l *Scanner) stateFn {
+	l.acceptIsRun(func(r rune) bool { return !l.isDelimiter(r) })
+	switch l.tokenText() {
+	case "#!fold-case":
+		l.foldCase = true
+	case "#!no-fold-case":
+		l.foldCase = false
+	default:
+		return l.error("unknown directive")
+	}
+	l.ignore()
+	return lexAny
+}
+
 // lexSymbol scans a Scheme symbol
 //
 // This uses the definition from Racket.
@@ -504,39 +641,53 @@ Loop:
 		}
 	}
 
-	// If the symbol looks like a number, it is a number.
+	// If the symbol looks like a number, it is a number, unless some of it is
+	// quoted.
 	text := l.tokenText()
-	_, err := strconv.ParseInt(text, 0, 64)
-	if err == nil {
-		l.emit(Fixnum)
-	} else if err.(*strconv.NumError).Err == strconv.ErrRange {
-		return l.error("Bignums not yet implemented")
-	} else if _, err = strconv.ParseFloat(text, 64); err == nil {
-		l.emit(Flonum)
-	} else if err.(*strconv.NumError).Err == strconv.ErrRange {
-		return l.error("Bignums not yet implemented")
-	} else if err.(*strconv.NumError).Err == strconv.ErrSyntax {
+	if strings.ContainsAny(text, "|\\") {
+		if _, err := unquoteSymbol(text, false); err != nil {
+			return l.errorf("%s", err)
+		}
 		l.emit(Symbol)
+	} else if text == "..." {
+		l.emit(Ellipsis)
+	} else if n, ok := ParseNumber(text); ok {
+		l.emitNumber(n)
+	} else if text[0] == '#' && !strings.HasPrefix(text, "#%") {
+		return l.error("bad number syntax")
 	} else {
-		panic(fmt.Sprintf("unexpected strconv error on %q: %v", text, err))
+		l.emit(Symbol)
 	}
 	return lexAny
 }
 
+// lexBarSymbol scans the |-quoted part of a symbol, in which a \ escapes the
+// character after it. The opening | has been consumed.
 func lexBarSymbol(l *Scanner) stateFn {
 	//	fmt.Printf("lexBarSymbol\n")//DEBUG
-	for r := l.next(); r != eof && r != '|'; r = l.next() {
+	for {
+		switch r := l.next(); {
+		case r == '\\':
+			if l.next() == eof {
+				return l.errorf("unterminated |symbol|")
+			}
+		case r == eof:
+			return l.errorf("unterminated |symbol|")
+		case r == '|':
+			return lexSymbol
+		}
 	}
-	return lexSymbol
 }
 
 // isDelimiter reports whether the argument is a delimiter character.
 // Along with whitespace, the following characters are delimiters:
 //    ( ) [ ] { } " , ' ` ; # | \
 func (l *Scanner) isDelimiter(r rune) bool {
-	return unicode.IsSpace(r) ||
-		strings.IndexRune("()[]{}\",'`;#|\\", r) >= 0 ||
-		r == eof
+	return isDelimiterRune(r) || r == eof
+}
+
+func isDelimiterRune(r rune) bool {
+	return unicode.IsSpace(r) || strings.IndexRune("()[]{}\",'`;#|\\", r) >= 0
 }
 
 // lexChar scans a character constant. The leading #\ is already
@@ -550,10 +701,22 @@ func lexChar(l *Scanner) stateFn {
 	case r == 'U' && isHexDigit(l.peek()):
 		//fmt.Printf("6-digit unicode character\n")
 		l.acceptLimitedIsRun(isHexDigit, 6)
+	case r == 'x' && isHexDigit(l.peek()):
+		// R7RS's #\x<hex scalar value>
+		l.acceptIsRun(isHexDigit)
+		n, err := strconv.ParseInt(l.input[l.start+3:l.pos], 16, 64)
+		if err != nil || !l.isDelimiter(l.peek()) || !utf8.ValidRune(rune(n)) {
+			l.acceptIsRun(func(r rune) bool { return !l.isDelimiter(r) })
+			return l.error("bad character syntax")
+		}
 	case unicode.IsLetter(r) && unicode.IsLetter(l.peek()):
 		//fmt.Printf("named character\n")
 		l.acceptIsRun(unicode.IsLetter)
-		if namedCharacter(l.input[l.start+2:l.pos]) < 0 {
+		name := l.input[l.start+2 : l.pos]
+		if l.foldCase {
+			name = strings.ToLower(name)
+		}
+		if namedCharacter(name) < 0 {
 			return l.error("unrecognized character name")
 		}
 	case isOctDigit(r):
@@ -595,6 +758,8 @@ func namedCharacter(s string) rune {
 	switch s {
 	case "nul", "null":
 		return 0
+	case "alarm":
+		return '\007'
 	case "backspace":
 		return '\010'
 	case "tab":
@@ -607,9 +772,11 @@ func namedCharacter(s string) rune {
 		return '\014'
 	case "return":
 		return '\015'
+	case "escape":
+		return '\033'
 	case "space":
 		return '\040'
-	case "rubout":
+	case "rubout", "delete":
 		return '\177'
 	default:
 		return -1
@@ -625,37 +792,273 @@ func CharLiteralToRune(s string) rune {
 		if r, size := utf8.DecodeRuneInString(s[2:]); size > 0 {
 			return r
 		}
-	case s[2] == 'u' || s[2] == 'U': // runes > 3
+	case s[2] == 'u' || s[2] == 'U' || s[2] == 'x': // runes > 3
 		n, err := strconv.ParseInt(s[3:], 16, 64)
 		if err == nil && n <= unicode.MaxRune {
 			return rune(n)
 		}
 	default:
-		if r := namedCharacter(s[2:]); r >= 0 {
+		// The name may be in any case, if it was scanned under #!fold-case.
+		if r := namedCharacter(strings.ToLower(s[2:])); r >= 0 {
 			return r
 		}
 	}
 	panic(fmt.Sprintf("invalid char literal %q", s))
 }
 
-// lexString scans a quoted string.
+// lexString scans a quoted string, which may span lines. The escape sequences
+// in it are checked here, and decoded by StringLiteralToString.
 func lexString(l *Scanner) stateFn {
 	//	fmt.Printf("lexString\n")//DEBUG
 	for {
 		switch r := l.next(); {
 		case r == '\\':
-			if r := l.next(); r != eof && !l.isLineSeparator(r) {
-				break // switch
+			if l.next() == eof {
+				return l.errorf("unterminated quoted string")
 			}
-			fallthrough
-		case r == eof || l.isLineSeparator(r):
+		case r == eof:
 			return l.errorf("unterminated quoted string")
-			if r != eof {
-				l.newline()
-			}
 		case r == '"':
+			if _, err := unquoteString(l.tokenText()); err != nil {
+				return l.errorf("%s", err)
+			}
 			l.emit(String)
 			return lexAny
 		}
 	}
 }
+
+// StringLiteralToString returns the string that the string literal s (as
+// scanned, quotes included) stands for.
+func StringLiteralToString(s string) string {
+	text, err := unquoteString(s)
+	if err != nil {
+		panic(fmt.Sprintf("invalid string literal %q: %v", s, err))
+	}
+	return text
+}
+
+// unquoteString decodes the string literal s. These are the escape sequences,
+// which are Racket's (see https://docs.racket-lang.org/reference/reader.html#%28part._parse-string%29)
+// along with R7RS's \x<hex>; and line continuation:
+//    \a \b \t \n \v \f \r \e   alarm, backspace, tab, linefeed, vtab, page, return, escape
+//    \" \' \\               the character itself
+//    \<digit8>{1,3}         the character with that octal code, up to 255
+//    \x<digit16>{1,2}       the character with that hex code
+//    \x<digit16>+;          likewise, as in R7RS
+//    \u<digit16>{1,4}       the character with that hex code; a pair of these
+//                           may give a UTF-16 surrogate pair
+//    \U<digit16>{1,8}       the character with that hex code
+//    \<newline>             nothing; intraline whitespace around the newline
+//                           is skipped too, as in R7RS
+func unquoteString(s string) (string, error) {
+	runes := []rune(s[1 : len(s)-1])
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '\\' {
+			b.WriteRune(r)
+			continue
+		}
+		i++
+		start := i - 1
+		switch r = runes[i]; r {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'v':
+			b.WriteByte('\v')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte('\033')
+		case '"', '\'', '\\':
+			b.WriteRune(r)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// The longest run of up to 3 digits whose value is in range.
+			n, code := digitRun(runes[i:], isOctDigit, 8, 3)
+			if code > 0377 {
+				n, code = n-1, code>>3
+			}
+			b.WriteRune(code)
+			i += n - 1
+		case 'x':
+			n, code := digitRun(runes[i+1:], isHexDigit, 16, 8)
+			if n > 0 && i+1+n < len(runes) && runes[i+1+n] == ';' {
+				if !utf8.ValidRune(code) {
+					return "", fmt.Errorf("bad escape sequence `%s` in string", string(runes[start:i+2+n]))
+				}
+				i += n + 1
+			} else if n > 0 {
+				n, code = digitRun(runes[i+1:], isHexDigit, 16, 2)
+				i += n
+			} else {
+				return "", fmt.Errorf("bad escape sequence `%s` in string", string(runes[start:i+1]))
+			}
+			b.WriteRune(code)
+		case 'u', 'U':
+			max := 4
+			if r == 'U' {
+				max = 8
+			}
+			n, code := digitRun(runes[i+1:], isHexDigit, 16, max)
+			end := i + 1 + n
+			if r == 'u' && 0xd800 <= code && code < 0xdc00 &&
+				end+1 < len(runes) && runes[end] == '\\' && runes[end+1] == 'u' {
+				// A high surrogate, which a low one may follow.
+				m, low := digitRun(runes[end+2:], isHexDigit, 16, 4)
+				if 0xdc00 <= low && low < 0xe000 {
+					code = utf16.DecodeRune(code, low)
+					end += 2 + m
+				}
+			}
+			if n == 0 || !utf8.ValidRune(code) {
+				return "", fmt.Errorf("bad escape sequence `%s` in string", string(runes[start:end]))
+			}
+			b.WriteRune(code)
+			i = end - 1
+		default:
+			// A line continuation.
+			j := i
+			for j < len(runes) && isIntralineSpace(runes[j]) {
+				j++
+			}
+			if j == len(runes) || !isLineEnding(runes[j]) {
+				return "", fmt.Errorf("bad escape sequence `%s` in string", string(runes[start:i+1]))
+			}
+			if runes[j] == '\r' && j+1 < len(runes) && runes[j+1] == '\n' {
+				j++
+			}
+			for j++; j < len(runes) && isIntralineSpace(runes[j]); j++ {
+			}
+			i = j - 1
+		}
+	}
+	return b.String(), nil
+}
+
+// SymbolLiteralToString returns the name of the symbol that the symbol
+// literal s (as scanned, quoting included) stands for. If fold is true, the
+// unquoted parts of the name are case-folded, as under #!fold-case.
+func SymbolLiteralToString(s string, fold bool) string {
+	name, err := unquoteSymbol(s, fold)
+	if err != nil {
+		panic(fmt.Sprintf("invalid symbol literal %q: %v", s, err))
+	}
+	return name
+}
+
+// unquoteSymbol decodes the symbol literal s. Outside bars, \ quotes the
+// character after it, as in Racket. Inside bars, these are the escape
+// sequences, which are R7RS's:
+//    \a \b \t \n \r           alarm, backspace, tab, linefeed, return
+//    \| \\                   the character itself
+//    \x<digit16>+;           the character with that hex code
+func unquoteSymbol(s string, fold bool) (string, error) {
+	runes := []rune(s)
+	var b strings.Builder
+	inBars := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '|':
+			inBars = !inBars
+		case r == '\\' && !inBars:
+			i++
+			b.WriteRune(runes[i])
+		case r == '\\':
+			i++
+			start := i - 1
+			switch r = runes[i]; r {
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case '|', '\\':
+				b.WriteRune(r)
+			case 'x':
+				n, code := digitRun(runes[i+1:], isHexDigit, 16, 8)
+				if n == 0 || i+1+n == len(runes) || runes[i+1+n] != ';' || !utf8.ValidRune(code) {
+					return "", fmt.Errorf("bad escape sequence `%s` in symbol", string(runes[start:i+1]))
+				}
+				b.WriteRune(code)
+				i += n + 1
+			default:
+				return "", fmt.Errorf("bad escape sequence `%s` in symbol", string(runes[start:i+1]))
+			}
+		case fold && !inBars:
+			b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+// SymbolToLiteral returns a symbol literal that reads back as the symbol
+// named name. The name is quoted with bars if it would otherwise read as
+// something else: a number, a dot, or more or less than one symbol.
+func SymbolToLiteral(name string) string {
+	quote := name == "" || name == "." || name[0] == '#' && !strings.HasPrefix(name, "#%")
+	if _, ok := ParseNumber(name); ok {
+		quote = true
+	}
+	for _, r := range name {
+		if r != '#' && isDelimiterRune(r) || !unicode.IsPrint(r) {
+			quote = true
+		}
+	}
+	if !quote {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('|')
+	for _, r := range name {
+		switch {
+		case r == '|' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case unicode.IsPrint(r):
+			b.WriteRune(r)
+		default:
+			b.WriteString(`\x` + strconv.FormatInt(int64(r), 16) + ";")
+		}
+	}
+	b.WriteByte('|')
+	return b.String()
+}
+
+// digitRun returns the length and value of the run of up to max digits in the
+// given base at the start of runes.
+func digitRun(runes []rune, isDigit func(rune) bool, base, max int) (int, rune) {
+	n, code := 0, rune(0)
+	for n < len(runes) && n < max && isDigit(runes[n]) {
+		d, _ := strconv.ParseInt(string(runes[n]), base, 32)
+		code = code*rune(base) + rune(d)
+		n++
+	}
+	return n, code
+}
+
+func isIntralineSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// isLineEnding reports whether r is a line separator (see isLineSeparator).
+func isLineEnding(r rune) bool {
+	return r == '\n' || r == '\v' || r == '\f' || r == '\r' ||
+		r == '\x85' || r == '\u2028' || r == '\u2029'
+}
@@ -51,7 +51,7 @@ var testcases []testcase = []testcase{
 		},
 	},
 	{
-		input: "'`,,@#f#t .",
+		input: "'`,,@#f#t . ... ....",
 		output: []wanted{
 			{Quote, "'"},
 			{QuasiQuote, "`"},
@@ -60,6 +60,8 @@ var testcases []testcase = []testcase{
 			{False, "#f"},
 			{True, "#t"},
 			{Dot, "."},
+			{Ellipsis, "..."},
+			{Symbol, "...."},
 			{EOF, "<EOF>"},
 		},
 	},
@@ -77,12 +79,48 @@ var testcases []testcase = []testcase{
 		},
 	},
 	{
-		input: `"" "?" "howdy" "\"\x" "unfinished business`,
+		input: "123456789012345678901234567890 1e400 1/3 -22/7 +0/5 1/ /2 1/-2 --1/2",
+		output: []wanted{
+			{Fixnum, "123456789012345678901234567890"},
+			{Flonum, "1e400"},
+			{Rational, "1/3"},
+			{Rational, "-22/7"},
+			{Rational, "+0/5"},
+			{Symbol, "1/"},
+			{Symbol, "/2"},
+			{Symbol, "1/-2"},
+			{Symbol, "--1/2"},
+			{EOF, "<EOF>"},
+		},
+	},
+	{
+		input: "#x1F #b-101 #e1.5 #i3/4 #X#E1f +inf.0 -nan.0 1+2i -i 1@2 0x1F 1_000 inf #q1",
+		output: []wanted{
+			{Fixnum, "#x1F"},
+			{Fixnum, "#b-101"},
+			{Flonum, "#e1.5"},
+			{Rational, "#i3/4"},
+			{Fixnum, "#X#E1f"},
+			{Flonum, "+inf.0"},
+			{Flonum, "-nan.0"},
+			{Complex, "1+2i"},
+			{Complex, "-i"},
+			{Complex, "1@2"},
+			{Symbol, "0x1F"},
+			{Symbol, "1_000"},
+			{Symbol, "inf"},
+			{Error, "bad character following #: U+0071 'q'"},
+		},
+	},
+	{
+		input: `"" "?" "howdy" "\"\x41;" "two` + "\n" + `lines" "\q" "unfinished business`,
 		output: []wanted{
 			{String, `""`},
 			{String, `"?"`},
 			{String, `"howdy"`},
-			{String, `"\"\x"`},
+			{String, `"\"\x41;"`},
+			{String, "\"two\nlines\""},
+			{Error, "bad escape sequence `\\q` in string"},
 			{Error, "unterminated quoted string"},
 			{EOF, "<EOF>"},
 		},
@@ -101,10 +139,71 @@ var testcases []testcase = []testcase{
 		},
 	},
 	{
-		input: `#\space #\x`,
+		input: `#\space #\x #\alarm #\delete #\escape #\x41 #\x3bb(#\x110000 #\x41z`,
 		output: []wanted{
 			{Char, "#\\space"},
 			{Char, `#\x`},
+			{Char, `#\alarm`},
+			{Char, `#\delete`},
+			{Char, `#\escape`},
+			{Char, `#\x41`},
+			{Char, `#\x3bb`},
+			{LeftParen, "("},
+			{Error, "bad character syntax `#\\x110000`"},
+			{Error, "bad character syntax `#\\x41z`"},
+			{EOF, "<EOF>"},
+		},
+	},
+	{
+		input: `#(1 #u8(2) #U8()) #u9`,
+		output: []wanted{
+			{Vector, "#("},
+			{Fixnum, "1"},
+			{Bytevector, "#u8("},
+			{Fixnum, "2"},
+			{RightParen, ")"},
+			{Bytevector, "#U8("},
+			{RightParen, ")"},
+			{RightParen, ")"},
+			{Error, "bad # syntax `#u9`"},
+			{EOF, "<EOF>"},
+		},
+	},
+	{
+		input: `|a b| a\ b x|(|y .\z |\|| #!fold-case A #!no-fold-case |x`,
+		output: []wanted{
+			{Symbol, "|a b|"},
+			{Symbol, `a\ b`},
+			{Symbol, "x|(|y"},
+			{Symbol, `.\z`},
+			{Symbol, `|\||`},
+			{Symbol, "A"},
+			{Error, "unterminated |symbol|"},
+			{EOF, "<EOF>"},
+		},
+	},
+	{
+		input: `#!fold-case #\SPACE #!no-fold-case #\SPACE #!other`,
+		output: []wanted{
+			{Char, `#\SPACE`},
+			{Error, "unrecognized character name `#\\SPACE`"},
+			{Error, "unknown directive `#!other`"},
+			{EOF, "<EOF>"},
+		},
+	},
+	{
+		input: `#0=(a . #0#) #12=#12# #3 #4x`,
+		output: []wanted{
+			{DatumLabel, "#0="},
+			{LeftParen, "("},
+			{Symbol, "a"},
+			{Dot, "."},
+			{DatumRef, "#0#"},
+			{RightParen, ")"},
+			{DatumLabel, "#12="},
+			{DatumRef, "#12#"},
+			{Error, "bad datum label `#3`"},
+			{Error, "bad datum label `#4x`"},
 			{EOF, "<EOF>"},
 		},
 	},
@@ -133,3 +232,153 @@ func TestTokenizer(t *testing.T) {
 		checkTestcase(t, &testcases[i])
 	}
 }
+
+func TestStringLiteralToString(t *testing.T) {
+	for _, c := range []struct {
+		literal, want string
+	}{
+		{`"plain"`, "plain"},
+		{`"a\nb\tc"`, "a\nb\tc"},
+		{`"\a\b\v\f\r\e"`, "\a\b\v\f\r\033"},
+		{`"\"\'\\"`, `"'\`},
+		{`"\101\60\0"`, "A0\000"},
+		{`"\477"`, "'7"},
+		{`"\x41\x7e7"`, "A~7"},
+		{`"\x3bb;!"`, "λ!"},
+		{`"\u3bbλ"`, "λλ"},
+		{`"😀"`, "\U0001F600"},
+		{`"\U1F600"`, "\U0001F600"},
+		{"\"one \\  \n   two\"", "one two"},
+		{"\"raw\nnewline\"", "raw\nnewline"},
+	} {
+		if got := StringLiteralToString(c.literal); got != c.want {
+			t.Errorf("StringLiteralToString(%s) = %q; want %q", c.literal, got, c.want)
+		}
+	}
+}
+
+func TestSymbolLiteralToString(t *testing.T) {
+	for _, c := range []struct {
+		literal string
+		fold    bool
+		want    string
+	}{
+		{`abc`, false, "abc"},
+		{`|a b|`, false, "a b"},
+		{`a\ b`, false, "a b"},
+		{`\|x`, false, "|x"},
+		{`ab|cd ef|gh`, false, "abcd efgh"},
+		{`||`, false, ""},
+		{`|\x41;\|\\\t|`, false, "A|\\\t"},
+		{`Hello|World|\X`, true, "helloWorldX"},
+		{`Hello`, false, "Hello"},
+	} {
+		if got := SymbolLiteralToString(c.literal, c.fold); got != c.want {
+			t.Errorf("SymbolLiteralToString(%s, %v) = %q; want %q", c.literal, c.fold, got, c.want)
+		}
+	}
+}
+
+func TestSymbolToLiteral(t *testing.T) {
+	for _, c := range []struct {
+		name, want string
+	}{
+		{"abc", "abc"},
+		{"...", "..."},
+		{"#%void", "#%void"},
+		{"a#b", "a#b"},
+		{"a b", "|a b|"},
+		{"", "||"},
+		{".", "|.|"},
+		{"1", "|1|"},
+		{"-1.5e3", "|-1.5e3|"},
+		{"+i", "|+i|"},
+		{"#t", "|#t|"},
+		{"(", "|(|"},
+		{`a|b\c`, `|a\|b\\c|`},
+		{"x\ny", `|x\xa;y|`},
+	} {
+		if got := SymbolToLiteral(c.name); got != c.want {
+			t.Errorf("SymbolToLiteral(%q) = %s; want %s", c.name, got, c.want)
+		}
+		if got := SymbolLiteralToString(c.want, false); got != c.name {
+			t.Errorf("SymbolLiteralToString(%s) = %q; want %q", c.want, got, c.name)
+		}
+	}
+}
+
+func TestStringLiteralRejects(t *testing.T) {
+	for _, literal := range []string{
+		`"\q"`, `"\x"`, `"\x110000;"`, `"\uD800"`, `"\U110000"`, `"\ x"`,
+	} {
+		if text, err := unquoteString(literal); err == nil {
+			t.Errorf("unquoteString(%s) = %q; wanted an error", literal, text)
+		}
+	}
+}
+
+func TestNextRune(t *testing.T) {
+	scanner := NewScanner("<string>", strings.NewReader("(a) λy\nz"))
+	expectToken(t, scanner, LeftParen, "(")
+	expectToken(t, scanner, Symbol, "a")
+	expectToken(t, scanner, RightParen, ")")
+	for _, want := range " λy\n" {
+		if r, ok := scanner.PeekRune(); !ok || r != want {
+			t.Errorf("PeekRune: wanted %q, got %q, %v", want, r, ok)
+		}
+		if r, ok := scanner.NextRune(); !ok || r != want {
+			t.Errorf("NextRune: wanted %q, got %q, %v", want, r, ok)
+		}
+	}
+	expectToken(t, scanner, Symbol, "z")
+	if r, ok := scanner.NextRune(); ok {
+		t.Errorf("NextRune: wanted the end of the input, got %q", r)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	type position struct {
+		typ               Type
+		line, col, offset int
+	}
+	for _, c := range []struct {
+		input string
+		want  []position
+	}{
+		{
+			input: "(a \"x\ny\" b)\r\n#| c\nd |# λz ; e\n  #\\a |p\nq| 1.5 \"open",
+			want: []position{
+				{LeftParen, 1, 1, 0},
+				{Symbol, 1, 2, 1},
+				{String, 1, 4, 3},
+				{Symbol, 2, 4, 9},
+				{RightParen, 2, 5, 10},
+				{Symbol, 4, 6, 23},
+				{Char, 5, 3, 33},
+				{Symbol, 5, 7, 37},
+				{Flonum, 6, 4, 43},
+				{Error, 6, 8, 47},
+				{EOF, 6, 13, 52},
+			},
+		},
+		{
+			input: "#!bogus x\n\"a\\\n  b\" y",
+			want: []position{
+				{Error, 1, 1, 0},
+				{Symbol, 1, 9, 8},
+				{String, 2, 1, 10},
+				{Symbol, 3, 6, 19},
+				{EOF, 3, 7, 20},
+			},
+		},
+	} {
+		scanner := NewScanner("<string>", strings.NewReader(c.input))
+		for _, want := range c.want {
+			token := scanner.Next()
+			if got := (position{token.Type, token.Line, token.Col, token.Offset}); got != want {
+				t.Errorf("%q: got %v at %d:%d (offset %d), want %v at %d:%d (offset %d)", c.input,
+					got.typ, got.line, got.col, got.offset, want.typ, want.line, want.col, want.offset)
+			}
+		}
+	}
+}
@@ -4,9 +4,9 @@ package scan
 
 import "strconv"
 
-const _Type_name = "EOFErrorLeftParenLeftBrackLeftBraceQuoteQuasiQuoteUnquoteUnquoteSplicingFalseTrueDotFixnumFlonumStringSymbolRightParenRightBrackRightBraceCharLiteralAssignCharGreaterOrEqualIdentifierNumberOperatorOpRationalSemicolonSpace"
+const _Type_name = "EOFErrorLeftParenLeftBrackLeftBraceQuoteQuasiQuoteUnquoteUnquoteSplicingFalseTrueDotEllipsisFixnumFlonumRationalComplexStringSymbolRightParenRightBrackRightBraceCharLiteralVectorBytevectorDatumLabelDatumRefAssignCharGreaterOrEqualIdentifierNumberOperatorOpSemicolonSpace"
 
-var _Type_index = [...]uint8{0, 3, 8, 17, 26, 35, 40, 50, 57, 72, 77, 81, 84, 90, 96, 102, 108, 118, 128, 138, 149, 155, 159, 173, 183, 189, 197, 199, 207, 216, 221}
+var _Type_index = [...]uint16{0, 3, 8, 17, 26, 35, 40, 50, 57, 72, 77, 81, 84, 92, 98, 104, 112, 119, 125, 131, 141, 151, 161, 172, 178, 188, 198, 206, 212, 216, 230, 240, 246, 254, 256, 265, 270}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -6,11 +6,11 @@
  * Pieter Kelchtermans 2013
  * LICENSE: WTFPL 2.0
  */
-package main
+package lisp
 
 import (
 	"fmt"
-	"reflect"
+	"runtime"
 	"strings"
 	"unicode"
 
@@ -21,30 +21,36 @@ import (
  Eval / Apply
 */
 var Tracing bool
+var UseVM bool
 
 func TopLevelEvaluate(e scmer) scmer {
+	translating, definedMacros = nil, nil
+	defer forget(e)
+	defer locateTranslationError()
+	e = expand(e, nil)
+	if UseVM {
+		return executeBlock(compile(analyze(e, nil)))
+	}
 	if isDefineForm(e) {
-		return define(e.(array), &globalenv)
+		return define(e.(*pair))
 	}
-	return eval(e, &globalenv)
+	return eval(e)
 }
 
 func isDefineForm(form scmer) bool {
-	if list, ok := form.(array); !ok {
-		return false
-	} else if len(list) < 3 {
+	if list, ok := form.(*pair); !ok {
 		return false
-	} else if sym, ok := list[0].(symbol); ok {
-		return sym == "define"
+	} else if sym, ok := list.car.(symbol); ok {
+		return sym == "define" && length(list) >= 3
 	} else {
 		return false
 	}
 }
 
-func define(list array, r *env) (result scmer) {
+func define(form *pair) (result scmer) {
 	if Tracing {
 		print_indent()
-		fmt.Printf("=> Define %s\n", list)
+		fmt.Printf("=> Define %s\n", form)
 		indent()
 		defer func() {
 			undent()
@@ -52,27 +58,9 @@ func define(list array, r *env) (result scmer) {
 			fmt.Printf("<= %s\n", result)
 		}()
 	}
-	if len(list) != 3 {
-		Fail("define requires at exactly 3 arguments: %s", list)
-	}
-	if sym, ok := list[1].(symbol); ok {
-		if len(list) != 3 {
-			Fail("define has trailing values: %s", list)
-		}
-		r.vars[sym] = eval(list[2], r)
-		return array{symbol("#%undef"), symbol("define"), sym}
-	}
-	if args, ok := list[1].(array); ok {
-		if sym, ok := args[0].(symbol); !ok {
-			Fail("define has illegal structure")
-		} else {
-			val := proc{args[1:], list[2], r}
-			r.vars[sym] = val
-			return array{symbol("#%undef"), symbol("define"), sym}
-		}
-	}
-	Fail("define: 1st arg must be symbol or func declaration: %s", list)
-	panic("Fail didn't panic")
+	m := newMachine()
+	analyze(form, nil).step(m) // without tracing the define itself
+	return m.run()
 }
 
 var depth int
@@ -85,241 +73,540 @@ func print_indent() {
 	}
 }
 
-func eval(expression scmer, en *env) (value scmer) {
-	if Tracing {
-		print_indent()
-		fmt.Printf("=> Evaluate %s\n", expression)
-		indent()
-		defer func() {
-			undent()
-			print_indent()
-			fmt.Printf("<= %s\n", value)
-		}()
+// A machine evaluates expressions with an explicit continuation, k, in place
+// of the Go call stack. Each frame of k says what to do with the value of the
+// expression currently being evaluated, and frames are never modified once
+// they have been pushed, so that a continuation captured by call/cc can be
+// resumed any number of times.
+//
+// Expressions in tail position (the branches of an if, the last expression of
+// a begin, and the body of a procedure being applied) are evaluated without
+// pushing a frame, so a chain of tail calls runs in constant space.
+//
+// A machine can also execute bytecode (see vm.go), whose return addresses are
+// frames like any other.
+type machine struct {
+	mode       mode
+	expression node        // the expression to evaluate
+	en         *activation // the activation in which to evaluate expression or block
+	value      scmer       // the value to pass to k; the accumulator of the bytecode
+	loc        *location   // the location of expression, if known
+	k          frame       // the continuation
+	base       frame       // the frame that ends k; resuming it halts the machine
+	halted     bool
+
+	block *codeBlock // the bytecode to execute
+	pc    int        // the offset in block.code of the next instruction
+	stack []scmer    // the values pushed by block, in this activation
+}
+
+// A mode says what a machine is doing.
+type mode int
+
+const (
+	returning  mode = iota // passing value to k
+	evaluating             // evaluating expression in en
+	executing              // executing block from pc, in en
+)
+
+// A frame is one step of a continuation. Its resume method receives m.value
+// (with m.k already popped back to the frame below it) and decides what the
+// machine does next. below returns the frame below it, or nil if it is the
+// last.
+type frame interface {
+	resume(m *machine)
+	below() frame
+}
+
+// machines lists the machines that are currently running, innermost last.
+// There is more than one when a Go primitive calls back into Scheme code.
+var machines []*machine
+
+func newMachine() *machine {
+	base := &haltFrame{native: compiled}
+	return &machine{k: base, base: base}
+}
+
+// eval evaluates an expanded expression at top level.
+func eval(expression scmer) scmer {
+	m := newMachine()
+	m.eval(analyze(expression, nil), nil)
+	return m.run()
+}
+
+// apply applies procedure to args.
+func apply(procedure scmer, args []scmer) scmer {
+	m := newMachine()
+	// The application waits for the machine to run, so that an escape to a
+	// continuation captured in it, or a failure, can be caught there.
+	m.push(&applyFrame{procedure, args, m.k})
+	m.ret(nil)
+	return m.run()
+}
+
+// eval makes expression in activation en the next thing to evaluate.
+func (m *machine) eval(expression node, en *activation) {
+	m.mode, m.expression, m.en = evaluating, expression, en
+}
+
+// ret passes value to the current continuation.
+func (m *machine) ret(value scmer) {
+	m.mode, m.value = returning, value
+}
+
+// push makes f the current continuation. f's next frame must be m.k.
+func (m *machine) push(f frame) {
+	m.k = f
+}
+
+// run runs the machine until it halts, and returns the final value.
+func (m *machine) run() scmer {
+	machines = append(machines, m)
+	defer func() { machines = machines[:len(machines)-1] }()
+	for !m.runUntilEscape() {
 	}
-	switch e := expression.(type) {
-	case boolean:
-		value = e
-	case char:
-		value = e
-	case flonum:
-		value = e
-	case str:
-		value = e
-	case symbol:
-		value = en.Lookup(e)
-	case array:
-		switch car, _ := e[0].(symbol); car {
-		case "quote":
-			value = e[1]
-		case "if":
-			if eval(e[1], en).(boolean) {
-				value = eval(e[2], en)
-			} else {
-				value = eval(e[3], en)
+	return m.value
+}
+
+// runUntilEscape runs the machine until it halts, and then returns true. If an
+// escape to a continuation belonging to m is raised by a machine nested inside
+// m, runUntilEscape reinstates that continuation and returns false.
+func (m *machine) runUntilEscape() (halted bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*escape); ok && e.to == m {
+				m.k, m.base = e.k, e.base
+				m.ret(e.value)
+				return
 			}
-		case "set!":
-			v := e[1].(symbol)
-			en.Find(v).vars[v] = eval(e[2], en)
-			value = symbol("#%set!")
-		case "define":
-			value = define(e, en)
-		case "lambda":
-			value = proc{e[1], e[2], en}
-		case "apply":
-			functor := eval(e[1], en)
-			value = apply(functor, eval(e[2], en).(array))
-		case "begin":
-			for _, i := range e[1:] {
-				value = eval(i, en)
+			// A primitive failed: raise the condition, if anything can
+			// handle it, noting where it failed in case nothing does.
+			if c := condition(r); c != nil {
+				if c.trace == nil {
+					c.trace = m.backtrace()
+				}
+				if handlers != nil {
+					m.raise(c, false)
+					return
+				}
+				panic(c)
 			}
-		default:
-			functor := eval(e[0], en)
-			value = apply(functor, eval_all(e[1:], en))
+			panic(r)
 		}
-	default:
-		Fail("eval: unknown expression type: %T %e", expression, expression)
+	}()
+	for !m.halted {
+		if m.mode == returning {
+			k := m.k
+			k.resume(m)
+		} else if m.mode == executing {
+			m.execute()
+		} else if Tracing {
+			print_indent()
+			fmt.Printf("=> Evaluate %s\n", m.expression.source())
+			indent()
+			m.push(&traceFrame{m.k})
+			m.expression.step(m)
+		} else {
+			m.expression.step(m)
+		}
+	}
+	return true
+}
+
+// The step method of each kind of node begins its evaluation in m.en.
+
+func (n *constant) step(m *machine) {
+	m.ret(n.value)
+}
+
+func (r *localRef) step(m *machine) {
+	m.loc = r.loc
+	m.ret(r.get(m.en))
+}
+
+func (r *globalRef) step(m *machine) {
+	m.loc = r.loc
+	m.ret(r.get(m.en))
+}
+
+func (n *ifNode) step(m *machine) {
+	m.push(&ifFrame{n, m.en, m.k})
+	m.eval(n.test, m.en)
+}
+
+func (n *assignment) step(m *machine) {
+	m.push(&assignFrame{n, m.en, m.k})
+	m.eval(n.value, m.en)
+}
+
+func (n *definition) step(m *machine) {
+	m.push(&defineFrame{n, m.k})
+	m.eval(n.value, m.en)
+}
+
+func (n *lambda) step(m *machine) {
+	m.ret(&proc{n, m.en})
+}
+
+func (n *sequence) step(m *machine) {
+	m.evalSequence(n.body, m.en)
+}
+
+func (n *application) step(m *machine) {
+	values := make([]scmer, 0, len(n.parts)+1)
+	if n.apply {
+		// (apply f args) evaluates f and args, then applies applyControl.
+		values = append(values, applyControl)
 	}
-	return
+	m.evalOperands(n.parts, values, m.en, n.loc)
 }
 
-func eval_all(list []scmer, r *env) []scmer {
-	values := make(array, len(list))
-	for i, x := range list {
-		values[i] = eval(x, r)
+// evalOperands evaluates the operator and operands of an application, given
+// by parts, in activation en. The values are appended to values, and once
+// they are all known the application, at location loc, is performed.
+//
+// Variables and constants are evaluated on the spot, because they cannot
+// capture a continuation; anything else is evaluated with an argFrame on the
+// stack to receive its value.
+func (m *machine) evalOperands(parts []node, values []scmer, en *activation, loc *location) {
+	// If a variable is undefined or the application fails, the machine is
+	// brought up to date, so that the error can say where. (It would be
+	// slower to keep it up to date all the time.)
+	done := false
+	defer func() {
+		if !done {
+			m.en, m.loc = en, loc
+		}
+	}()
+	for i, part := range parts {
+		if Tracing {
+			// evaluate everything the long way, so that it is traced
+		} else if r, ok := part.(reference); ok {
+			values = append(values, r.get(en))
+			continue
+		} else if c, ok := part.(*constant); ok {
+			values = append(values, c.value)
+			continue
+		}
+		m.push(&argFrame{parts[i+1:], values, en, loc, m.k})
+		m.eval(part, en)
+		done = true
+		return
+	}
+	m.loc = loc // the call site of a compound procedure
+	m.apply(values[0], values[1:])
+	done = true
+}
+
+// evalSequence evaluates the expressions in body, in order, in activation en.
+// The last one is in tail position.
+func (m *machine) evalSequence(body []node, en *activation) {
+	if len(body) > 1 {
+		m.push(&beginFrame{body[1:], en, m.k})
 	}
-	return values
+	m.eval(body[0], en)
 }
 
-func apply(procedure scmer, args array) (value scmer) {
+// apply applies procedure to args. The body of a compound procedure is
+// evaluated in tail position.
+func (m *machine) apply(procedure scmer, args []scmer) {
 	//if Tracing {
 	//	print_indent()
 	//	fmt.Printf("apply %s to %s\n", procedure, args)
-	//	indent()
-	//	defer func() {
-	//		undent()
-	//		print_indent()
-	//		fmt.Printf("return value from %s is %s\n", procedure, value)
-	//	}()
 	//}
 	switch p := procedure.(type) {
-	case primitive:
-		value = p.f(args...)
-	case proc:
-		en := &env{make(vars), p.en}
-		switch params := p.params.(type) {
-		case array:
-			for i, param := range params {
-				//if Tracing {
-				//	print_indent()
-				//	fmt.Printf("set %s to %s\n", param, args[i])
-				//}
-				en.vars[param.(symbol)] = args[i]
-			}
-		default:
-			//if Tracing {
-			//	print_indent()
-			//	fmt.Printf("set %s to %s\n", params, args)
-			//}
-			en.vars[params.(symbol)] = args
+	case *primitive:
+		m.ret(p.call(args))
+	case *control:
+		p.check(string(p.name), args)
+		p.f(m, args)
+	case *continuation:
+		p.invoke(m, args)
+	case *parameter:
+		if len(args) != 0 {
+			Fail("parameter: expected 0 arguments, got %d", len(args))
+		}
+		m.ret(p.value)
+	case *proc:
+		l := p.lambda
+		lambdaArity(l.arity, l.rest).check(procName(l.name, l.params), args)
+		en := bindArgs(l.arity, l.rest, l.size, args, p.en)
+		en.proc, en.site = p, m.where()
+		m.eval(l.code, en)
+	case *native:
+		m.ret(Call(p, args...))
+	case *caseLambda:
+		m.apply(p.clause(args), args)
+	case *closure:
+		b := p.block
+		lambdaArity(b.arity, b.rest).check(procName(b.name, b.params), args)
+		en := bindArgs(b.arity, b.rest, b.size, args, p.en)
+		en.proc, en.site = p, m.where()
+		m.mode, m.block, m.pc, m.en = executing, b, 0, en
+		// The stack goes in the room after args, if the caller left some.
+		if m.stack = args[len(args):]; cap(m.stack) < b.maxStack {
+			m.stack = make([]scmer, 0, b.maxStack)
 		}
-		value = eval(p.body, en)
 	default:
 		Fail("apply: invalid functor: %T %s", procedure, procedure)
 	}
-	return
 }
 
-type primitive struct {
-	name symbol
-	f    func(...scmer) scmer
+// bindArgs returns the activation, of the given size, in which a procedure
+// with the given parameters is applied to args.
+func bindArgs(arity int, rest bool, size int, args []scmer, outer *activation) *activation {
+	// args is made afresh for each application, so it can serve as the
+	// activation's values if it is the right size.
+	values := args
+	if rest || size != len(args) {
+		values = make([]scmer, size)
+		for i := 0; i < arity; i++ {
+			values[i] = args[i]
+		}
+		if rest {
+			// a rest parameter collects all remaining arguments
+			values[arity] = makeList(args[arity:]...)
+		}
+	}
+	return &activation{values: values, outer: outer}
 }
 
-func (x primitive) String() string {
-	return fmt.Sprintf("#<primitive:%s>", x.name)
+// haltFrame ends every continuation. Each machine's must have an address of
+// its own, since it identifies the machine a continuation belongs to.
+type haltFrame struct {
+	native bool // the machine was started by compiled code (see native.go)
 }
 
-type proc struct {
-	params, body scmer
-	en           *env
+func (f *haltFrame) resume(m *machine) {
+	m.halted = true
 }
 
-func (x proc) String() string {
-	return fmt.Sprintf("(lambda %s %s)", x.params, x.body)
+func (f *haltFrame) below() frame { return nil }
+
+// applyFrame applies a procedure, ignoring the value it is given.
+type applyFrame struct {
+	procedure scmer
+	args      []scmer
+	next      frame
 }
 
-/*
- Environments
-*/
+func (f *applyFrame) resume(m *machine) {
+	m.k = f.next
+	m.apply(f.procedure, f.args)
+}
+
+func (f *applyFrame) below() frame { return f.next }
+
+// traceFrame prints the value of an expression being traced.
+type traceFrame struct {
+	next frame
+}
 
-type vars map[symbol]scmer
-type env struct {
-	vars
-	outer *env
+func (f *traceFrame) resume(m *machine) {
+	undent()
+	print_indent()
+	fmt.Printf("<= %s\n", m.value)
+	m.k = f.next
 }
 
-func (e *env) Find(s symbol) *env {
-	if _, ok := e.vars[s]; ok {
-		return e
-	} else if e.outer == nil {
-		return nil
+func (f *traceFrame) below() frame { return f.next }
+
+type ifFrame struct {
+	n    *ifNode
+	en   *activation
+	next frame
+}
+
+func (f *ifFrame) resume(m *machine) {
+	m.k = f.next
+	if m.value != boolean(false) {
+		m.eval(f.n.consequent, f.en)
 	} else {
-		return e.outer.Find(s)
+		m.eval(f.n.alternative, f.en)
 	}
 }
 
-func (e *env) Lookup(s symbol) scmer {
-	r := e.Find(s)
-	if r == nil {
-		Fail("undefined symbol: %s", s)
-	}
-	return r.vars[s]
+func (f *ifFrame) below() frame { return f.next }
+
+type assignFrame struct {
+	n    *assignment
+	en   *activation
+	next frame
+}
+
+func (f *assignFrame) resume(m *machine) {
+	m.k = f.next
+	m.en, m.loc = f.en, f.n.loc
+	f.n.target.set(f.en, m.value)
+	m.ret(f.n.result)
+}
+
+func (f *assignFrame) below() frame { return f.next }
+
+type defineFrame struct {
+	n    *definition
+	next frame
+}
+
+func (f *defineFrame) resume(m *machine) {
+	m.k = f.next
+	f.n.g.value = m.value
+	m.ret(f.n.result)
+}
+
+func (f *defineFrame) below() frame { return f.next }
+
+type beginFrame struct {
+	body []node // the expressions remaining to be evaluated
+	en   *activation
+	next frame
+}
+
+func (f *beginFrame) resume(m *machine) {
+	m.k = f.next
+	m.evalSequence(f.body, f.en)
+}
+
+func (f *beginFrame) below() frame { return f.next }
+
+// argFrame receives the value of an operator or operand of an application.
+type argFrame struct {
+	parts  []node  // the operands remaining to be evaluated
+	values []scmer // the values of those already evaluated
+	en     *activation
+	loc    *location // the location of the application
+	next   frame
+}
+
+func (f *argFrame) resume(m *machine) {
+	m.k = f.next
+	// Copy values, so that resuming this frame more than once cannot
+	// clobber a value collected earlier.
+	n := len(f.values)
+	values := make([]scmer, n+1, cap(f.values))
+	copy(values, f.values)
+	values[n] = m.value
+	m.evalOperands(f.parts, values, f.en, f.loc)
+}
+
+func (f *argFrame) below() frame { return f.next }
+
+// A primitive is a procedure written in Go. It must not keep its argument
+// slice, which the bytecode machine reuses.
+type primitive struct {
+	name symbol
+	arity
+	f func(...scmer) scmer
+}
+
+// A primitiveDef is an entry in a table of primitives, which init adds to the
+// global environment: the arity and code of the primitive named by its key.
+type primitiveDef struct {
+	arity
+	f func(...scmer) scmer
+}
+
+// call applies p to args. A Go runtime error in p, such as an index out of
+// range, is reported as a failure of p.
+func (p *primitive) call(args []scmer) scmer {
+	p.check(string(p.name), args)
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok {
+				Fail("%s: %s", p.name, err)
+			}
+			panic(r)
+		}
+	}()
+	return p.f(args...)
+}
+
+func (x *primitive) String() string {
+	return fmt.Sprintf("#<primitive:%s>", x.name)
+}
+
+type proc struct {
+	lambda *lambda
+	en     *activation
+}
+
+func (x *proc) String() string {
+	return x.lambda.written.String()
 }
 
 /*
  Primitives
 */
 
-var globalenv env
-
 func listPrimitive() scmer {
 	scanner := scan.NewScanner("<str>", strings.NewReader("(lambda z z)"))
 	expr, _ := read(scanner)
-	return eval(expr, &globalenv)
+	defer forget(expr)
+	return eval(expr)
 }
 
 func init() {
-	std := map[string]func(...scmer) scmer{
-		"+": func(a ...scmer) scmer {
-			v := a[0].(flonum)
-			for _, i := range a[1:] {
-				v += i.(flonum)
-			}
-			return v
-		},
-		"-": func(a ...scmer) scmer {
-			v := a[0].(flonum)
-			for _, i := range a[1:] {
-				v -= i.(flonum)
-			}
-			return v
-		},
-		"*": func(a ...scmer) scmer {
-			v := a[0].(flonum)
-			for _, i := range a[1:] {
-				v *= i.(flonum)
-			}
-			return v
-		},
-		"/": func(a ...scmer) scmer {
-			v := a[0].(flonum)
-			for _, i := range a[1:] {
-				v /= i.(flonum)
-			}
-			return v
-		},
-		"equal?": func(a ...scmer) scmer {
-			return boolean(reflect.DeepEqual(a[0], a[1]))
-		},
-		"!=": func(a ...scmer) scmer {
-			return boolean(!reflect.DeepEqual(a[0], a[1]))
-		},
-		"<": func(a ...scmer) scmer {
-			return boolean(a[0].(flonum) < a[1].(flonum))
-		},
-		"<=": func(a ...scmer) scmer {
-			return boolean(a[0].(flonum) <= a[1].(flonum))
-		},
-		">": func(a ...scmer) scmer {
-			return boolean(a[0].(flonum) > a[1].(flonum))
-		},
-		">=": func(a ...scmer) scmer {
-			return boolean(a[0].(flonum) >= a[1].(flonum))
-		},
-		"cons": func(a ...scmer) scmer {
-			switch car := a[0]; cdr := a[1].(type) {
-			case array:
-				return append(array{car}, cdr...)
-			default:
-				return array{car, cdr}
-			}
-		},
-		"car": func(a ...scmer) scmer {
-			return a[0].(array)[0]
-		},
-		"cdr": func(a ...scmer) scmer {
-			return a[0].(array)[1:]
-		},
+	std := map[string]primitiveDef{
+		"eq?": {arity{2, 2}, func(a ...scmer) scmer {
+			return boolean(a[0] == a[1])
+		}},
+		"eqv?": {arity{2, 2}, func(a ...scmer) scmer {
+			return boolean(eqv(a[0], a[1]))
+		}},
+		"equal?": {arity{2, 2}, func(a ...scmer) scmer {
+			return boolean(equal(a[0], a[1]))
+		}},
+		"!=": {arity{2, 2}, func(a ...scmer) scmer {
+			return boolean(!equal(a[0], a[1]))
+		}},
+		"cons": {arity{2, 2}, func(a ...scmer) scmer {
+			return &pair{a[0], a[1]}
+		}},
+		"car": {arity{1, 1}, func(a ...scmer) scmer {
+			return asPair("car", a[0]).car
+		}},
+		"cdr": {arity{1, 1}, func(a ...scmer) scmer {
+			return asPair("cdr", a[0]).cdr
+		}},
+		"set-car!": {arity{2, 2}, func(a ...scmer) scmer {
+			asPair("set-car!", a[0]).car = a[1]
+			return symbol("#%set!")
+		}},
+		"set-cdr!": {arity{2, 2}, func(a ...scmer) scmer {
+			asPair("set-cdr!", a[0]).cdr = a[1]
+			return symbol("#%set!")
+		}},
+		"append": {arity{0, variadic}, appendLists},
+		"pair?": {arity{1, 1}, func(a ...scmer) scmer {
+			_, ok := a[0].(*pair)
+			return boolean(ok)
+		}},
+		"null?": {arity{1, 1}, func(a ...scmer) scmer {
+			return boolean(a[0] == empty)
+		}},
 	}
-	builtins := vars{}
-	for k, v := range std {
-		sym := symbol(k)
-		builtins[sym] = primitive{sym, v}
+	for _, primitives := range []map[string]primitiveDef{
+		std, numericPrimitives, charPrimitives, stringPrimitives, vectorPrimitives,
+		hashTablePrimitives, portPrimitives, exceptionPrimitives,
+		continuationPrimitives, promisePrimitives, arityPrimitives,
+		featurePrimitives,
+	} {
+		for k, v := range primitives {
+			sym := symbol(k)
+			globalVariable(sym).value = &primitive{sym, v.arity, v.f}
+		}
+	}
+	for _, controls := range []map[string]controlDef{
+		controls, exceptionControls, portControls, promiseControls,
+	} {
+		for k, v := range controls {
+			sym := symbol(k)
+			globalVariable(sym).value = &control{sym, v.arity, v.f}
+		}
 	}
 
-	builtins[symbol("list")] = listPrimitive()
-	builtins[symbol("null")] = array{}
-
-	globalenv = env{builtins, nil}
+	globalVariable("list").value = listPrimitive()
+	globalVariable("null").value = empty
 }
 
 /*
@@ -331,34 +618,37 @@ type scmer interface {
 	String() string
 }
 
-type array []scmer  // lists are represented as arrays of scmers,
-type symbol string  // ...symbols by strings,
-type flonum float64 // ...numbers by float64,
-type str string     // ...str by string,
-type char rune      // ...char by rune
-type boolean bool   // ...boolean by bool
+type pair struct { // lists are chains of mutable pairs,
+	car, cdr scmer
+}
+type emptyList struct{} // ...ending in the empty list,
+type symbol string      // ...symbols by strings,
+type flonum float64     // ...inexact numbers by float64 (see numbers.go),
+type char rune          // ...char by rune
+type boolean bool       // ...boolean by bool
 
-func (a array) String() string {
-	l := make([]string, len(a))
-	for i, x := range a {
-		l[i] = x.String()
-	}
-	return "(" + strings.Join(l, " ") + ")"
+// empty is the one and only empty list, '().
+var empty = emptyList{}
+
+func (x *pair) String() string {
+	return printValue(x, labelCycles, false)
 }
-func (x symbol) String() string { return string(x) }
-func (x flonum) String() string { return fmt.Sprintf("%g", x) }
+
+func (x emptyList) String() string { return "()" }
+func (x symbol) String() string    { return scan.SymbolToLiteral(string(x)) }
 func (x boolean) String() string {
 	if x {
 		return "#t"
 	}
 	return "#f"
 }
-func (x str) String() string { return string(x) }
 
 func (x char) String() string {
 	switch c := rune(x); c {
 	case '\000':
 		return "#\\nul"
+	case '\007':
+		return "#\\alarm"
 	case '\010':
 		return "#\\backspace"
 	case '\011':
@@ -371,6 +661,8 @@ func (x char) String() string {
 		return "#\\page"
 	case '\015':
 		return "#\\return"
+	case '\033':
+		return "#\\escape"
 	case '\040':
 		return "#\\space"
 	case '\177':
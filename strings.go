@@ -0,0 +1,331 @@
+package lisp
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/perlmonger42/LiSP/scan"
+)
+
+/*
+ Strings
+
+ A string is a mutable sequence of chars. It holds them as runes, so that
+ string-ref, substring and the rest index it by char, as Scheme does, rather
+ than by byte. Being mutable, two strings are eqv? only if they are the same
+ string; equal? compares their contents.
+*/
+
+type str struct {
+	runes []rune
+}
+
+// makeStr returns a new string holding the Go string s.
+func makeStr(s string) *str {
+	return &str{[]rune(s)}
+}
+
+// text returns the contents of x as a Go string.
+func (x *str) text() string {
+	return string(x.runes)
+}
+
+// String returns x as a string literal, which reads back as x.
+func (x *str) String() string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range x.runes {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\v':
+			b.WriteString(`\v`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\033':
+			b.WriteString(`\e`)
+		default:
+			if unicode.IsPrint(r) {
+				b.WriteRune(r)
+			} else {
+				b.WriteString(`\x` + strconv.FormatInt(int64(r), 16) + ";")
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// equal reports whether x and y have the same contents.
+func (x *str) equal(y *str) bool {
+	if len(x.runes) != len(y.runes) {
+		return false
+	}
+	for i, r := range x.runes {
+		if y.runes[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// asStr returns x as a string, or fails on behalf of the named procedure.
+func asStr(who string, x scmer) *str {
+	s, ok := x.(*str)
+	if !ok {
+		Fail("%s: expected a string, got %s", who, x)
+	}
+	return s
+}
+
+// asIndex returns x as an index from 0 to max, or fails on behalf of the named
+// procedure.
+func asIndex(who string, x scmer, max int) int {
+	i, ok := x.(fixnum)
+	if !ok {
+		Fail("%s: expected an exact integer index, got %s", who, x)
+	}
+	if i < 0 || int64(i) > int64(max) {
+		Fail("%s: index %s out of range [0, %d]", who, x, max)
+	}
+	return int(i)
+}
+
+// span returns the start and end given by the optional arguments a[i] and
+// a[i+1], which select part of a sequence of length n: all of it by default.
+func span(who string, a []scmer, i, n int) (start, end int) {
+	start, end = 0, n
+	if len(a) > i+1 {
+		end = asIndex(who, a[i+1], n)
+	}
+	if len(a) > i {
+		start = asIndex(who, a[i], end)
+	}
+	return start, end
+}
+
+// stringComparison returns a primitive that reports whether each of its
+// arguments is related to the next by holds, comparing them char by char, and
+// case-folded if fold is true.
+func stringComparison(who string, fold bool, holds func(c int) bool) func(...scmer) scmer {
+	key := func(x scmer) string {
+		s := asStr(who, x).text()
+		if fold {
+			return strings.Map(foldRune, s)
+		}
+		return s
+	}
+	return func(a ...scmer) scmer {
+		result := true
+		for i := range a {
+			k := key(a[i])
+			if i > 0 && result {
+				result = holds(strings.Compare(key(a[i-1]), k))
+			}
+		}
+		return boolean(result)
+	}
+}
+
+// mapStrings calls f with the chars at each index of the strings a, up to the
+// length of the shortest, and returns the results.
+func mapStrings(who string, f scmer, a []scmer) []scmer {
+	strs := make([]*str, len(a))
+	n := -1
+	for i, x := range a {
+		strs[i] = asStr(who, x)
+		if n < 0 || len(strs[i].runes) < n {
+			n = len(strs[i].runes)
+		}
+	}
+	results := make([]scmer, n)
+	for i := range results {
+		args := make([]scmer, len(strs))
+		for j, s := range strs {
+			args[j] = char(s.runes[i])
+		}
+		results[i] = apply(f, args)
+	}
+	return results
+}
+
+var stringPrimitives = map[string]primitiveDef{
+	"string?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*str)
+		return boolean(ok)
+	}},
+	"make-string": {arity{1, 2}, func(a ...scmer) scmer {
+		k, ok := a[0].(fixnum)
+		if !ok || k < 0 {
+			Fail("make-string: expected a length, got %s", a[0])
+		}
+		fill := ' '
+		if len(a) > 1 {
+			fill = asChar("make-string", a[1])
+		}
+		runes := make([]rune, k)
+		for i := range runes {
+			runes[i] = fill
+		}
+		return &str{runes}
+	}},
+	"string": {arity{0, variadic}, func(a ...scmer) scmer {
+		runes := make([]rune, len(a))
+		for i, x := range a {
+			runes[i] = asChar("string", x)
+		}
+		return &str{runes}
+	}},
+	"string-length": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(len(asStr("string-length", a[0]).runes))
+	}},
+	"string-ref": {arity{2, 2}, func(a ...scmer) scmer {
+		s := asStr("string-ref", a[0])
+		return char(s.runes[asIndex("string-ref", a[1], len(s.runes)-1)])
+	}},
+	"string-set!": {arity{3, 3}, func(a ...scmer) scmer {
+		s := asStr("string-set!", a[0])
+		s.runes[asIndex("string-set!", a[1], len(s.runes)-1)] = asChar("string-set!", a[2])
+		return symbol("#%set!")
+	}},
+	"substring": {arity{3, 3}, func(a ...scmer) scmer {
+		s := asStr("substring", a[0])
+		start, end := span("substring", a[:3], 1, len(s.runes))
+		return &str{append([]rune(nil), s.runes[start:end]...)}
+	}},
+	"string-append": {arity{0, variadic}, func(a ...scmer) scmer {
+		var runes []rune
+		for _, x := range a {
+			runes = append(runes, asStr("string-append", x).runes...)
+		}
+		return &str{runes}
+	}},
+	"string-copy": {arity{1, 3}, func(a ...scmer) scmer {
+		s := asStr("string-copy", a[0])
+		start, end := span("string-copy", a, 1, len(s.runes))
+		return &str{append([]rune(nil), s.runes[start:end]...)}
+	}},
+	"string-copy!": {arity{3, 5}, func(a ...scmer) scmer {
+		to := asStr("string-copy!", a[0])
+		at := asIndex("string-copy!", a[1], len(to.runes))
+		from := asStr("string-copy!", a[2])
+		start, end := span("string-copy!", a, 3, len(from.runes))
+		if at+end-start > len(to.runes) {
+			Fail("string-copy!: %d chars do not fit at index %d of %s", end-start, at, to)
+		}
+		copy(to.runes[at:], from.runes[start:end])
+		return symbol("#%set!")
+	}},
+	"string-fill!": {arity{2, 4}, func(a ...scmer) scmer {
+		s := asStr("string-fill!", a[0])
+		fill := asChar("string-fill!", a[1])
+		start, end := span("string-fill!", a, 2, len(s.runes))
+		for i := start; i < end; i++ {
+			s.runes[i] = fill
+		}
+		return symbol("#%set!")
+	}},
+	"string->list": {arity{1, 3}, func(a ...scmer) scmer {
+		s := asStr("string->list", a[0])
+		start, end := span("string->list", a, 1, len(s.runes))
+		chars := make([]scmer, end-start)
+		for i := range chars {
+			chars[i] = char(s.runes[start+i])
+		}
+		return makeList(chars...)
+	}},
+	"list->string": {arity{1, 1}, func(a ...scmer) scmer {
+		chars, ok := listToSlice(a[0])
+		if !ok {
+			Fail("list->string: expected a list, got %s", a[0])
+		}
+		runes := make([]rune, len(chars))
+		for i, x := range chars {
+			runes[i] = asChar("list->string", x)
+		}
+		return &str{runes}
+	}},
+	"string->symbol": {arity{1, 1}, func(a ...scmer) scmer {
+		return symbol(asStr("string->symbol", a[0]).text())
+	}},
+	"symbol->string": {arity{1, 1}, func(a ...scmer) scmer {
+		sym, ok := a[0].(symbol)
+		if !ok {
+			Fail("symbol->string: expected a symbol, got %s", a[0])
+		}
+		return makeStr(string(sym))
+	}},
+	"string->number": {arity{1, 2}, func(a ...scmer) scmer {
+		text := asStr("string->number", a[0]).text()
+		if len(a) > 1 {
+			prefix, ok := radixPrefixes[a[1]]
+			if !ok {
+				Fail("string->number: expected a radix of 2, 8, 10 or 16, got %s", a[1])
+			}
+			text = prefix + text
+		}
+		if x, err := readNumber(scan.Token{Text: text}); err == nil {
+			return x
+		}
+		return boolean(false)
+	}},
+	"number->string": {arity{1, 2}, func(a ...scmer) scmer {
+		radix := 10
+		if len(a) > 1 {
+			if _, ok := radixPrefixes[a[1]]; !ok {
+				Fail("number->string: expected a radix of 2, 8, 10 or 16, got %s", a[1])
+			}
+			radix = int(a[1].(fixnum))
+		}
+		return makeStr(numberText("number->string", a[0], radix))
+	}},
+	"string-upcase": {arity{1, 1}, func(a ...scmer) scmer {
+		return makeStr(strings.ToUpper(asStr("string-upcase", a[0]).text()))
+	}},
+	"string-downcase": {arity{1, 1}, func(a ...scmer) scmer {
+		return makeStr(strings.ToLower(asStr("string-downcase", a[0]).text()))
+	}},
+	"string-foldcase": {arity{1, 1}, func(a ...scmer) scmer {
+		return makeStr(strings.Map(foldRune, asStr("string-foldcase", a[0]).text()))
+	}},
+	"string=?":     {arity{1, variadic}, stringComparison("string=?", false, func(c int) bool { return c == 0 })},
+	"string<?":     {arity{1, variadic}, stringComparison("string<?", false, func(c int) bool { return c < 0 })},
+	"string>?":     {arity{1, variadic}, stringComparison("string>?", false, func(c int) bool { return c > 0 })},
+	"string<=?":    {arity{1, variadic}, stringComparison("string<=?", false, func(c int) bool { return c <= 0 })},
+	"string>=?":    {arity{1, variadic}, stringComparison("string>=?", false, func(c int) bool { return c >= 0 })},
+	"string-ci=?":  {arity{1, variadic}, stringComparison("string-ci=?", true, func(c int) bool { return c == 0 })},
+	"string-ci<?":  {arity{1, variadic}, stringComparison("string-ci<?", true, func(c int) bool { return c < 0 })},
+	"string-ci>?":  {arity{1, variadic}, stringComparison("string-ci>?", true, func(c int) bool { return c > 0 })},
+	"string-ci<=?": {arity{1, variadic}, stringComparison("string-ci<=?", true, func(c int) bool { return c <= 0 })},
+	"string-ci>=?": {arity{1, variadic}, stringComparison("string-ci>=?", true, func(c int) bool { return c >= 0 })},
+	"string-map": {arity{2, variadic}, func(a ...scmer) scmer {
+		results := mapStrings("string-map", a[0], a[1:])
+		runes := make([]rune, len(results))
+		for i, x := range results {
+			runes[i] = asChar("string-map", x)
+		}
+		return &str{runes}
+	}},
+	"string-for-each": {arity{2, variadic}, func(a ...scmer) scmer {
+		mapStrings("string-for-each", a[0], a[1:])
+		return symbol("#%void")
+	}},
+}
+
+// radixPrefixes maps each radix that number->string and string->number take
+// to the prefix that gives it in a number's syntax.
+var radixPrefixes = map[scmer]string{
+	fixnum(2): "#b", fixnum(8): "#o", fixnum(10): "#d", fixnum(16): "#x",
+}
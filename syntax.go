@@ -0,0 +1,1101 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ Syntactic analysis: macro expansion
+
+ Before a top-level form is evaluated, expand rewrites it so that it contains
+ only core special forms and procedure applications. Macros are defined with
+ define-syntax, let-syntax and letrec-syntax, whose transformers are written
+ with syntax-rules.
+
+ Expansion is hygienic. Each identifier that a macro's template introduces
+ into the expansion is renamed, by wrapping it in an alias that remembers the
+ syntactic environment in which the macro was defined. A free alias means
+ whatever its name meant where the macro was defined, and a variable bound by
+ an alias is given a fresh name, so that it cannot capture a variable of the
+ same name at the macro's point of use.
+*/
+
+// An alias is an identifier introduced by a macro expansion.
+type alias struct {
+	name scmer // the symbol (or alias) that appeared in the template
+	env  *senv // the syntactic environment of the macro's definition
+}
+
+func (x *alias) String() string { return x.name.String() }
+
+// isIdentifier reports whether x is a symbol or an alias.
+func isIdentifier(x scmer) bool {
+	switch x.(type) {
+	case symbol, *alias:
+		return true
+	}
+	return false
+}
+
+// base returns the symbol from which an identifier was derived.
+func base(id scmer) symbol {
+	for {
+		switch x := id.(type) {
+		case symbol:
+			return x
+		case *alias:
+			id = x.name
+		default:
+			panic(fmt.Sprintf("base: not an identifier: %s", id))
+		}
+	}
+}
+
+// A senv is a syntactic environment: it records what the identifiers bound
+// by a lambda, let-syntax or letrec-syntax mean in the forms they enclose.
+// Identifiers that are bound by no senv refer to the global environment.
+type senv struct {
+	bindings map[scmer]*meaning
+	frame    bool // true if the senv belongs to a lambda, and holds its defines
+	outer    *senv
+}
+
+// A meaning is what an identifier denotes: a variable, or a macro.
+type meaning struct {
+	variable symbol // the variable's name in the expanded code
+	macro    *macro
+	defined  bool // the variable is defined at the head of a body
+}
+
+// globalMacros holds the macros defined by top-level define-syntax forms.
+var globalMacros = map[symbol]*macro{}
+
+// A macroDefinition records that a top-level form defined name as a global
+// macro, or, if macro is nil, as a variable in place of one.
+type macroDefinition struct {
+	name  symbol
+	macro *macro
+}
+
+// definedMacros lists, in order, the changes to globalMacros made in
+// expanding the current top-level form, so that a bytecode file can make them
+// again when it is loaded.
+var definedMacros []macroDefinition
+
+// coreForms are the special forms that expand understands.
+var coreForms = map[symbol]bool{
+	"quote":         true,
+	"if":            true,
+	"set!":          true,
+	"define":        true,
+	"lambda":        true,
+	"apply":         true,
+	"begin":         true,
+	"define-syntax": true,
+	"let-syntax":    true,
+	"letrec-syntax": true,
+	"syntax-rules":  true,
+	"syntax-error":  true,
+	"#%global":      true,
+
+	"quasiquote":       true,
+	"unquote":          true,
+	"unquote-splicing": true,
+
+	"guard": true,
+
+	// The derived expressions (see derived.go).
+	"let":           true,
+	"let*":          true,
+	"letrec":        true,
+	"letrec*":       true,
+	"let-values":    true,
+	"let*-values":   true,
+	"define-values": true,
+	"cond":          true,
+	"case":          true,
+	"and":           true,
+	"or":            true,
+	"when":          true,
+	"unless":        true,
+	"do":            true,
+	"case-lambda":   true,
+	"parameterize":  true,
+	"delay":         true,
+	"delay-force":   true,
+	"cond-expand":   true,
+
+	"define-record-type": true,
+}
+
+// resolve returns the meaning of identifier id in syntactic environment e.
+// If id is not bound locally, resolve returns nil and the name of the global
+// that id refers to.
+func resolve(id scmer, e *senv) (*meaning, symbol) {
+	for {
+		for f := e; f != nil; f = f.outer {
+			if m, ok := f.bindings[id]; ok {
+				return m, ""
+			}
+		}
+		switch x := id.(type) {
+		case symbol:
+			return nil, x
+		case *alias:
+			id, e = x.name, x.env
+		}
+	}
+}
+
+// keyword returns the core form that id denotes in e, or "" if it denotes
+// something else.
+func keyword(id scmer, e *senv) symbol {
+	if m, global := resolve(id, e); m == nil && coreForms[global] && globalMacros[global] == nil {
+		return global
+	}
+	return ""
+}
+
+// isAuxiliary reports whether x is the auxiliary syntax name (such as else or
+// =>) in e: that is, an identifier that refers to the global name.
+func isAuxiliary(x scmer, name symbol, e *senv) bool {
+	if !isIdentifier(x) {
+		return false
+	}
+	m, global := resolve(x, e)
+	return m == nil && global == name
+}
+
+// macroOf returns the macro that id denotes in e, or nil if it is not a macro.
+func macroOf(id scmer, e *senv) *macro {
+	if m, global := resolve(id, e); m != nil {
+		return m.macro
+	} else {
+		return globalMacros[global]
+	}
+}
+
+// shadowed reports whether a variable named name is bound locally in e.
+func shadowed(name symbol, e *senv) bool {
+	for f := e; f != nil; f = f.outer {
+		for _, m := range f.bindings {
+			if m.macro == nil && m.variable == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var renameCount int
+
+// fresh returns a variable name, derived from name, that is used nowhere else.
+func fresh(name symbol) symbol {
+	renameCount += 1
+	return symbol(fmt.Sprintf("#%%%s.%d", name, renameCount))
+}
+
+// sourceName returns the name from which a variable name was derived by
+// fresh, or name itself if it was not.
+func sourceName(name symbol) symbol {
+	s := string(name)
+	if i := strings.LastIndexByte(s, '.'); strings.HasPrefix(s, "#%") && i > 2 {
+		return symbol(s[2:i])
+	}
+	return name
+}
+
+// sourceParams returns the parameter list params, from an expanded lambda
+// expression, with each parameter given its name in the source.
+func sourceParams(params scmer) scmer {
+	switch p := params.(type) {
+	case *pair:
+		return &pair{sourceParams(p.car), sourceParams(p.cdr)}
+	case symbol:
+		return sourceName(p)
+	}
+	return params
+}
+
+// bind adds identifier id to e as a variable, and returns the variable's
+// name in the expanded code. That is id itself, unless id is an alias, would
+// shadow another local variable, or would be taken for a core form, in which
+// case it is a fresh name.
+func bind(id scmer, e *senv) symbol {
+	name := base(id)
+	if _, ok := id.(*alias); ok || shadowed(name, e) || coreForms[name] {
+		name = fresh(name)
+	}
+	e.bindings[id] = &meaning{variable: name}
+	return name
+}
+
+// expand returns form, with its macro uses expanded, ready to be evaluated in
+// syntactic environment e. A list it expands into has form's location, unless
+// it has its own.
+func expand(form scmer, e *senv) scmer {
+	loc := locationOf(form)
+	if loc == nil {
+		return expandForm(form, e)
+	}
+	saved := translating
+	translating = loc
+	x := expandForm(form, e)
+	translating = saved
+	if p, ok := x.(*pair); ok && locations[p] == nil {
+		locations[p] = loc
+		expanded = append(expanded, p)
+	}
+	return x
+}
+
+// expandForm does the work of expand.
+func expandForm(form scmer, e *senv) scmer {
+	switch x := form.(type) {
+	case symbol, *alias:
+		return expandVariable(x, e)
+	case *pair:
+		if isIdentifier(x.car) {
+			if m := macroOf(x.car, e); m != nil {
+				return expand(m.transcribe(x, e), e)
+			} else if k := keyword(x.car, e); k != "" {
+				return expandSpecial(k, x, e)
+			}
+		}
+		return expandList(x, e)
+	case *vector:
+		// A vector is a constant, whatever a template put in it.
+		return syntaxToDatum(x)
+	default:
+		return form
+	}
+}
+
+// expandVariable returns the expanded form of a reference to identifier id.
+func expandVariable(id scmer, e *senv) scmer {
+	m, global := resolve(id, e)
+	if m != nil && m.macro == nil {
+		return m.variable
+	} else if macroOf(id, e) != nil || keyword(id, e) != "" {
+		Fail("bad syntax: keyword used as a variable: %s", id)
+	} else if _, ok := id.(*alias); ok && shadowed(global, e) {
+		// The alias means the global variable, but the name is taken here.
+		return makeList(symbol("#%global"), global)
+	}
+	return global
+}
+
+// expandList expands each element of a list.
+func expandList(list scmer, e *senv) scmer {
+	switch x := list.(type) {
+	case *pair:
+		head := expand(x.car, e)
+		return &pair{head, expandList(x.cdr, e)}
+	case emptyList:
+		return x
+	default:
+		return expand(x, e)
+	}
+}
+
+// expandSpecial expands a core special form.
+func expandSpecial(k symbol, x *pair, e *senv) scmer {
+	form, ok := listToSlice(x)
+	if !ok {
+		Fail("bad syntax: improper list in %s form: %s", k, x)
+	}
+	switch k {
+	case "quote":
+		if len(form) != 2 {
+			Fail("bad syntax: quote requires exactly 1 argument: %s", x)
+		}
+		return makeList(k, syntaxToDatum(form[1]))
+	case "if":
+		if len(form) != 3 && len(form) != 4 {
+			Fail("bad syntax: if requires a test, a consequent and an optional alternative: %s", x)
+		}
+	case "set!":
+		if len(form) != 3 || !isIdentifier(form[1]) {
+			Fail("bad syntax: set!: %s", x)
+		}
+		return makeList(k, expandVariable(form[1], e), expand(form[2], e))
+	case "define":
+		return expandDefine(form, e)
+	case "lambda":
+		if len(form) < 3 {
+			Fail("bad syntax: lambda requires parameters and a body: %s", x)
+		}
+		return expandLambda(form[1], form[2:], e)
+	case "define-syntax":
+		if len(form) != 3 || !isIdentifier(form[1]) {
+			Fail("bad syntax: define-syntax: %s", x)
+		}
+		m := makeMacro(form[2], e)
+		if f := frameOf(e); f != nil {
+			f.bindings[form[1]] = &meaning{macro: m}
+		} else {
+			globalMacros[base(form[1])] = m
+			definedMacros = append(definedMacros, macroDefinition{base(form[1]), m})
+		}
+		return makeList(symbol("quote"),
+			makeList(symbol("#%undef"), k, base(form[1])))
+	case "let-syntax", "letrec-syntax":
+		if len(form) < 3 {
+			Fail("bad syntax: %s requires bindings and a body: %s", k, x)
+		}
+		inner := &senv{map[scmer]*meaning{}, false, e}
+		defEnv := e
+		if k == "letrec-syntax" {
+			defEnv = inner
+		}
+		bindings, ok := listToSlice(form[1])
+		if !ok {
+			Fail("bad syntax: %s: bindings must be a list: %s", k, x)
+		}
+		for _, b := range bindings {
+			spec, ok := listToSlice(b)
+			if !ok || len(spec) != 2 || !isIdentifier(spec[0]) {
+				Fail("bad syntax: %s: bad binding: %s", k, b)
+			}
+			inner.bindings[spec[0]] = &meaning{macro: makeMacro(spec[1], defEnv)}
+		}
+		return &pair{symbol("begin"), expandList(x.cdr.(*pair).cdr, inner)}
+	case "syntax-rules":
+		Fail("bad syntax: syntax-rules used outside of a macro definition: %s", x)
+	case "syntax-error":
+		// It is an error as soon as it is expanded, with the message and
+		// irritants given, as if by error.
+		if len(form) < 2 {
+			Fail("bad syntax: syntax-error requires a message: %s", syntaxToDatum(x))
+		}
+		message, ok := form[1].(*str)
+		if !ok {
+			Fail("bad syntax: syntax-error: expected a string message, got %s", syntaxToDatum(form[1]))
+		}
+		panic(&errorObject{message, syntaxToDatum(makeList(form[2:]...)), nil})
+	case "quasiquote":
+		if len(form) != 2 {
+			Fail("bad syntax: quasiquote requires exactly 1 argument: %s", x)
+		}
+		return expandQuasiquote(form[1], 1, e)
+	case "unquote", "unquote-splicing":
+		Fail("bad syntax: %s used outside of quasiquote: %s", k, x)
+	case "guard":
+		return expandGuard(form, e)
+	case "let":
+		return expandLet(form, e)
+	case "let*":
+		return expandLetStar(form, e)
+	case "letrec", "letrec*":
+		return expandLetrec(form, e)
+	case "let-values":
+		return expandLetValues(form, e)
+	case "let*-values":
+		return expandLetStarValues(form, e)
+	case "define-values":
+		return expandDefineValues(form, e)
+	case "cond":
+		return expandClauses(form[1:], unspecified(), e)
+	case "case":
+		return expandCase(form, e)
+	case "and":
+		return expandAnd(form, e)
+	case "or":
+		return expandOr(form, e)
+	case "when", "unless":
+		return expandWhen(form, e)
+	case "do":
+		return expandDo(form, e)
+	case "case-lambda":
+		return expandCaseLambda(form, e)
+	case "parameterize":
+		return expandParameterize(form, e)
+	case "delay", "delay-force":
+		return expandDelay(form, e)
+	case "cond-expand":
+		body := condExpansion(form, e)
+		if len(body) == 0 {
+			return unspecified()
+		}
+		return &pair{symbol("begin"), expandList(makeList(body...), e)}
+	case "define-record-type":
+		return expandDefineRecordType(form, e)
+	case "#%global":
+		return x
+	}
+	// if, apply and begin need nothing but their subforms expanded.
+	return &pair{k, expandList(x.cdr, e)}
+}
+
+// frameOf returns the senv that holds the definitions made in e, or nil if
+// they are global.
+func frameOf(e *senv) *senv {
+	for ; e != nil; e = e.outer {
+		if e.frame {
+			return e
+		}
+	}
+	return nil
+}
+
+func expandDefine(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: define: %s", makeList(form...))
+	}
+	target, params := form[1], scmer(nil)
+	if p, ok := target.(*pair); ok {
+		target, params = p.car, p.cdr
+	}
+	if !isIdentifier(target) {
+		Fail("bad syntax: define: not an identifier: %s", target)
+	}
+	var name symbol
+	if f := frameOf(e); f != nil {
+		// The variable was bound when the body was scanned; a define found
+		// anywhere else is in an expression.
+		m, ok := f.bindings[target]
+		if !ok || !m.defined {
+			Fail("bad syntax: define: not allowed in an expression context: %s",
+				syntaxToDatum(makeList(form...)))
+		}
+		name = m.variable
+	} else {
+		name = base(target)
+		if globalMacros[name] != nil {
+			delete(globalMacros, name)
+			definedMacros = append(definedMacros, macroDefinition{name, nil})
+		}
+	}
+	if params == nil {
+		return &pair{symbol("define"), &pair{name, expandList(makeList(form[2:]...), e)}}
+	}
+	x := expandLambda(params, form[2:], e).(*pair)
+	lambda := x.cdr.(*pair)
+	def := &pair{symbol("define"), &pair{&pair{name, lambda.car}, lambda.cdr}}
+	sources[def] = sources[x]
+	delete(sources, x)
+	return def
+}
+
+// sources holds the lambda expression, as written, that each lambda
+// expression expansion has produced came from, until it is analyzed. It is
+// how the procedure prints.
+var sources = map[*pair]scmer{}
+
+// expandLambda expands a lambda expression with the given parameters and body.
+func expandLambda(params scmer, body []scmer, e *senv) scmer {
+	source := syntaxToDatum(&pair{symbol("lambda"), &pair{params, makeList(body...)}})
+	inner := &senv{map[scmer]*meaning{}, true, e}
+	var names []scmer
+	for {
+		if p, ok := params.(*pair); ok && isIdentifier(p.car) {
+			if _, ok := inner.bindings[p.car]; ok {
+				Fail("bad syntax: lambda: duplicate parameter: %s", p.car)
+			}
+			names = append(names, bind(p.car, inner))
+			params = p.cdr
+		} else if isIdentifier(params) {
+			if _, ok := inner.bindings[params]; ok {
+				Fail("bad syntax: lambda: duplicate parameter: %s", params)
+			}
+			params = bind(params, inner)
+			break
+		} else if params == empty {
+			break
+		} else {
+			Fail("bad syntax: lambda: bad parameter list: %s", params)
+		}
+	}
+	x := &pair{symbol("lambda"),
+		&pair{makeDottedList(names, params), expandBody(body, inner)}}
+	sources[x] = source
+	return x
+}
+
+// expandBody expands the body of a lambda expression, in e, the syntactic
+// environment of the lambda. The body is scanned for definitions before
+// anything in it is expanded, and their variables bound, so that every form of
+// the body is within their scope: a procedure defined in it can refer to one
+// defined after it. The definitions must come first, and at least one
+// expression after them.
+func expandBody(body []scmer, e *senv) scmer {
+	forms, expr := scanBody(body, e, nil, false)
+	if !expr {
+		Fail("bad syntax: body has no expression: %s", syntaxToDatum(makeList(body...)))
+	}
+	return expandList(makeList(forms...), e)
+}
+
+// scanBody appends the forms of body to forms, and returns the result, and
+// whether there is an expression among them. Each form that is a macro use is
+// transcribed, and each begin replaced by the forms within it, so as to find
+// the definitions among them. The variables they define are bound in e, and the
+// macros defined, as they are found. expr says whether an expression came
+// before body, after which a definition is an error.
+func scanBody(body []scmer, e *senv, forms []scmer, expr bool) ([]scmer, bool) {
+	for _, x := range body {
+		p, ok := x.(*pair)
+		for ok && isIdentifier(p.car) && macroOf(p.car, e) != nil {
+			x = macroOf(p.car, e).transcribe(p, e)
+			p, ok = x.(*pair)
+		}
+		if !ok || !isIdentifier(p.car) {
+			forms, expr = append(forms, x), true
+			continue
+		}
+		switch k := keyword(p.car, e); k {
+		case "begin":
+			items, ok := listToSlice(p.cdr)
+			if !ok {
+				Fail("bad syntax: improper list in begin form: %s", syntaxToDatum(p))
+			}
+			forms, expr = scanBody(items, e, forms, expr)
+			continue
+		case "cond-expand":
+			// The forms of the chosen clause are part of the body.
+			form, ok := listToSlice(p)
+			if !ok {
+				Fail("bad syntax: improper list in cond-expand form: %s", syntaxToDatum(p))
+			}
+			forms, expr = scanBody(condExpansion(form, e), e, forms, expr)
+			continue
+		case "define", "define-values", "define-record-type", "define-syntax":
+			saved := translating
+			if loc := locationOf(p); loc != nil {
+				translating = loc
+			}
+			if expr {
+				Fail("bad syntax: %s: definition after an expression in a body: %s",
+					k, syntaxToDatum(p))
+			}
+			switch k {
+			case "define":
+				if target, ok := p.cdr.(*pair); ok {
+					if t, ok := target.car.(*pair); ok {
+						declare(t.car, k, e)
+					} else {
+						declare(target.car, k, e)
+					}
+				}
+			case "define-values":
+				if formals, ok := p.cdr.(*pair); ok {
+					for x := formals.car; ; x = x.(*pair).cdr {
+						if f, ok := x.(*pair); ok {
+							declare(f.car, k, e)
+							continue
+						}
+						declare(x, k, e)
+						break
+					}
+				}
+			case "define-record-type":
+				for _, id := range recordTypeNames(p) {
+					declare(id, k, e)
+				}
+			case "define-syntax":
+				// Expanding it defines the macro, which later forms may use.
+				x = expand(x, e)
+			}
+			translating = saved
+		default:
+			expr = true
+		}
+		forms = append(forms, x)
+	}
+	return forms, expr
+}
+
+// declare binds identifier id in e as a variable defined by the body, unless
+// it is bound there already, as a parameter. A define of id in e will then
+// assign to that variable. who is the kind of definition, for errors.
+func declare(id scmer, who symbol, e *senv) {
+	if !isIdentifier(id) {
+		return // the define will report it
+	}
+	m, ok := e.bindings[id]
+	if ok && m.defined {
+		Fail("bad syntax: %s: duplicate definition: %s", who, syntaxToDatum(id))
+	} else if !ok || m.macro != nil {
+		bind(id, e)
+		m = e.bindings[id]
+	}
+	m.defined = true
+}
+
+// syntaxToDatum returns x with every alias in it replaced by its symbol.
+// If x contains no aliases, it is returned unchanged.
+func syntaxToDatum(x scmer) scmer {
+	return stripAliases(x, map[scmer]bool{})
+}
+
+// stripAliases does syntaxToDatum for x, which is within the pairs and vectors
+// in inside. One found within itself is part of a cycle, which only the reader
+// makes (with datum labels), so it has no aliases in it.
+func stripAliases(x scmer, inside map[scmer]bool) scmer {
+	switch v := x.(type) {
+	case *alias:
+		return base(v)
+	case *pair:
+		if inside[v] {
+			return v
+		}
+		inside[v] = true
+		defer delete(inside, v)
+		car, cdr := stripAliases(v.car, inside), stripAliases(v.cdr, inside)
+		if car == v.car && cdr == v.cdr {
+			return v
+		}
+		return &pair{car, cdr}
+	case *vector:
+		if inside[v] {
+			return v
+		}
+		inside[v] = true
+		defer delete(inside, v)
+		items, changed := make([]scmer, len(v.items)), false
+		for i, item := range v.items {
+			items[i] = stripAliases(item, inside)
+			changed = changed || items[i] != item
+		}
+		if !changed {
+			return v
+		}
+		return &vector{items}
+	}
+	return x
+}
+
+/*
+ Guard
+*/
+
+// expandGuard expands (guard (var clause ...) body ...) as R7RS defines it:
+//
+//	((call/cc
+//	   (lambda (guard-k)
+//	     (with-exception-handler
+//	       (lambda (condition)
+//	         ((call/cc
+//	            (lambda (handler-k)
+//	              (guard-k
+//	                (lambda ()
+//	                  ((lambda (var) <clauses>) condition)))))))
+//	       (lambda ()
+//	         ((lambda (value) (guard-k (lambda () value)))
+//	          (begin body ...)))))))
+//
+// where <clauses> tests the clauses like cond, and, if none applies, re-raises
+// the condition in the dynamic environment of the original raise:
+//
+//	(handler-k (lambda () (raise-continuable condition)))
+func expandGuard(form []scmer, e *senv) scmer {
+	if len(form) < 3 {
+		Fail("bad syntax: guard requires a variable, clauses and a body: %s", makeList(form...))
+	}
+	spec, ok := listToSlice(form[1])
+	if !ok || len(spec) == 0 || !isIdentifier(spec[0]) {
+		Fail("bad syntax: guard: expected (variable clause ...): %s", form[1])
+	}
+	guardK, condition, handlerK, value := fresh("guard-k"), fresh("condition"), fresh("handler-k"), fresh("value")
+	lambda := func(params scmer, body scmer) scmer {
+		return makeList(symbol("lambda"), params, body)
+	}
+
+	inner := &senv{map[scmer]*meaning{}, true, e}
+	variable := bind(spec[0], inner)
+	reraise := makeList(handlerK, lambda(empty, makeList(guardRaiseContinuable, condition)))
+	clauses := makeList(lambda(makeList(variable), expandClauses(spec[1:], reraise, inner)), condition)
+	handler := lambda(makeList(condition),
+		makeList(makeList(guardCallCC, lambda(makeList(handlerK),
+			makeList(guardK, lambda(empty, clauses))))))
+	body := &pair{symbol("begin"), expandList(makeList(form[2:]...), e)}
+	thunk := lambda(empty,
+		makeList(lambda(makeList(value), makeList(guardK, lambda(empty, value))), body))
+	return makeList(makeList(guardCallCC, lambda(makeList(guardK),
+		makeList(guardWithExceptionHandler, handler, thunk))))
+}
+
+// expandClauses expands cond clauses into nested ifs. If no clause applies,
+// the result is the value of otherwise, an expanded expression.
+func expandClauses(clauses []scmer, otherwise scmer, e *senv) scmer {
+	if len(clauses) == 0 {
+		return otherwise
+	}
+	clause, ok := listToSlice(clauses[0])
+	if !ok || len(clause) == 0 {
+		Fail("bad syntax: bad clause: %s", clauses[0])
+	}
+	switch {
+	case isAuxiliary(clause[0], "else", e):
+		if len(clauses) > 1 || len(clause) < 2 {
+			Fail("bad syntax: else clause must be last, and not empty: %s", clauses[0])
+		}
+		return &pair{symbol("begin"), expandList(makeList(clause[1:]...), e)}
+	case len(clause) == 1 || len(clause) == 3 && isAuxiliary(clause[1], "=>", e):
+		// ((lambda (test) (if test test-or-(receiver test) rest)) clause[0])
+		test := fresh("test")
+		var consequent scmer = test
+		if len(clause) == 3 {
+			consequent = makeList(expand(clause[2], e), test)
+		}
+		rest := expandClauses(clauses[1:], otherwise, e)
+		return makeList(
+			makeList(symbol("lambda"), makeList(test), makeList(symbol("if"), test, consequent, rest)),
+			expand(clause[0], e))
+	}
+	return makeList(symbol("if"),
+		expand(clause[0], e),
+		&pair{symbol("begin"), expandList(makeList(clause[1:]...), e)},
+		expandClauses(clauses[1:], otherwise, e))
+}
+
+/*
+ Quasiquote
+*/
+
+// qqCons, qqAppend and qqVector build the values of quasiquote templates.
+// Expansions refer to them directly, rather than by name, so that they work
+// even where cons, append and list->vector have been redefined.
+var (
+	qqCons   = &primitive{"cons", arity{2, 2}, func(a ...scmer) scmer { return &pair{a[0], a[1]} }}
+	qqAppend = &primitive{"append", arity{0, variadic}, appendLists}
+	qqVector = &primitive{"list->vector", arity{1, 1}, listToVector}
+)
+
+// expandQuasiquote returns an expression that builds template, the body of
+// a quasiquote nested depth levels deep.
+func expandQuasiquote(template scmer, depth int, e *senv) scmer {
+	if v, ok := template.(*vector); ok {
+		// Build the items as a list, and convert it.
+		items := expandQuasiquote(makeList(v.items...), depth, e)
+		if list, ok := quoted(items); ok {
+			constant, _ := listToSlice(list)
+			return quotation(&vector{constant})
+		}
+		return makeList(qqVector, items)
+	}
+	p, ok := template.(*pair)
+	if !ok {
+		return quotation(template)
+	}
+	if k, arg, ok := unquotation(p, e); ok {
+		switch {
+		case k == "unquote" && depth == 1:
+			return expand(arg, e)
+		case k == "unquote":
+			return consQuasiquote(k, expandQuasiquote(arg, depth-1, e))
+		case k == "unquote-splicing" && depth == 1:
+			Fail("bad syntax: unquote-splicing: not in a list: %s", syntaxToDatum(p))
+		case k == "unquote-splicing":
+			return consQuasiquote(k, expandQuasiquote(arg, depth-1, e))
+		case k == "quasiquote":
+			return consQuasiquote(k, expandQuasiquote(arg, depth+1, e))
+		}
+	}
+	rest := expandQuasiquote(p.cdr, depth, e)
+	if inner, ok := p.car.(*pair); ok {
+		if k, arg, ok := unquotation(inner, e); ok && k == "unquote-splicing" {
+			if depth == 1 && equal(rest, quotation(empty)) {
+				// The spliced list is the tail of the result, so share it.
+				return expand(arg, e)
+			} else if depth == 1 {
+				return makeList(qqAppend, expand(arg, e), rest)
+			}
+			first := consQuasiquote(k, expandQuasiquote(arg, depth-1, e))
+			return makeList(qqCons, first, rest)
+		}
+	}
+	first := expandQuasiquote(p.car, depth, e)
+	if car, ok := quoted(first); ok {
+		if cdr, ok := quoted(rest); ok {
+			// Nothing in template is unquoted, so it can be a constant.
+			if car != p.car || cdr != p.cdr {
+				return quotation(&pair{car, cdr})
+			}
+			return quotation(p)
+		}
+	}
+	return makeList(qqCons, first, rest)
+}
+
+// unquotation reports whether p is (unquote arg), (unquote-splicing arg) or
+// (quasiquote arg), and if so, returns which and arg.
+func unquotation(p *pair, e *senv) (symbol, scmer, bool) {
+	if !isIdentifier(p.car) {
+		return "", nil, false
+	}
+	switch k := keyword(p.car, e); k {
+	case "unquote", "unquote-splicing", "quasiquote":
+		if rest, ok := p.cdr.(*pair); ok && rest.cdr == empty {
+			return k, rest.car, true
+		}
+		Fail("bad syntax: %s requires exactly 1 argument: %s", k, syntaxToDatum(p))
+	}
+	return "", nil, false
+}
+
+// consQuasiquote returns an expression that builds (k arg), where arg is the
+// value of the expression argExpr.
+func consQuasiquote(k symbol, argExpr scmer) scmer {
+	if arg, ok := quoted(argExpr); ok {
+		return quotation(&pair{k, &pair{arg, empty}})
+	}
+	return makeList(qqCons, quotation(k), makeList(qqCons, argExpr, quotation(empty)))
+}
+
+// quotation returns (quote x).
+func quotation(x scmer) scmer {
+	return makeList(symbol("quote"), syntaxToDatum(x))
+}
+
+// quoted reports whether expr is (quote x), and if so, returns x.
+func quoted(expr scmer) (scmer, bool) {
+	if p, ok := expr.(*pair); ok && p.car == symbol("quote") {
+		return p.cdr.(*pair).car, true
+	}
+	return nil, false
+}
+
+/*
+ syntax-rules
+*/
+
+// A macro is a syntax-rules transformer.
+type macro struct {
+	ellipsis scmer   // the identifier that means "...", normally ... itself
+	literals []scmer // identifiers that match only themselves
+	rules    [][2]scmer
+	env      *senv // the syntactic environment of the macro's definition
+}
+
+// makeMacro returns the macro described by spec, a syntax-rules form.
+func makeMacro(spec scmer, e *senv) *macro {
+	form, ok := listToSlice(spec)
+	if !ok || len(form) < 2 || !isIdentifier(form[0]) || keyword(form[0], e) != "syntax-rules" {
+		Fail("bad syntax: expected a syntax-rules form: %s", spec)
+	}
+	m := &macro{ellipsis: symbol("..."), env: e}
+	form = form[1:]
+	if isIdentifier(form[0]) {
+		m.ellipsis, form = form[0], form[1:]
+	}
+	if len(form) == 0 {
+		Fail("bad syntax: syntax-rules: missing literals: %s", spec)
+	}
+	if m.literals, ok = listToSlice(form[0]); !ok {
+		Fail("bad syntax: syntax-rules: literals must be a list: %s", spec)
+	}
+	for _, r := range form[1:] {
+		rule, ok := listToSlice(r)
+		if !ok || len(rule) != 2 {
+			Fail("bad syntax: syntax-rules: bad rule: %s", r)
+		}
+		if _, ok := rule[0].(*pair); !ok {
+			Fail("bad syntax: syntax-rules: pattern must be a list: %s", r)
+		}
+		m.rules = append(m.rules, [2]scmer{rule[0], rule[1]})
+	}
+	return m
+}
+
+// bindings maps the pattern variables of a rule to what they matched. A
+// variable followed by n ellipses in the pattern is bound to a []interface{}
+// nested n deep.
+type bindings map[scmer]interface{}
+
+// transcribe rewrites form, a use of macro m in syntactic environment e.
+func (m *macro) transcribe(form *pair, e *senv) scmer {
+	for _, rule := range m.rules {
+		b := bindings{}
+		// The keyword position of the pattern is ignored.
+		if m.match(rule[0].(*pair).cdr, form.cdr, e, b) {
+			aliases := map[scmer]*alias{}
+			return m.instantiate(rule[1], b, aliases)
+		}
+	}
+	Fail("bad syntax: no syntax-rules pattern matches: %s", syntaxToDatum(form))
+	panic("Fail didn't panic")
+}
+
+// isEllipsis reports whether x is the macro's ellipsis identifier. The usual
+// ellipsis, ..., is recognized even if it was renamed by another macro (as it
+// is when a macro's expansion defines a macro).
+func (m *macro) isEllipsis(x scmer) bool {
+	if !isIdentifier(x) || m.ellipsis == nil {
+		return false
+	} else if m.ellipsis == symbol("...") {
+		return base(x) == "..."
+	}
+	return x == m.ellipsis
+}
+
+func (m *macro) isLiteral(x scmer) bool {
+	for _, l := range m.literals {
+		if l == x {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether form, in the macro use's environment e, matches
+// pattern. If so, the pattern variables are added to b.
+func (m *macro) match(pattern, form scmer, e *senv, b bindings) bool {
+	switch p := pattern.(type) {
+	case symbol, *alias:
+		if m.isLiteral(p) {
+			return isIdentifier(form) && sameBinding(form, e, p, m.env)
+		}
+		if base(p) != "_" {
+			b[p] = form
+		}
+		return true
+	case *pair:
+		if next, ok := p.cdr.(*pair); ok && m.isEllipsis(next.car) {
+			return m.matchEllipsis(p.car, next.cdr, form, e, b)
+		}
+		f, ok := form.(*pair)
+		return ok && m.match(p.car, f.car, e, b) && m.match(p.cdr, f.cdr, e, b)
+	case emptyList:
+		return form == empty
+	case *vector:
+		// #(p ...) matches a vector whose items, as a list, match (p ...).
+		f, ok := form.(*vector)
+		return ok && m.match(makeList(p.items...), makeList(f.items...), e, b)
+	default:
+		return equal(pattern, form)
+	}
+}
+
+// matchEllipsis matches form against the pattern `item ... . rest`.
+func (m *macro) matchEllipsis(item, rest, form scmer, e *senv, b bindings) bool {
+	// item matches as many elements of form as rest leaves over.
+	n := length(form) - length(rest)
+	if n < 0 {
+		return false
+	}
+	matches := make([]bindings, n)
+	for i := range matches {
+		f := form.(*pair)
+		matches[i] = bindings{}
+		if !m.match(item, f.car, e, matches[i]) {
+			return false
+		}
+		form = f.cdr
+	}
+	for _, v := range m.patternVars(item, nil) {
+		seq := make([]interface{}, n)
+		for i, match := range matches {
+			seq[i] = match[v]
+		}
+		b[v] = seq
+	}
+	return m.match(rest, form, e, b)
+}
+
+// patternVars appends the pattern variables in pattern to vars.
+func (m *macro) patternVars(pattern scmer, vars []scmer) []scmer {
+	switch p := pattern.(type) {
+	case symbol, *alias:
+		if !m.isLiteral(p) && !m.isEllipsis(p) && base(p) != "_" {
+			vars = append(vars, p)
+		}
+	case *pair:
+		vars = m.patternVars(p.cdr, m.patternVars(p.car, vars))
+	case *vector:
+		for _, item := range p.items {
+			vars = m.patternVars(item, vars)
+		}
+	}
+	return vars
+}
+
+// sameBinding reports whether identifier a in environment ea means the same
+// thing as identifier b in environment eb.
+func sameBinding(a scmer, ea *senv, b scmer, eb *senv) bool {
+	ma, ga := resolve(a, ea)
+	mb, gb := resolve(b, eb)
+	return ma == mb && ga == gb
+}
+
+// instantiate fills in template with the values of the pattern variables in b.
+// Other identifiers are renamed with aliases, one per identifier.
+func (m *macro) instantiate(template scmer, b bindings, aliases map[scmer]*alias) scmer {
+	switch t := template.(type) {
+	case symbol, *alias:
+		if v, ok := b[t]; ok {
+			if x, ok := v.(scmer); ok {
+				return x
+			}
+			Fail("bad syntax: pattern variable used without ellipsis: %s", t)
+		}
+		a, ok := aliases[t]
+		if !ok {
+			a = &alias{t, m.env}
+			aliases[t] = a
+		}
+		return a
+	case *pair:
+		if m.isEllipsis(t.car) {
+			// (... template) means template, with ellipses taken literally.
+			if next, ok := t.cdr.(*pair); ok && next.cdr == empty {
+				literal := *m
+				literal.ellipsis = nil
+				return literal.instantiate(next.car, b, aliases)
+			}
+			Fail("bad syntax: misplaced ellipsis in template: %s", syntaxToDatum(t))
+		}
+		rest, depth := t.cdr, 0
+		for next, ok := rest.(*pair); ok && m.isEllipsis(next.car); next, ok = rest.(*pair) {
+			rest, depth = next.cdr, depth+1
+		}
+		tail := m.instantiate(rest, b, aliases)
+		if depth == 0 {
+			return &pair{m.instantiate(t.car, b, aliases), tail}
+		}
+		items := m.instantiateEllipsis(t.car, depth, b, aliases)
+		return makeDottedList(items, tail)
+	case *vector:
+		// #(t ...) is the vector of the items of (t ...).
+		items, _ := listToSlice(m.instantiate(makeList(t.items...), b, aliases))
+		return &vector{items}
+	default:
+		return template
+	}
+}
+
+// instantiateEllipsis returns the instances of `template ...`, with depth
+// ellipses following template.
+func (m *macro) instantiateEllipsis(template scmer, depth int, b bindings, aliases map[scmer]*alias) []scmer {
+	// The variables in template which have sequences to iterate over.
+	var seqs []scmer
+	n := -1
+	for _, v := range m.patternVars(template, nil) {
+		if seq, ok := b[v].([]interface{}); ok {
+			if n >= 0 && len(seq) != n {
+				Fail("bad syntax: pattern variables in the same ellipsis matched different numbers of items: %s", syntaxToDatum(template))
+			}
+			seqs, n = append(seqs, v), len(seq)
+		}
+	}
+	if seqs == nil {
+		Fail("bad syntax: no pattern variable to repeat with ellipsis: %s", syntaxToDatum(template))
+	}
+	var items []scmer
+	for i := 0; i < n; i++ {
+		inner := bindings{}
+		for k, v := range b {
+			inner[k] = v
+		}
+		for _, v := range seqs {
+			inner[v] = b[v].([]interface{})[i]
+		}
+		if depth > 1 {
+			items = append(items, m.instantiateEllipsis(template, depth-1, inner, aliases)...)
+		} else {
+			items = append(items, m.instantiate(template, inner, aliases))
+		}
+	}
+	return items
+}
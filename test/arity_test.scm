@@ -0,0 +1,54 @@
+; Arity checking.  Run with
+;   LiSP -test test/arity_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; primitives
+(car '(1 2) '(3))  ***
+(car)  ***
+(cons 1)  ***
+(vector-ref (vector 1 2))  ***
+(substring "hello" 1)  ***
+(+)  0
+(- 5)  -5
+(-)  ***
+(make-vector 2 'x)  #(x x)
+(make-vector 2 'x 'y)  ***
+(newline 1 2)  ***
+
+; controls
+(call/cc)  ***
+(dynamic-wind (lambda () 1) (lambda () 2))  ***
+(force)  ***
+
+; procedures
+(define (two a b) (list a b))  ---
+(two 1 2)  (1 2)
+(two 1)  ***
+(two 1 2 3)  ***
+((lambda (x . rest) rest) 1 2 3)  (2 3)
+((lambda (x . rest) rest))  ***
+((lambda args args))  ()
+(define-record-type <box> (make-box v) box? (v unbox))  ---
+(make-box)  ***
+(unbox (make-box 1) 2)  ***
+
+; procedure-arity
+(procedure-arity car)  1
+(procedure-arity cons)  2
+(procedure-arity +)  (0 . #f)
+(procedure-arity -)  (1 . #f)
+(procedure-arity make-vector)  (1 . 2)
+(procedure-arity vector-fill!)  (2 . 4)
+(procedure-arity call/cc)  1
+(procedure-arity two)  2
+(procedure-arity (case-lambda ((a) 1) ((a b) 2)))  (1 . 2)
+(procedure-arity (case-lambda ((a b) 2) ((a) 1)))  (1 . 2)
+(procedure-arity (case-lambda ((a) 1) ((a . r) 2)))  (1 . #f)
+(procedure-arity (case-lambda ((a) 1)))  1
+((case-lambda ((a) 1) ((a b) 2)))  ***
+(procedure-arity (lambda (a b . c) a))  (2 . #f)
+(procedure-arity (lambda args args))  (0 . #f)
+(procedure-arity make-box)  1
+(procedure-arity (make-parameter 1))  0
+(call/cc (lambda (k) (procedure-arity k)))  (0 . #f)
+(procedure-arity 'car)  ***
@@ -0,0 +1,96 @@
+; Bodies and internal definitions.  Run with
+;   LiSP -test test/bodies_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; a body is a sequence
+((lambda (x) (set! x (+ x 1)) (* x 2)) 3)  8
+(define (twice x) (define y (* x 2)) (+ y 1))  ---
+(twice 3)  7
+(let ((n 0)) (set! n (+ n 1)) (set! n (+ n 1)) n)  2
+(lambda (x))  ***
+
+; definitions come first, and at least one expression after them
+((lambda () (define a 1)))  ***
+((lambda () (define-values (a b) (values 1 2))))  ***
+((lambda () 1 (define a 2) a))  ***
+((lambda () (display "") (begin (define a 2)) a))  ***
+((lambda () (begin) (define a 2) a))  2
+(let () (define-syntax m (syntax-rules () ((_) 1))) (m))  1
+(let () (m0) (define-syntax m0 (syntax-rules () ((_) 1))))  ***
+
+; a definition anywhere but the head of a body is an error, and so is defining
+; a variable twice there
+(let ((z 1)) (if #t (define q 5)) q)  ***
+(define (f) (display "") (when #t (define x 2)) x)  ***
+((lambda (x) (if x (define y 1)) y) #t)  ***
+(let () (define x 1) (define x 2) x)  ***
+(let () (define x 1) (define-values (y x) (values 2 3)) x)  ***
+(let () (define (g) 1) (define g 2) g)  ***
+((lambda (x) (define x 2) (define y x) y) 1)  2
+(if #t (define top-level-q 5))  ---
+top-level-q  5
+
+; internal definitions are in the scope of the whole body
+(define (parity n)
+  (define (ev? n) (if (= n 0) 'even (od? (- n 1))))
+  (define (od? n) (if (= n 0) 'odd (ev? (- n 1))))
+  (ev? n))  ---
+(parity 10)  even
+(parity 7)  odd
+(define (counter)
+  (define n 0)
+  (define (next) (set! n (+ n 1)) n)
+  (next)
+  (next))  ---
+(counter)  2
+(define x 'outer)  ---
+(define (shadow) (define y x) (define x 'inner) y)  ---
+(shadow)  ***
+((lambda () (define a b) (define b 1) a))  ***
+((lambda () (define a (lambda () b)) (define b 1) (a)))  1
+((lambda (x) (define x 2) x) 1)  2
+
+; begins are spliced into the body, and macros defined in it
+((lambda ()
+   (begin (define a 1) (define b 2))
+   (+ a b)))  3
+((lambda ()
+   (define-syntax def2
+     (syntax-rules () ((_ a b v) (begin (define a v) (define b v)))))
+   (define (f) (+ p q))
+   (def2 p q 10)
+   (f)))  20
+
+; define-values
+((lambda ()
+   (define-values (q r) (values 7 2))
+   (define-values (a . rest) (values 1 2 3))
+   (list q r a rest)))  (7 2 1 (2 3))
+((lambda ()
+   (define (f) (list lo hi))
+   (define-values (lo hi) (values 0 9))
+   (f)))  (0 9)
+
+; define-record-type
+(define-record-type <point> (make-point x y) point?
+  (x point-x set-point-x!)
+  (y point-y))  ---
+(define p (make-point 1 2))  ---
+(point? p)  #t
+(point? 5)  #f
+(list (point-x p) (point-y p))  (1 2)
+(set-point-x! p 10)  ---
+(point-x p)  10
+(point-x 'nope)  ***
+(make-point 1)  ***
+((lambda ()
+   (define (origin) (make-node 0 '()))
+   (define-record-type node (make-node value children) node?
+     (value node-value)
+     (children node-children set-node-children!))
+   (define n (origin))
+   (set-node-children! n (list (make-node 1 '())))
+   (list (node? n) (node-value (car (node-children n))))))  (#t 1)
+(define-record-type <pare> (kons a) pare? (a kar) (b kdr set-kdr!))  ---
+(kdr (kons 1))  #f
+(define-record-type <bad> (make-bad z) bad? (a bad-a))  ***
@@ -0,0 +1,104 @@
+; First-class continuations and dynamic-wind.
+
+; Escaping.
+(call/cc (lambda (k) 5))  5
+(+ 1 (call/cc (lambda (k) (+ 10 (k 2)))))  3
+(call-with-current-continuation (lambda (k) (k (quote out))))  out
+
+; A continuation takes any number of values, as values does.
+(call-with-values (lambda () (call/cc (lambda (k) (k 1 2)))) list)  (1 2)
+(call-with-values (lambda () (call/cc (lambda (k) (k)))) list)  ()
+(call-with-values (lambda () (+ 1 (call/cc (lambda (k) (k 1 2))))) list)  ***
+
+; Re-entering a continuation captured by an earlier top-level form.
+(define saved #f)  ---
+(define result (+ 100 (call/cc (lambda (k) (begin (set! saved k) 0)))))  ---
+result  100
+(saved 1)  ---
+result  101
+(saved 5)  ---
+result  105
+
+; Backtracking, which resumes each choice point's continuation several times.
+(define choices-left null)  ---
+(define (amb choices)
+  (if (null? choices)
+      (fail)
+      (call/cc
+        (lambda (k)
+          (begin
+            (set! choices-left
+                  (cons (lambda () (k (amb (cdr choices)))) choices-left))
+            (car choices))))))  ---
+(define (fail)
+  (if (null? choices-left)
+      (quote no-more-choices)
+      ((lambda (retry) (begin (set! choices-left (cdr choices-left)) (retry)))
+       (car choices-left))))  ---
+(define (require ok) (if ok #t (fail)))  ---
+((lambda (x)
+   ((lambda (y) (begin (require (equal? (+ x y) 7)) (cons x y)))
+    (amb (quote (1 2 3 4)))))
+ (amb (quote (1 2 3 4 5))))  (3 . 4)
+
+; A generator, which passes control back and forth between two loops.
+(define gen-return #f)  ---
+(define gen-resume #f)  ---
+(define (walk items)
+  (if (null? items)
+      (gen-return (quote done))
+      (begin
+        (call/cc (lambda (resume) (begin (set! gen-resume resume) (gen-return (car items)))))
+        (walk (cdr items)))))  ---
+(define (gen-next)
+  (call/cc
+    (lambda (return)
+      (begin
+        (set! gen-return return)
+        (if (equal? gen-resume #f) (walk (quote (a b c))) (gen-resume #f))))))  ---
+(gen-next)  a
+(gen-next)  b
+(cons (gen-next) (gen-next))  (c . done)
+
+; dynamic-wind calls before, thunk and after, and returns thunk's value.
+(define trail null)  ---
+(define (note x) (set! trail (cons x trail)))  ---
+(dynamic-wind (lambda () (note 1)) (lambda () (begin (note 2) (quote value))) (lambda () (note 3)))  value
+trail  (3 2 1)
+
+; Escaping from the thunk runs after thunks, innermost first.
+(set! trail null)  ---
+(call/cc
+  (lambda (k)
+    (dynamic-wind
+      (lambda () (note (quote outer-in)))
+      (lambda ()
+        (dynamic-wind
+          (lambda () (note (quote inner-in)))
+          (lambda () (k (quote escaped)))
+          (lambda () (note (quote inner-out)))))
+      (lambda () (note (quote outer-out))))))  escaped
+trail  (outer-out inner-out inner-in outer-in)
+
+; Re-entering the thunk runs the before thunk again.
+(set! trail null)  ---
+(define reenter #f)  ---
+(dynamic-wind
+  (lambda () (note (quote in)))
+  (lambda () (call/cc (lambda (k) (begin (set! reenter k) 0))))
+  (lambda () (note (quote out))))  0
+(reenter 1)  1
+trail  (out in out in)
+
+; Jumping from one dynamic extent into another runs the thunks in between.
+(set! trail null)  ---
+(define inside-a #f)  ---
+(dynamic-wind
+  (lambda () (note (quote a-in)))
+  (lambda () (call/cc (lambda (k) (begin (set! inside-a k) (quote first)))))
+  (lambda () (note (quote a-out))))  first
+(dynamic-wind
+  (lambda () (note (quote b-in)))
+  (lambda () (inside-a (quote second)))
+  (lambda () (note (quote b-out))))  second
+trail  (a-out a-in b-out b-in a-out a-in)
@@ -0,0 +1,65 @@
+; Characters.  Run with
+;   LiSP -test test/chars_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; Literals, including R7RS's names and hex form.
+(char->integer #\alarm)  7
+(char->integer #\delete)  127
+(char->integer #\escape)  27
+(char->integer #\null)  0
+#\x41  #\A
+#\x3bb  #\λ
+(integer->char 7)  #\alarm
+(integer->char 32)  #\space
+
+(char? #\a)  #t
+(char? "a")  #f
+(char->integer #\A)  65
+(char->integer "A")  ***
+(integer->char 955)  #\λ
+(integer->char -1)  ***
+(integer->char 55296)  ***
+(integer->char 1114112)  ***
+
+; Case, for any script.
+(char-upcase #\a)  #\A
+(char-upcase #\λ)  #\Λ
+(char-downcase #\Σ)  #\σ
+(char-foldcase #\Σ)  #\σ
+(char-foldcase #\ς)  #\σ
+(char-upcase #\1)  #\1
+
+; Classification.
+(char-alphabetic? #\a)  #t
+(char-alphabetic? #\λ)  #t
+(char-alphabetic? #\1)  #f
+(char-numeric? #\7)  #t
+(char-numeric? #\x663)  #t
+(char-numeric? #\a)  #f
+(char-whitespace? #\space)  #t
+(char-whitespace? #\tab)  #t
+(char-whitespace? #\x3000)  #t
+(char-whitespace? #\a)  #f
+(char-upper-case? #\A)  #t
+(char-upper-case? #\a)  #f
+(char-lower-case? #\a)  #t
+(char-alphabetic? 1)  ***
+
+; digit-value knows the digits of every script.
+(digit-value #\3)  3
+(digit-value #\x664)  4
+(digit-value #\x1d7d9)  1
+(digit-value #\a)  #f
+
+; Comparisons, which take any number of chars.
+(char=? #\a #\a #\a)  #t
+(char=? #\a #\b)  #f
+(char<? #\a #\b #\c)  #t
+(char<? #\a #\c #\b)  #f
+(char>? #\b #\a)  #t
+(char<=? #\a #\a #\b)  #t
+(char>=? #\a #\b)  #f
+(char-ci=? #\a #\A)  #t
+(char-ci<? #\a #\B)  #t
+(char<? #\a #\B)  #f
+(char=? #\a "a")  ***
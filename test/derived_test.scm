@@ -0,0 +1,139 @@
+; The derived expressions.  Run with
+;   LiSP -test test/derived_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; if without an alternative
+(if #t 1)  1
+(if #f 1)  ---
+(if)  ***
+(if 1 2 3 4)  ***
+
+; let, let*, letrec, letrec* and named let
+(let ((x 1) (y 2)) (+ x y))  3
+(let () 5)  5
+(define x 10)  ---
+(let ((x 1) (y x)) y)  10
+(let* ((x 1) (y x)) y)  1
+(let* () 7)  7
+(letrec ((even? (lambda (n) (if (= n 0) #t (odd? (- n 1)))))
+         (odd? (lambda (n) (if (= n 0) #f (even? (- n 1))))))
+  (even? 100))  #t
+(letrec* ((a 1) (b (+ a 1))) (list a b))  (1 2)
+(letrec ((a b) (b 1)) a)  ***
+(let loop ((i 0) (acc '())) (if (= i 3) acc (loop (+ i 1) (cons i acc))))  (2 1 0)
+(let loop ((i 0)) (if (< i 100000) (loop (+ i 1)) i))  100000
+(let ((x 1) y) x)  ***
+(let ((if list)) (if 1 2 3))  (1 2 3)
+
+; cond and case
+(cond (#f 1) ((+ 1 1) => (lambda (x) (* x 10))) (else 3))  20
+(cond (#f 1) (else 2 3))  3
+(cond ((assq-like 1) 2))  ***
+(define (classify n)
+  (case n
+    ((0) 'zero)
+    ((1 2 3) 'small)
+    ((a b) => (lambda (s) (list s s)))
+    (else => (lambda (n) (list 'big n)))))  ---
+(classify 0)  zero
+(classify 2)  small
+(classify 'b)  (b b)
+(classify 42)  (big 42)
+(case #\a ((#\a) 1) (else 2))  1
+(case (* 2 3) ((2 3 5 7) 'prime) ((1 4 6 8 9) 'composite))  composite
+(case 1 (else 1) ((1) 2))  ***
+
+; and, or, when, unless
+(and)  #t
+(and 1 2 3)  3
+(and 1 #f 3)  #f
+(or)  #f
+(or #f 2 (car '()))  2
+(let ((x 5)) (or #f x))  5
+(let ((n 0)) (or (begin (set! n (+ n 1)) #f) n))  1
+(when (> 1 0) 'a 'b)  b
+(unless (> 1 0) 'a 'b)  ---
+(unless #f 'a 'b)  b
+
+; do
+(do ((i 0 (+ i 1)) (acc '() (cons i acc))) ((= i 4) acc))  (3 2 1 0)
+(let ((v (make-vector 3)))
+  (do ((i 0 (+ i 1))) ((= i 3) v) (vector-set! v i (* i i))))  #(0 1 4)
+
+; case-lambda
+(define area
+  (case-lambda
+    ((r) (* 3 r r))
+    ((w h) (* w h))
+    ((a b . rest) (list a b rest))))  ---
+(area 2)  12
+(area 2 5)  10
+(area 1 2 3 4)  (1 2 (3 4))
+(area)  ***
+(define pick (case-lambda ((a) 'one) ((a b c) 'three)))  ---
+(pick 1)  one
+(pick 1 2 3)  three
+(pick 1 2)  ***
+(pick)  ***
+(case-lambda)  ***
+(let ((f (case-lambda ((x) (list x)) ((x . r) r)))) (list (f 1) (f 1 2) (apply f '(3 4 5))))  ((1) (2) (4 5))
+
+; values, let-values and define-values
+(call-with-values (lambda () (values 1 2)) cons)  (1 . 2)
+(call-with-values (lambda () 5) list)  (5)
+(let-values (((a b) (values 1 2)) ((c) (values 3))) (list a b c))  (1 2 3)
+(let-values (((a . rest) (values 1 2 3)) (all (values 4 5))) (list a rest all))  (1 (2 3) (4 5))
+(let ((a 1)) (let-values (((a) (values 2)) ((b) (values a))) (list a b)))  (2 1)
+(let ((a 1)) (let*-values (((a) (values 2)) ((b) (values a))) (list a b)))  (2 2)
+(define-values (q r) (values 7 8))  ---
+(list q r)  (7 8)
+(define-values (h . t) (values 1 2 3))  ---
+(list h t)  (1 (2 3))
+
+; parameterize
+(define radix (make-parameter 10))  ---
+(radix)  10
+(parameterize ((radix 2)) (radix))  2
+(radix)  10
+(define p (make-parameter 10 (lambda (x) (* x 2))))  ---
+(p)  20
+(parameterize ((p 3)) (p))  6
+(p)  20
+(parameterize ((radix 16)) (guard (e (#t (radix))) (raise 'oops)))  16
+(guard (e (#t (radix))) (parameterize ((radix 16)) (raise 'oops)))  10
+(radix)  10
+(parameterize ((car 1)) 2)  ***
+
+; delay, delay-force, force and make-promise
+(define count 0)  ---
+(define pr (delay (begin (set! count (+ count 1)) count)))  ---
+(promise? pr)  #t
+(force pr)  1
+(force pr)  1
+(force 5)  5
+(force (make-promise 6))  6
+(promise? (make-promise 6))  #t
+(define (stream-drop n)
+  (delay-force (if (= n 0) (delay 'done) (stream-drop (- n 1)))))  ---
+(force (stream-drop 100000))  done
+(force (delay (delay 1)))  ---
+(promise? (force (delay (delay 1))))  #t
+(define self #f)  ---
+(define once (delay (if self 'outer (begin (set! self #t) (force once)))))  ---
+(force once)  outer
+
+; cond-expand chooses the body of the first clause whose features are there.
+(cond-expand (r7rs 'yes) (else 'no))  yes
+(cond-expand ((and r7rs (not no-such-feature)) 1) (else 2))  1
+(cond-expand ((or no-such-feature ratios) 1))  1
+(cond-expand ((library (scheme base)) 1) (else 2))  2
+(cond-expand (no-such-feature 1))  ---
+(cond-expand (else 1) (r7rs 2))  ***
+(cond-expand ((nand r7rs) 1))  ***
+(cond-expand (r7rs (define expanded 'top)))  ---
+expanded  top
+(define (expand-body)
+  (cond-expand (full-unicode (define x 1) (define y 2)))
+  (+ x y))  ---
+(expand-body)  3
+(car (features))  r7rs
@@ -0,0 +1,21 @@
+; Dotted pairs and improper lists in the reader.
+(quote (a . b))  (a . b)
+(car (quote (a . b)))  a
+(cdr (quote (a . b)))  b
+(quote (x y . rest))  (x y . rest)
+(cdr (cdr (quote (x y . rest))))  rest
+(quote (a . (b c)))  (a b c)
+(quote (a . ()))  (a)
+(cons 1 2)  (1 . 2)
+(cons 1 (cons 2 3))  (1 2 . 3)
+
+; Rest parameters collect the arguments that follow the fixed ones.
+(define (f a b . rest) rest)  ---
+(f 1 2)  ()
+(f 1 2 3 4)  (3 4)
+((lambda (a . rest) (cons a rest)) 1 2 3)  (1 2 3)
+((lambda (a . rest) rest) 1)  ()
+((lambda z z) 1 2)  (1 2)
+(define (g . all) all)  ---
+(g)  ()
+(g 1 (quote x))  (1 x)
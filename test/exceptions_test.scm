@@ -0,0 +1,75 @@
+; raise and guard.
+(guard (e (#t (list 'caught e))) (raise 'oops))  (caught oops)
+(guard (e ((eq? e 1) 'one) ((eq? e 42) 'forty-two)) (raise 42))  forty-two
+(guard (e ((eq? e 'x) 'x)) 5)  5
+(guard (e ((car e) => cdr)) (raise (list (cons 'a 42))))  42
+(guard (e ((cdr e))) (raise (cons 1 2)))  2
+(guard (e ((eq? e 'x) 'x) (else (list 'else e))) (raise 'y))  (else y)
+(guard (e ((eq? e 'x) 'x)) (raise 'y))  ***
+(raise 'boom)  ***
+
+; A condition that no clause accepts is re-raised to the next handler.
+(guard (outer (#t (list 'outer outer)))
+  (guard (inner ((eq? inner 'x) 'inner))
+    (raise 'y)))  (outer y)
+
+; Guard variables are hygienic.
+(define e 'global-e)  ---
+(guard (x (#t e)) (raise 'local))  global-e
+(define-syntax try
+  (syntax-rules ()
+    ((_ body) (guard (e (#t (list 'failed e))) body))))  ---
+(try (raise e))  (failed global-e)
+
+; with-exception-handler and raise-continuable.
+(with-exception-handler
+  (lambda (c) 42)
+  (lambda () (+ (raise-continuable 'c) 1)))  43
+(with-exception-handler
+  (lambda (c) 10)
+  (lambda ()
+    (guard (e ((eq? e 'no) 1))
+      (+ 1 (raise-continuable 'c)))))  11
+(with-exception-handler
+  (lambda (c) 0)
+  (lambda () (raise 'non-continuable)))  ***
+(guard (e ((error-object? e) (error-object-irritants e)))
+  (with-exception-handler
+    (lambda (c) 0)
+    (lambda () (raise 'non-continuable))))  (non-continuable)
+
+; A handler runs with the outer handlers in effect.
+(with-exception-handler
+  (lambda (c) (list 'outer c))
+  (lambda ()
+    (with-exception-handler
+      (lambda (c) (raise-continuable (list 'inner c)))
+      (lambda () (raise-continuable 'c)))))  (outer (inner c))
+
+; Error objects.
+(guard (e ((error-object? e)
+           (list (error-object-message e) (error-object-irritants e))))
+  (error "Something bad:" 42 'foo))  ("Something bad:" (42 foo))
+(error-object? 'oops)  #f
+(error-object-message 'oops)  ***
+(error 'not-a-string)  ***
+(error "Something bad:" 42 'foo)  ***
+
+; Primitives that fail raise error objects.
+(guard (e ((error-object? e) (error-object-message e))) (car '()))  "car: expected a pair, got ()"
+(guard (e ((error-object? e) 'caught)) (+ 1 'a))  caught
+(guard (e ((error-object? e) 'caught)) (undefined-variable))  caught
+(guard (e ((error-object? e) 'caught)) ((lambda (x y) x) 1))  caught
+(guard (e ((error-object? e) 'caught)) (/ 1 0))  caught
+
+; Leaving a guard runs the after thunks of dynamic-winds.
+(define trail '())  ---
+(guard (e (#t (cons 'handled trail)))
+  (dynamic-wind
+    (lambda () (set! trail (cons 'in trail)))
+    (lambda () (raise 'x))
+    (lambda () (set! trail (cons 'out trail)))))  (handled out in)
+
+; Errors abandon any handlers still in effect.
+(with-exception-handler (lambda (c) 0) (lambda () (car '())))  ***
+(raise 'boom)  ***
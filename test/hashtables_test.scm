@@ -0,0 +1,95 @@
+; Hash tables.  Run with
+;   LiSP -test test/hashtables_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; By default, keys are compared with equal?, so strings and lists work.
+(define t (make-hash-table))  ---
+(hash-table? t)  #t
+(hash-table? (list))  #f
+(hash-table-set! t "one" 1)  ---
+(hash-table-set! t '(2 two) 2)  ---
+(hash-table-set! t #\3 3)  ---
+(hash-table-set! t 4.5 'four)  ---
+(hash-table-set! t 'five 5)  ---
+(hash-table-ref t (string #\o #\n #\e))  1
+(hash-table-ref t (list 2 'two))  2
+(hash-table-ref t #\3)  3
+(hash-table-ref t 4.5)  four
+(hash-table-ref t 'five)  5
+(hash-table-ref t 'six)  ***
+(hash-table-ref t 'six (lambda () 'none))  none
+(hash-table-ref t 'five (lambda () 'none) (lambda (v) (* v 10)))  50
+(hash-table-ref/default t 'six 6)  6
+(hash-table-count t)  5
+(hash-table-exists? t "one")  #t
+
+; Entries stay in the order they were added.
+(hash-table-keys t)  ("one" (2 two) #\3 4.5 five)
+(hash-table-values t)  (1 2 3 four 5)
+(hash-table-set! t "one" 'uno)  ---
+(hash-table-delete! t '(2 two))  ---
+(hash-table-delete! t 'absent)  ---
+(hash-table->alist t)  (("one" . uno) (#\3 . 3) (4.5 . four) (five . 5))
+(hash-table-count t)  4
+
+; Updating.
+(define counts (make-hash-table eq?))  ---
+(define (count-all words)
+  (if (pair? words)
+      (begin
+        (hash-table-update!/default counts (car words) (lambda (n) (+ n 1)) 0)
+        (count-all (cdr words)))
+      (quote done)))  ---
+(count-all '(a b a c a b))  ---
+(hash-table->alist counts)  ((a . 3) (b . 2) (c . 1))
+(hash-table-update! counts 'a (lambda (n) (* n 100)))  ---
+(hash-table-ref counts 'a)  300
+(hash-table-update! counts 'z (lambda (n) n))  ***
+(hash-table-update! counts 'z (lambda (n) (+ n 1)) (lambda () 0))  ---
+(hash-table-ref counts 'z)  1
+
+; Walking and folding.
+(define total 0)  ---
+(hash-table-walk counts (lambda (k v) (set! total (+ total v))))  ---
+total  304
+(hash-table-fold counts (lambda (k v acc) (cons k acc)) null)  (z c b a)
+
+; eq? tables compare strings by identity, eqv? tables compare numbers by value.
+(define s "key")  ---
+(define q (make-hash-table eq?))  ---
+(hash-table-set! q s 'found)  ---
+(hash-table-ref/default q s 'missing)  found
+(hash-table-ref/default q (string-copy s) 'missing)  missing
+(define v (make-hash-table eqv?))  ---
+(hash-table-set! v 12345678901234567890 'big)  ---
+(hash-table-set! v 1/3 'third)  ---
+(hash-table-ref v (* 1234567890123456789 10))  big
+(hash-table-ref v (/ 2 6))  third
+(hash-table-ref/default v 1.0 'missing)  missing
+
+; Tables keyed by string-ci=?, or by a custom equivalence and hash function.
+(define ci (make-hash-table string-ci=?))  ---
+(hash-table-set! ci "Hello" 1)  ---
+(hash-table-ref ci "HELLO")  1
+(define mod10 (make-hash-table (lambda (a b) (= (modulo a 10) (modulo b 10)))
+                               (lambda (n) (modulo n 10))))  ---
+(hash-table-set! mod10 13 'three)  ---
+(hash-table-ref mod10 23)  three
+(hash-table-set! (make-hash-table equal? (lambda (x) -1)) 1 2)  ***
+
+; Copying, merging and association lists.
+(define a (alist->hash-table '((x . 1) (y . 2) (x . 3))))  ---
+(hash-table->alist a)  ((x . 1) (y . 2))
+(define b (hash-table-copy a))  ---
+(hash-table-set! b 'z 26)  ---
+(list (hash-table-count a) (hash-table-count b))  (2 3)
+(hash-table->alist (hash-table-merge! a (alist->hash-table '((y . 0) (w . 4)))))  ((x . 1) (y . 2) (w . 4))
+
+; Hash functions agree with their equivalences.
+(= (hash "abc") (hash (string-copy "abc")))  #t
+(= (hash '(1 #(2 "3"))) (hash (list 1 (vector 2 "3"))))  #t
+(= (string-ci-hash "ABC") (string-ci-hash "abc"))  #t
+(< (hash 'anything 10) 10)  #t
+(hash 'x 0)  ***
+(string-hash 'x)  ***
+(hash-table-ref 'not-a-table 1)  ***
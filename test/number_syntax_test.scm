@@ -0,0 +1,65 @@
+; Radix and exactness prefixes.
+#x1F  31
+#X-ff  -255
+#b1010  10
+#o17  15
+#d10  10
+#e1.5  3/2
+#i3/4  0.75
+#x#e10  16
+#e#x10  16
+#i#b101  5.0
+#e1e-3  1/1000
+#x1/A  1/10
+
+; Infinities and NaNs are inexact.
++inf.0  +inf.0
+-inf.0  -inf.0
+(nan? +nan.0)  #t
+(nan? -nan.0)  #t
+1e400  +inf.0
+
+; Go's number syntax is not Scheme's.
+'0x1F  0x1F
+'1_000  1_000
+'inf  inf
+'-  -
+
+; Complex numbers.
++i  0+1i
+-i  0-1i
+1+2i  1+2i
+1-2.5i  1.0-2.5i
+3/4+1/2i  3/4+1/2i
+(* +i +i)  -1
+(+ 1+2i 1-2i)  2
+(- 1+2i 1+2i)  0
+(/ 1+2i 3+4i)  11/25+2/25i
+(/ 1.0+2i 2)  0.5+1.0i
+(exact? 1+2i)  #t
+(inexact? 1.0+2i)  #t
+(real? 1+2i)  #f
+(complex? 1)  #t
+(real-part 1+2i)  1
+(imag-part 1+2i)  2
+(imag-part 1.5)  0
+(make-rectangular 1 0)  1
+(make-rectangular 1 2)  1+2i
+(make-polar 2 0)  2
+1@0  1
+(magnitude 3+4i)  5
+(magnitude -5)  5
+(angle -1.0)  3.141592653589793
+(= 1+2i 1.0+2.0i)  #t
+(eqv? 1+2i 1.0+2.0i)  #f
+(zero? 0.0+0.0i)  #t
+(< 1 +i)  ***
+(exact 1.5+2.5i)  3/2+5/2i
+(inexact 1/2+1/4i)  0.5+0.25i
+
+; Some functions of real numbers have complex values.
+(sqrt -4)  0+2i
+(sqrt -4.0)  0.0+2.0i
+(expt +i 2)  -1
+(expt 1+i 10)  0+32i
+(log -1)  0.0+3.141592653589793i
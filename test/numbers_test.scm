@@ -0,0 +1,98 @@
+; Exact and inexact numbers.
+(+ 1/10 2/10)  3/10
+(= (+ 1/10 2/10) 3/10)  #t
+(exact? 3/10)  #t
+(inexact? 0.1)  #t
+(exact->inexact 1/3)  0.3333333333333333
+(inexact 1/4)  0.25
+(exact 0.5)  1/2
+(exact 2.0)  2
+(inexact->exact 0.25)  1/4
+(exact (/ 0.0 0.0))  ***
+2.0  2.0
+-0.0  -0.0
+1e7  10000000.0
+
+; Exact integers grow into bignums, and shrink back again.
+(- 9223372036854775807 -1)  9223372036854775808
+(+ -9223372036854775808 -1)  -9223372036854775809
+(* 4294967296 4294967296)  18446744073709551616
+(- (* 4294967296 4294967296) 18446744073709551615)  1
+(expt 2 100)  1267650600228229401496703205376
+(quotient (expt 10 30) (expt 10 28))  100
+123456789012345678901234567890  123456789012345678901234567890
+(exact-integer? (expt 2 100))  #t
+
+; Rationals are kept in lowest terms, and integers are integers.
+6/4  3/2
+-2/4  -1/2
+(/ 1 3)  1/3
+(/ 6 3)  2
+(* 1/2 4)  2
+(integer? (* 1/2 4))  #t
+(numerator 6/4)  3
+(denominator 6/4)  2
+(/ 1 0)  ***
+(/ 4)  1/4
+(- 5)  -5
+(- 0.0)  -0.0
+(eqv? (- 0.0) -0.0)  #t
+(- -2.5)  2.5
+(- 1/2)  -1/2
+
+; Mixed arithmetic is inexact if any argument is.
+(+ 1/2 0.5)  1.0
+(* 2 1.5)  3.0
+(max 1 2.0)  2.0
+(max 1 2)  2
+(min 1/2 1/3)  1/3
+
+; Comparisons compare values, whatever their representation.
+(= 1 1.0 2/2)  #t
+(< 1 3/2 2 2.5)  #t
+(< 1 3/2 3/2)  #f
+(>= 3 3.0 -1/2)  #t
+(eqv? 1 1.0)  #f
+(eqv? (expt 2 100) (expt 2 100))  #t
+(equal? '(1/2 2) (list 2/4 (/ 4 2)))  #t
+(< 1 'a)  ***
+(= 9007199254740993 9007199254740992.0)  #f
+(< 9007199254740992.0 9007199254740993)  #t
+(> 9007199254740993 9007199254740992.0)  #t
+(= 9007199254740992 9007199254740992.0)  #t
+(= 1/3 0.3333333333333333)  #f
+(< 1/3 0.3333333333333333)  #f
+(= 1/2 0.5)  #t
+(< (expt 10 400) +inf.0)  #t
+(> (expt 10 400) -inf.0)  #t
+(= 1 +nan.0)  #f
+
+; Integer division.
+(quotient -7 2)  -3
+(remainder -7 2)  -1
+(modulo -7 2)  1
+(modulo 7 -2)  -1
+(modulo 7.0 2)  1.0
+(quotient 5 (expt 2 100))  0
+(modulo -5 (expt 2 100))  1267650600228229401496703205371
+(quotient -9223372036854775808 -1)  9223372036854775808
+(gcd 12 18)  6
+(lcm 4 6)  12
+(odd? (+ (expt 2 100) 1))  #t
+
+; Rounding.
+(round 5/2)  2
+(round 7/2)  4
+(round 2.5)  2.0
+(floor -7/2)  -4
+(ceiling -7/2)  -3
+(truncate -7/2)  -3
+(floor 2.7)  2.0
+
+; Roots and powers.
+(sqrt 16)  4
+(sqrt 1/4)  1/2
+(sqrt 2)  1.4142135623730951
+(expt 2/3 -2)  9/4
+(expt 2.0 3)  8.0
+(expt 0 -1)  ***
@@ -0,0 +1,52 @@
+; Each expression below is followed by its expected value.  Run with
+;   LiSP -test test/pairs_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; cons shares its cdr rather than copying it.
+(define tail (cons 2 null))  ---
+(define whole (cons 1 tail))  ---
+(eq? (cdr whole) tail)  #t
+(set-car! tail 20)  ---
+whole  (1 20)
+(eq? (cons 1 null) (cons 1 null))  #f
+
+; Pairs need not form proper lists.
+(pair? (cons 1 2))  #t
+(car (cons 1 2))  1
+(cdr (cons 1 2))  2
+(pair? null)  #f
+(null? null)  #t
+(null? (cdr tail))  #t
+(car null)  ***
+(cdr 5)  ***
+(set-car! null 1)  ***
+
+(equal? (cons 1 (cons 2 null)) (quote (1 2)))  #t
+(equal? (cons 1 2) (cons 1 3))  #f
+(equal? (cons (cons 1 2) 3) (cons (cons 1 2) 3))  #t
+
+; An association list updated in place.
+(define alist (cons (cons (quote a) 1) (cons (cons (quote b) 2) null)))  ---
+(set-cdr! (car (cdr alist)) 200)  ---
+(cdr (car (cdr alist)))  200
+
+; A queue is a pair of pointers to the first and last cells of a list.
+(define queue (cons null null))  ---
+(define (enqueue! q x) ((lambda (cell) (begin (if (null? (car q)) (set-car! q cell) (set-cdr! (cdr q) cell)) (set-cdr! q cell))) (cons x null)))  ---
+(enqueue! queue 1)  ---
+(enqueue! queue 2)  ---
+(enqueue! queue 3)  ---
+(car queue)  (1 2 3)
+(cdr queue)  (3)
+
+; equal? finishes on circular structure, and procedures that need a proper
+; list fail on one instead of running forever.
+(equal? '#0=(1 . #0#) '#1=(1 . #1#))  #t
+(equal? '#0=(1 . #0#) '#1=(1 1 . #1#))  #t
+(equal? '#0=(1 . #0#) '#1=(1 2 . #1#))  #f
+(equal? '#0=#(1 #0#) '#1=#(1 #1#))  #t
+(equal? '#0=(#0# . #0#) '#1=(#1# . #1#))  #t
+(define ring (list 1 2 3))  ---
+(set-cdr! (cdr (cdr ring)) ring)  ---
+(apply + ring)  ***
+(append ring '())  ***
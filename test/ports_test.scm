@@ -0,0 +1,75 @@
+; Ports.  Run with
+;   LiSP -test test/ports_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; String input ports.
+(define in (open-input-string "(a b) 42 λ\nsecond line\nlast"))  ---
+(port? in)  #t
+(input-port? in)  #t
+(output-port? in)  #f
+(read in)  (a b)
+(read in)  42
+(read-char in)  #\space
+(peek-char in)  #\λ
+(read-char in)  #\λ
+(read-line in)  ""
+(read-line in)  "second line"
+(read-string 2 in)  "la"
+(read-string 10 in)  "st"
+(eof-object? (read-char in))  #t
+(eof-object? (peek-char in))  #t
+(eof-object? (read-line in))  #t
+(eof-object? (read in))  #t
+(eof-object? (eof-object))  #t
+(eof-object? 'eof)  #f
+(eof-object? (string->symbol "#%eof"))  #f
+(read (open-input-string "(unfinished"))  ***
+(define stray (open-input-string ") 5"))  ---
+(read stray)  ***
+(read stray)  5
+
+; String output ports.
+(define out (open-output-string))  ---
+(output-port? out)  #t
+(write-char #\x out)  ---
+(write-string "yz" out)  ---
+(write-string "abcdef" out 2 4)  ---
+(newline out)  ---
+(write "q\"uote" out)  ---
+(display "q\"uote" out)  ---
+(display (list 1 #\a "b") out)  ---
+(get-output-string out)  "xyzcd\n\"q\\\"uote\"q\"uote(1 a b)"
+(get-output-string in)  ***
+(write-char "x" out)  ***
+(write-char #\x in)  ***
+(read-char out)  ***
+
+; Closing a port.
+(input-port-open? in)  #t
+(close-port in)  ---
+(input-port-open? in)  #f
+(read-char in)  ***
+(close-output-port out)  ---
+(output-port-open? out)  #f
+(display "late" out)  ***
+
+; with-output-to-string captures what is written to the current output port.
+(with-output-to-string (lambda () (begin (display "hi ") (write 'there))))  "hi there"
+(with-output-to-string
+  (lambda () (begin (display 1) (display (with-output-to-string (lambda () (display 2)))) (display 3))))  "123"
+(output-port? (current-output-port))  #t
+(input-port? (current-input-port))  #t
+(output-port? (current-error-port))  #t
+
+; The current output port is restored when an error or a continuation
+; escapes from the thunk.
+(guard (e (#t (output-port? (current-output-port))))
+  (with-output-to-string (lambda () (raise 'oops))))  #t
+(eq? (call/cc (lambda (k) (with-output-to-string (lambda () (k (current-output-port))))))
+     (current-output-port))  #f
+(define saved (current-output-port))  ---
+(call/cc (lambda (k) (with-output-to-string (lambda () (k 1)))))  1
+(eq? saved (current-output-port))  #t
+
+; Files.
+(open-input-file "no/such/file")  ***
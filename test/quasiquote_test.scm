@@ -0,0 +1,48 @@
+; Quasiquote, unquote and unquote-splicing.
+(define xs '(a b))  ---
+`(1 2)  (1 2)
+(quasiquote (1 (unquote (+ 1 1))))  (1 2)
+`(1 ,(+ 1 1) 3)  (1 2 3)
+`,(car xs)  a
+`(0 ,@xs 9)  (0 a b 9)
+`(0 ,@xs)  (0 a b)
+`(,@xs ,@xs)  (a b a b)
+`(1 ,@'() 2)  (1 2)
+`(,@xs . tail)  (a b . tail)
+`((nested ,(car xs)) ,xs)  ((nested a) (a b))
+
+; Unquoting in the tail of a list shares the unquoted value.
+`(0 . ,xs)  (0 a b)
+(eq? (cdr `(0 . ,xs)) xs)  #t
+(eq? (cdr `(0 ,@xs)) xs)  #t
+
+; A template with nothing unquoted is a constant.
+(define (constant) `(1 (2 3)))  ---
+(eq? (constant) (constant))  #t
+(define (fresh x) `(1 (2 ,x)))  ---
+(eq? (cdr (fresh 3)) (cdr (fresh 3)))  #f
+
+; Nested quasiquotes: only the innermost level of unquoting is evaluated.
+`(1 `(2 ,(3 ,(+ 1 3))))  (1 `(2 ,(3 4)))
+`(1 `(2 ,(3 ,@xs)))  (1 `(2 ,(3 a b)))
+`(1 `(2 ,,(car xs)))  (1 `(2 ,a))
+`(1 `(2 ,@(3 ,(car xs))))  (1 `(2 ,@(3 a)))
+
+; Quasiquote does not depend on the current bindings of cons or append.
+((lambda (cons append) `(1 ,@xs ,cons)) 5 6)  (1 a b 5)
+
+; Quasiquote in a macro template.
+(define-syntax show
+  (syntax-rules ()
+    ((_ e) `(e is ,e))))  ---
+(show (+ 1 2))  ((+ 1 2) is 3)
+
+,xs  ***
+(unquote-splicing xs)  ***
+`(1 ,@2 3)  ***
+`(1 ,@2)  (1 . 2)
+`(unquote 1 2)  ***
+`,@(list 1)  ***
+`(1 . ,@(list 2))  ***
+`(1 `,@,(+ 1 2))  (1 (quasiquote (unquote-splicing 3)))
+`(1 `(,@,(+ 1 2)))  (1 (quasiquote ((unquote-splicing 3))))
@@ -4,7 +4,7 @@ trap "{ rm -f ~/tmp/test-repl*; }" EXIT
 
 
 
-go install github.com/perlmonger42/LiSP
+go install github.com/perlmonger42/LiSP/cmd/LiSP
 
 
 
@@ -0,0 +1,91 @@
+; Strings.  Run with
+;   LiSP -test test/strings_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; Escapes are decoded when a string is read, and written back when it is
+; printed.
+(string-length "a\nb")  3
+(string-ref "a\x3bb;c" 1)  #\λ
+(string->list "tab\tend")  (#\t #\a #\b #\tab #\e #\n #\d)
+"say \"hi\"\\"  "say \"hi\"\\"
+
+; Indexing is by char, not by byte.
+(string-length "λx")  2
+(string-ref "λx" 1)  #\x
+(string-ref "abc" 3)  ***
+(string-ref "abc" -1)  ***
+(substring "hello world" 6 11)  "world"
+(substring "hello" 2 1)  ***
+(string-copy "hello" 1)  "ello"
+(string-copy "hello" 1 3)  "el"
+(string->list "hello" 2 4)  (#\l #\l)
+
+; Building strings.
+(make-string 3 #\z)  "zzz"
+(string-length (make-string 2))  2
+(string #\a #\b)  "ab"
+(string)  ""
+(string-append "ab" "" "cd")  "abcd"
+(list->string (list #\x #\y))  "xy"
+(list->string (list #\x 1))  ***
+
+; Strings are mutable, and each string-copy is a new string.
+(define s (make-string 3 #\-))  ---
+(string-set! s 1 #\+)  ---
+s  "-+-"
+(string-fill! s #\. 2)  ---
+s  "-+."
+(define t (string-copy s))  ---
+(string-set! t 0 #\*)  ---
+(list s t)  ("-+." "*+.")
+(string-copy! t 1 "abc" 1)  ---
+t  "*bc"
+(string-copy! t 2 "abc")  ***
+(string-set! s 3 #\x)  ***
+(eq? s t)  #f
+(equal? (string-copy "abc") "abc")  #t
+(eqv? (string-copy "abc") (string-copy "abc"))  #f
+
+; Conversions.
+(string->symbol "abc")  abc
+(symbol->string (quote abc))  "abc"
+(symbol->string "abc")  ***
+(string->number "42")  42
+(string->number "-1.5e2")  -150.0
+(string->number "1/3")  1/3
+(string->number "ff" 16)  255
+(string->number "#xff")  255
+(string->number "12abc")  #f
+(string->number "101" 2)  5
+(number->string 255)  "255"
+(number->string 255 16)  "ff"
+(number->string -10 2)  "-1010"
+(number->string 3/4 2)  "11/100"
+(number->string 1.5)  "1.5"
+(number->string 1.5 2)  ***
+(number->string "1")  ***
+
+; Case.
+(string-upcase "Hello")  "HELLO"
+(string-downcase "HeLLo")  "hello"
+(string-foldcase "ΣΑΣ")  "σασ"
+
+; Comparisons, which take any number of strings.
+(string=? "abc" "abc" "abc")  #t
+(string=? "abc" "abd")  #f
+(string<? "abc" "abd" "b")  #t
+(string<? "b" "abc")  #f
+(string>? "b" "a")  #t
+(string<=? "a" "a" "b")  #t
+(string>=? "a" "b")  #f
+(string-ci=? "AbC" "aBc")  #t
+(string-ci<? "apple" "BANANA")  #t
+(string<? "apple" "BANANA")  #f
+(string=? "a" 1)  ***
+
+; Mapping over the chars of strings.
+(string-map (lambda (c) (if (eqv? c #\a) #\A c)) "banana")  "bAnAnA"
+(string-map (lambda (a b) (if (eqv? a b) #\= #\x)) "abcd" "abz")  "==x"
+(define seen null)  ---
+(string-for-each (lambda (c) (set! seen (cons c seen))) "abc")  ---
+seen  (#\c #\b #\a)
@@ -0,0 +1,46 @@
+; Quoted symbols and case folding.  Run with
+;   LiSP -test test/symbols_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+(define (show writer x) (with-output-to-string (lambda () (writer x))))  ---
+
+; Bars and backslashes quote the characters of a symbol's name; they are not
+; part of it.
+(symbol->string '|a b|)  "a b"
+(symbol->string 'a\ b)  "a b"
+(symbol->string 'ab|cd ef|gh)  "abcd efgh"
+(symbol->string '||)  ""
+(symbol->string '|\x41;\|\\\t|)  "A|\\\t"
+(symbol->string '|(|)  "("
+(symbol->string '\1)  "1"
+(eq? 'abc '|abc|)  #t
+(eq? 'abc 'a\bc)  #t
+(eq? '|1| (string->symbol "1"))  #t
+(string->symbol "a b")  |a b|
+
+; write quotes a name that would not read back as the same symbol; display
+; does not.
+(show write 'abc)  "abc"
+(show write (string->symbol "a b"))  "|a b|"
+(show write (string->symbol "1"))  "|1|"
+(show write (string->symbol "+i"))  "|+i|"
+(show write (string->symbol "."))  "|.|"
+(show write (string->symbol ""))  "||"
+(show write (string->symbol "#x"))  "|#x|"
+(show write (string->symbol "a|b\\c"))  "|a\\|b\\\\c|"
+(show write (string->symbol "x\ny"))  "|x\\xa;y|"
+(show write (list 'a (string->symbol "b c") "d e"))  "(a |b c| \"d e\")"
+(show display (string->symbol "a b"))  "a b"
+(show write '...)  "..."
+(show write '#%void)  "#%void"
+
+; #!fold-case folds the case of symbols and char names that follow it, until
+; #!no-fold-case; quoted parts of a symbol are not folded.
+(eq? 'ABC 'abc)  #f
+#!fold-case
+(eq? 'ABC 'abc)  #t
+(symbol->string 'Hello|World|)  "helloWorld"
+#\SPACE  #\space
+"ABC"  "ABC"
+#!no-fold-case
+(eq? 'ABC 'abc)  #f
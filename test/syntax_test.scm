@@ -0,0 +1,150 @@
+; Macros: define-syntax, let-syntax, letrec-syntax and syntax-rules.
+
+(define-syntax swap!
+  (syntax-rules ()
+    ((_ a b) ((lambda (tmp) (begin (set! a b) (set! b tmp))) a))))  ---
+(define x 1)  ---
+(define y 2)  ---
+(swap! x y)  ---
+(cons x y)  (2 . 1)
+
+; The macro's tmp does not capture the user's tmp.
+(define tmp 10)  ---
+(define other 20)  ---
+(swap! tmp other)  ---
+(cons tmp other)  (20 . 10)
+
+; Ellipses, and recursive macros. (For my-or to work, if must count every
+; value other than #f as true.)
+(if 0 (quote yes) (quote no))  yes
+(if null (quote yes) (quote no))  yes
+
+(define-syntax my-or
+  (syntax-rules ()
+    ((_) #f)
+    ((_ e) e)
+    ((_ e rest ...) ((lambda (t) (if t t (my-or rest ...))) e))))  ---
+(my-or)  #f
+(my-or #f #f 3)  3
+(define t 5)  ---
+(my-or #f t)  5
+
+(define-syntax my-let
+  (syntax-rules ()
+    ((_ ((name val) ...) body) ((lambda (name ...) body) val ...))))  ---
+(my-let ((a 1) (b 2)) (+ a b))  3
+(my-let () 7)  7
+
+; Nested ellipses, and patterns after an ellipsis or in a dotted tail.
+(define-syntax flatten
+  (syntax-rules ()
+    ((_ (a b ...) ...) (quote (a ... b ... ...)))))  ---
+(flatten (1 2 3) (4 5) (6))  (1 4 6 2 3 5)
+(define-syntax last
+  (syntax-rules ()
+    ((_ a ... b) (quote b))))  ---
+(last 1 2 3)  3
+(last 4)  4
+(define-syntax tail-of
+  (syntax-rules ()
+    ((_ a . b) (quote b))))  ---
+(tail-of 1 2 3)  (2 3)
+
+; Literals match only themselves, and not a local variable of the same name.
+(define-syntax arrow
+  (syntax-rules (=>)
+    ((_ a => b) (cons a b))
+    ((_ a b c) (quote no-arrow))))  ---
+(arrow 1 => 2)  (1 . 2)
+(arrow 1 2 3)  no-arrow
+((lambda (=>) (arrow 1 => 2)) 0)  no-arrow
+(arrow 1)  ***
+
+; A free identifier in a template means what it meant where the macro was
+; defined, even if the macro is used where that name is rebound.
+(define-syntax first-of
+  (syntax-rules ()
+    ((_ l) (car l))))  ---
+((lambda (car) (first-of (quote (1 2)))) cdr)  1
+((lambda (x)
+   (let-syntax ((get-x (syntax-rules () ((_) x))))
+     ((lambda (x) (get-x)) 2)))
+ 1)  1
+
+; Macros can be shadowed by variables.
+((lambda (my-or) (my-or (quote (5 6)))) car)  5
+my-or  ***
+
+(let-syntax ((twice (syntax-rules () ((_ e) (cons e e)))))
+  (twice 1))  (1 . 1)
+(let-syntax ((ev? (syntax-rules () ((_) #t) ((_ x . r) (od? . r))))
+             (od? (syntax-rules () ((_) #f) ((_ x . r) (ev? . r)))))
+  (ev? 1 2))  ***
+(letrec-syntax ((ev? (syntax-rules () ((_) #t) ((_ x . r) (od? . r))))
+                (od? (syntax-rules () ((_) #f) ((_ x . r) (ev? . r)))))
+  (cons (ev? 1 2 3 4) (od? 1 2 3 4)))  (#t . #f)
+
+; A macro that defines a macro, escaping its ellipses with (... ...).
+(define-syntax define-lister
+  (syntax-rules ()
+    ((_ name) (define-syntax name (syntax-rules () ((_ args (... ...)) (quote (args (... ...)))))))))  ---
+(define-lister lst)  ---
+(lst 1 2 3)  (1 2 3)
+
+; A custom ellipsis.
+(define-syntax rev-pair
+  (syntax-rules ::: ()
+    ((_ (a b) :::) (quote ((b . a) :::)))))  ---
+(rev-pair (1 2) (3 4))  ((2 . 1) (4 . 3))
+
+; A macro that defines a global.
+(define-syntax define-constant
+  (syntax-rules ()
+    ((_ name value) (define name value))))  ---
+(define-constant answer 42)  ---
+answer  42
+
+; A variable may be bound only once by the same form, but a macro's own
+; variable is distinct from one of the same name at its point of use.
+(lambda (x x) x)  ***
+(lambda (x . x) x)  ***
+(define (twice-bound a a) a)  ***
+(let ((a 1) (a 2)) a)  ***
+(letrec ((a 1) (a 2)) a)  ***
+(let-values (((a b) (values 1 2)) ((a) (values 3))) a)  ***
+(define-syntax with-temp
+  (syntax-rules ()
+    ((_ v e) ((lambda (tmp v) e) 1 2))))  ---
+(with-temp tmp tmp)  2
+
+; Vector patterns and templates, with and without ellipses.
+(define-syntax vec-swap
+  (syntax-rules ()
+    ((_ #(a b)) (quote #(b a)))))  ---
+(vec-swap #(1 2))  #(2 1)
+(vec-swap (1 2))  ***
+(vec-swap #(1 2 3))  ***
+(define-syntax vec-rest
+  (syntax-rules ()
+    ((_ #(first rest ... last)) (list 'first 'last #(rest ...)))))  ---
+(vec-rest #(1 2 3 4))  (1 4 #(2 3))
+(vec-rest #(1 2))  (1 2 #())
+(vec-rest #(1))  ***
+(define-syntax vec-pairs
+  (syntax-rules ()
+    ((_ #((k v) ...)) (quote #((v . k) ...)))))  ---
+(vec-pairs #((a 1) (b 2)))  #((1 . a) (2 . b))
+(define-syntax vec-of
+  (syntax-rules ()
+    ((_ x) (let ((tmp x)) #(tmp x)))))  ---
+(vec-of 5)  #(tmp 5)
+
+; syntax-error reports an error as soon as it is expanded.
+(define-syntax must-be-pair
+  (syntax-rules ()
+    ((_ (a . b)) (quote (a . b)))
+    ((_ x) (syntax-error "must-be-pair: not a pair" x))))  ---
+(must-be-pair (1 . 2))  (1 . 2)
+(must-be-pair 3)  ***
+(define (never-called) (must-be-pair 3))  ***
+(syntax-error 'no-message)  ***
@@ -0,0 +1,89 @@
+; Vectors and bytevectors.  Run with
+;   LiSP -test test/vectors_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+; Vector literals evaluate to themselves, and print as they are read.
+#(1 "two" #\3 (4 5))  #(1 "two" #\3 (4 5))
+#()  #()
+(vector? #(1))  #t
+(vector? (list 1))  #f
+(vector 'a (+ 1 2))  #(a 3)
+(vector-length #(a b c))  3
+(vector-ref #(a b c) 1)  b
+(vector-ref #(a b c) 3)  ***
+(vector-ref (list 1) 0)  ***
+(make-vector 2 'x)  #(x x)
+(vector-length (make-vector 3))  3
+(make-vector -1)  ***
+
+; Vectors are mutable.
+(define v (vector 1 2 3 4 5))  ---
+(vector-set! v 0 'one)  ---
+v  #(one 2 3 4 5)
+(vector-fill! v 0 3)  ---
+v  #(one 2 3 0 0)
+(vector-copy! v 3 #(x y z) 1)  ---
+v  #(one 2 3 y z)
+(vector-copy! v 4 #(x y))  ***
+(vector-set! v 5 'six)  ***
+(define w (vector-copy v))  ---
+(vector-set! w 0 1)  ---
+(list v w)  (#(one 2 3 y z) #(1 2 3 y z))
+(vector-copy v 1 3)  #(2 3)
+
+; Equality.
+(equal? (vector 1 "a" #(2)) #(1 "a" #(2)))  #t
+(equal? #(1 2) #(1 2 3))  #f
+(eqv? (vector) (vector 1))  #f
+(eq? v v)  #t
+
+; Conversions.
+(vector->list #(a b c))  (a b c)
+(vector->list #(a b c) 1)  (b c)
+(vector->list #(a b c) 1 2)  (b)
+(list->vector '(1 2))  #(1 2)
+(list->vector 5)  ***
+(vector->string #(#\a #\b))  "ab"
+(vector->string #(1))  ***
+(string->vector "abc" 1)  #(#\b #\c)
+(vector-append #(1) #() #(2 3))  #(1 2 3)
+
+; Mapping.
+(vector-map + #(1 2 3) #(10 20))  #(11 22)
+(define seen null)  ---
+(vector-for-each (lambda (x) (set! seen (cons x seen))) #(a b c))  ---
+seen  (c b a)
+
+; Quasiquote builds vectors, too.
+(define x 42)  ---
+`#(1 ,x)  #(1 42)
+`#(a ,@(list 1 2) b)  #(a 1 2 b)
+`(1 #(,x))  (1 #(42))
+`#(1 2)  #(1 2)
+
+; Bytevectors hold exact integers from 0 to 255.
+#u8(1 2 255)  #u8(1 2 255)
+(bytevector? #u8())  #t
+(bytevector? #(1))  #f
+(bytevector 1 2)  #u8(1 2)
+(bytevector 256)  ***
+(make-bytevector 3 7)  #u8(7 7 7)
+(make-bytevector 2 -1)  ***
+(bytevector-length #u8(1 2 3))  3
+(bytevector-u8-ref #u8(5 6) 1)  6
+(bytevector-u8-ref #u8(5 6) 2)  ***
+(define b (make-bytevector 4 0))  ---
+(bytevector-u8-set! b 1 200)  ---
+b  #u8(0 200 0 0)
+(bytevector-copy! b 2 #u8(1 2 3))  ***
+(bytevector-copy! b 2 #u8(1 2 3) 1)  ---
+b  #u8(0 200 2 3)
+(bytevector-copy b 1 3)  #u8(200 2)
+(bytevector-append #u8(1) #u8(2 3))  #u8(1 2 3)
+(equal? (bytevector 1 2) #u8(1 2))  #t
+
+; Bytevectors and UTF-8.
+(string->utf8 "λx")  #u8(206 187 120)
+(utf8->string #u8(206 187 120))  "λx"
+(utf8->string #u8(65 66 67) 1)  "BC"
+(string->utf8 "abc" 1 2)  #u8(98)
@@ -0,0 +1,57 @@
+; Writing shared and cyclic structure.  Run with
+;   LiSP -test test/write_test.scm
+; where *** means an error is expected and --- means the value is unimportant.
+
+(define (show writer x) (with-output-to-string (lambda () (writer x))))  ---
+
+; Without sharing, the write procedures agree, and display differs only in
+; strings and chars.
+(define plain (list 1 "two" #\3 (vector 'four "5")))  ---
+(show write plain)  "(1 \"two\" #\\3 #(four \"5\"))"
+(show write-shared plain)  "(1 \"two\" #\\3 #(four \"5\"))"
+(show write-simple plain)  "(1 \"two\" #\\3 #(four \"5\"))"
+(show display plain)  "(1 two 3 #(four 5))"
+
+; write-shared labels every pair or vector that appears more than once;
+; write and write-simple do not.
+(define s (list 'a))  ---
+(define dag (list s s (vector s)))  ---
+(show write-shared dag)  "(#0=(a) #0# #(#0#))"
+(show write dag)  "((a) (a) #((a)))"
+(show write-simple dag)  "((a) (a) #((a)))"
+
+; write and display label cycles, so that they finish.
+(define c (list 1 2 3))  ---
+(set-cdr! (cdr (cdr c)) c)  ---
+(show write c)  "#0=(1 2 3 . #0#)"
+(show write-shared c)  "#0=(1 2 3 . #0#)"
+(show display c)  "#0=(1 2 3 . #0#)"
+(define v (vector "x" #\y 0))  ---
+(vector-set! v 2 v)  ---
+(show write v)  "#0=#(\"x\" #\\y #0#)"
+(show display v)  "#0=#(x y #0#)"
+(define inner (list 'b))  ---
+(set-car! inner inner)  ---
+(show write (list 'a inner inner))  "(a #0=(#0#) #0#)"
+
+; The reader accepts datum labels, so what is written reads back.
+(show write '#0=(x . #0#))  "#0=(x . #0#)"
+(show write '#5=#(1 #5#))  "#0=#(1 #0#)"
+(show write-shared '(#1=(p) #1# #2="q" #2#))  "(#0=(p) #0# \"q\" \"q\")"
+(define x (read (open-input-string "#0=(a #0# . #0#)")))  ---
+(list (eq? x (car (cdr x))) (eq? x (cdr (cdr x))))  (#t #t)
+(define round-trip (read (open-input-string (show write c))))  ---
+(car (cdr (cdr (cdr round-trip))))  1
+(eq? round-trip (cdr (cdr (cdr round-trip))))  #t
+(read (open-input-string "#0#"))  ***
+(read (open-input-string "(#0=a #0=b)"))  ***
+(read (open-input-string "#0=#0#"))  ***
+
+; A procedure prints as the lambda expression it was made from, as it was
+; written, whatever expansion made of it.
+(show write (lambda (x . rest) `(,x ,@rest)))  "(lambda (x . rest) (quasiquote ((unquote x) (unquote-splicing rest))))"
+(define (shadowing x) (lambda (x) (let ((y x)) y)))  ---
+(show write (shadowing 1))  "(lambda (x) (let ((y x)) y))"
+(define (named a b) a)  ---
+(show write named)  "(lambda (a b) a)"
+(show write (case-lambda ((a) a) ((a b) (+ a b))))  "(case-lambda ((a) a) ((a b) (+ a b)))"
@@ -0,0 +1,236 @@
+package lisp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ Locations and backtraces
+
+ The reader records where each list that it reads as code begins, and
+ expansion passes a form's location on to the form it expands into. Each
+ node gets the location of the innermost list it was analyzed from, and each
+ code block a table of the locations of its code, so that a machine always
+ knows where the expression it is evaluating came from, and each activation
+ where its procedure was called from.
+
+ An error that nothing handles is reported with the location at which it was
+ raised, and a backtrace: the procedures whose calls are in progress,
+ innermost first, each with the place it was called from.
+*/
+
+// A location is a place in a source file.
+type location struct {
+	file      string
+	line, col int
+}
+
+func (l *location) String() string {
+	return fmt.Sprintf("%s:%d:%d", l.file, l.line, l.col)
+}
+
+// locations holds where each list that was read as code, or expanded from
+// such a list, begins, until its top-level form has been translated (see
+// forget).
+var locations = map[*pair]*location{}
+
+// expanded lists the lists that expansion has given locations to, since the
+// top-level form being translated was read.
+var expanded []*pair
+
+// forget removes the locations of the lists of datum, a top-level form that
+// has been translated, and of the forms expanded from it, with the sources of
+// its lambda expressions. The nodes and code blocks made from it hold what
+// they need.
+func forget(datum scmer) {
+	for _, p := range expanded {
+		delete(locations, p)
+	}
+	expanded, sources = nil, map[*pair]scmer{}
+	seen := map[interface{}]bool{} // datum may be circular
+	var walk func(x scmer)
+	walk = func(x scmer) {
+		for {
+			switch y := x.(type) {
+			case *pair:
+				if seen[y] {
+					return
+				}
+				seen[y] = true
+				delete(locations, y)
+				walk(y.car)
+				x = y.cdr
+				continue
+			case *vector:
+				if !seen[y] {
+					seen[y] = true
+					for _, item := range y.items {
+						walk(item)
+					}
+				}
+			}
+			return
+		}
+	}
+	walk(datum)
+}
+
+// locationOf returns the location of the list x, or nil if it has none.
+func locationOf(x scmer) *location {
+	if p, ok := x.(*pair); ok {
+		return locations[p]
+	}
+	return nil
+}
+
+// translating is the location of the innermost list being expanded or
+// analyzed. It is left as it is if either fails, to say where.
+var translating *location
+
+// locateTranslationError, when deferred, reports where a failure to expand or
+// analyze an expression happened, in the error.
+func locateTranslationError() {
+	if r := recover(); r != nil {
+		if c, ok := r.(*errorObject); ok && c.trace == nil {
+			c.trace = &backtrace{where: translating}
+		}
+		panic(r)
+	}
+}
+
+// A line records that the code of a block from pc on was compiled from an
+// expression at loc.
+type line struct {
+	pc  int
+	loc *location
+}
+
+// locate returns the location of the code just before pc, or nil if it has
+// none.
+func (b *codeBlock) locate(pc int) *location {
+	i := sort.Search(len(b.lines), func(i int) bool { return b.lines[i].pc >= pc })
+	if i == 0 {
+		return nil
+	}
+	return b.lines[i-1].loc
+}
+
+// where returns the location of what m is evaluating or executing, or nil if
+// it is not known.
+func (m *machine) where() *location {
+	if m.mode == executing {
+		return m.block.locate(m.pc)
+	}
+	return m.loc
+}
+
+// A backtrace says where an error was raised, and which calls were then in
+// progress.
+type backtrace struct {
+	where *location
+	calls []call // innermost first
+}
+
+// A call is one line of a backtrace, which stands for a number of calls in a
+// row that are the same, as those of a recursion are.
+type call struct {
+	text  string
+	times int
+}
+
+// maxBacktrace is the most lines a backtrace has.
+const maxBacktrace = 20
+
+// backtrace returns the backtrace of the machines that are running, of which m
+// is the innermost.
+func (m *machine) backtrace() *backtrace {
+	t := &backtrace{where: m.where()}
+	var last *activation
+	more := 0
+	add := func(en *activation) {
+		if en == nil || en == last || en.proc == nil || hidden(en.proc) {
+			return
+		}
+		last = en
+		text := "in " + procedureName(en.proc)
+		if en.site != nil {
+			text += ", called at " + en.site.String()
+		}
+		switch n := len(t.calls); {
+		case n > 0 && t.calls[n-1].text == text:
+			t.calls[n-1].times++
+		case n == maxBacktrace:
+			more++
+		default:
+			t.calls = append(t.calls, call{text, 1})
+		}
+	}
+	for i := len(machines) - 1; i >= 0; i-- {
+		add(machines[i].en)
+		for k := machines[i].k; k != nil; k = k.below() {
+			switch f := k.(type) {
+			case *ifFrame:
+				add(f.en)
+			case *assignFrame:
+				add(f.en)
+			case *beginFrame:
+				add(f.en)
+			case *argFrame:
+				add(f.en)
+			case *vmFrame:
+				add(f.en)
+			}
+		}
+	}
+	if more > 0 {
+		t.calls = append(t.calls, call{fmt.Sprintf("and %d more", more), 1})
+	}
+	return t
+}
+
+// hidden reports whether the compound procedure p was made by an expansion,
+// rather than written, and so is left out of backtraces.
+func hidden(p scmer) bool {
+	switch p := p.(type) {
+	case *proc:
+		return p.lambda.hidden
+	case *closure:
+		return p.block.hidden
+	}
+	return false
+}
+
+// procedureName returns the name of a compound procedure, for a backtrace.
+func procedureName(p scmer) string {
+	switch p := p.(type) {
+	case *proc:
+		return procName(p.lambda.name, p.lambda.params)
+	case *closure:
+		return procName(p.block.name, p.block.params)
+	}
+	return p.String()
+}
+
+// annotate returns the message of an error, prefixed by where it was raised
+// and followed by the calls in progress, one to a line.
+func (t *backtrace) annotate(message string) string {
+	if t == nil {
+		return message
+	}
+	var b strings.Builder
+	if t.where != nil {
+		b.WriteString(t.where.String())
+		b.WriteString(": ")
+	}
+	b.WriteString(message)
+	for _, c := range t.calls {
+		b.WriteString("\n  ")
+		b.WriteString(c.text)
+		if c.times > 1 {
+			fmt.Fprintf(&b, " (%d times)", c.times)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,277 @@
+package lisp
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+ Vectors and bytevectors
+
+ A vector is a mutable sequence of values, indexed from 0, and a bytevector
+ is a mutable sequence of bytes. Like strings, two of them are eqv? only if
+ they are the same object; equal? compares their contents.
+*/
+
+type vector struct {
+	items []scmer
+}
+
+type bytevector struct {
+	bytes []byte
+}
+
+// String returns x as a vector literal, which reads back as x.
+func (x *vector) String() string {
+	return printValue(x, labelCycles, false)
+}
+
+// String returns x as a bytevector literal, which reads back as x.
+func (x *bytevector) String() string {
+	var b strings.Builder
+	b.WriteString("#u8(")
+	for i, n := range x.bytes {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.Itoa(int(n)))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// equal reports whether x and y hold the same bytes.
+func (x *bytevector) equal(y *bytevector) bool {
+	return string(x.bytes) == string(y.bytes)
+}
+
+// asVector returns x as a vector, or fails on behalf of the named procedure.
+func asVector(who string, x scmer) *vector {
+	v, ok := x.(*vector)
+	if !ok {
+		Fail("%s: expected a vector, got %s", who, x)
+	}
+	return v
+}
+
+// asBytevector returns x as a bytevector, or fails on behalf of the named
+// procedure.
+func asBytevector(who string, x scmer) *bytevector {
+	v, ok := x.(*bytevector)
+	if !ok {
+		Fail("%s: expected a bytevector, got %s", who, x)
+	}
+	return v
+}
+
+// asByte returns x as a byte, or fails on behalf of the named procedure.
+func asByte(who string, x scmer) byte {
+	n, ok := x.(fixnum)
+	if !ok || n < 0 || n > 255 {
+		Fail("%s: expected an exact integer from 0 to 255, got %s", who, x)
+	}
+	return byte(n)
+}
+
+// asLength returns x as the length of a new vector, bytevector or string, or
+// fails on behalf of the named procedure.
+func asLength(who string, x scmer) int {
+	k, ok := x.(fixnum)
+	if !ok || k < 0 {
+		Fail("%s: expected a length, got %s", who, x)
+	}
+	return int(k)
+}
+
+// listToVector implements (list->vector list).
+func listToVector(a ...scmer) scmer {
+	items, ok := listToSlice(a[0])
+	if !ok {
+		Fail("list->vector: expected a list, got %s", a[0])
+	}
+	return &vector{items}
+}
+
+// mapVectors calls f with the items at each index of the vectors a, up to the
+// length of the shortest, and returns the results.
+func mapVectors(who string, f scmer, a []scmer) []scmer {
+	vectors := make([]*vector, len(a))
+	n := -1
+	for i, x := range a {
+		vectors[i] = asVector(who, x)
+		if n < 0 || len(vectors[i].items) < n {
+			n = len(vectors[i].items)
+		}
+	}
+	results := make([]scmer, n)
+	for i := range results {
+		args := make([]scmer, len(vectors))
+		for j, v := range vectors {
+			args[j] = v.items[i]
+		}
+		results[i] = apply(f, args)
+	}
+	return results
+}
+
+var vectorPrimitives = map[string]primitiveDef{
+	"vector?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*vector)
+		return boolean(ok)
+	}},
+	"make-vector": {arity{1, 2}, func(a ...scmer) scmer {
+		items := make([]scmer, asLength("make-vector", a[0]))
+		var fill scmer = boolean(false)
+		if len(a) > 1 {
+			fill = a[1]
+		}
+		for i := range items {
+			items[i] = fill
+		}
+		return &vector{items}
+	}},
+	"vector": {arity{0, variadic}, func(a ...scmer) scmer {
+		return &vector{append([]scmer(nil), a...)}
+	}},
+	"vector-length": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(len(asVector("vector-length", a[0]).items))
+	}},
+	"vector-ref": {arity{2, 2}, func(a ...scmer) scmer {
+		v := asVector("vector-ref", a[0])
+		return v.items[asIndex("vector-ref", a[1], len(v.items)-1)]
+	}},
+	"vector-set!": {arity{3, 3}, func(a ...scmer) scmer {
+		v := asVector("vector-set!", a[0])
+		v.items[asIndex("vector-set!", a[1], len(v.items)-1)] = a[2]
+		return symbol("#%set!")
+	}},
+	"vector->list": {arity{1, 3}, func(a ...scmer) scmer {
+		v := asVector("vector->list", a[0])
+		start, end := span("vector->list", a, 1, len(v.items))
+		return makeList(v.items[start:end]...)
+	}},
+	"list->vector": {arity{1, 1}, listToVector},
+	"vector->string": {arity{1, 3}, func(a ...scmer) scmer {
+		v := asVector("vector->string", a[0])
+		start, end := span("vector->string", a, 1, len(v.items))
+		runes := make([]rune, end-start)
+		for i := range runes {
+			runes[i] = asChar("vector->string", v.items[start+i])
+		}
+		return &str{runes}
+	}},
+	"string->vector": {arity{1, 3}, func(a ...scmer) scmer {
+		s := asStr("string->vector", a[0])
+		start, end := span("string->vector", a, 1, len(s.runes))
+		items := make([]scmer, end-start)
+		for i := range items {
+			items[i] = char(s.runes[start+i])
+		}
+		return &vector{items}
+	}},
+	"vector-copy": {arity{1, 3}, func(a ...scmer) scmer {
+		v := asVector("vector-copy", a[0])
+		start, end := span("vector-copy", a, 1, len(v.items))
+		return &vector{append([]scmer(nil), v.items[start:end]...)}
+	}},
+	"vector-copy!": {arity{3, 5}, func(a ...scmer) scmer {
+		to := asVector("vector-copy!", a[0])
+		at := asIndex("vector-copy!", a[1], len(to.items))
+		from := asVector("vector-copy!", a[2])
+		start, end := span("vector-copy!", a, 3, len(from.items))
+		if at+end-start > len(to.items) {
+			Fail("vector-copy!: %d items do not fit at index %d of %s", end-start, at, to)
+		}
+		copy(to.items[at:], from.items[start:end])
+		return symbol("#%set!")
+	}},
+	"vector-append": {arity{0, variadic}, func(a ...scmer) scmer {
+		var items []scmer
+		for _, x := range a {
+			items = append(items, asVector("vector-append", x).items...)
+		}
+		return &vector{items}
+	}},
+	"vector-fill!": {arity{2, 4}, func(a ...scmer) scmer {
+		v := asVector("vector-fill!", a[0])
+		start, end := span("vector-fill!", a, 2, len(v.items))
+		for i := start; i < end; i++ {
+			v.items[i] = a[1]
+		}
+		return symbol("#%set!")
+	}},
+	"vector-map": {arity{2, variadic}, func(a ...scmer) scmer {
+		return &vector{mapVectors("vector-map", a[0], a[1:])}
+	}},
+	"vector-for-each": {arity{2, variadic}, func(a ...scmer) scmer {
+		mapVectors("vector-for-each", a[0], a[1:])
+		return symbol("#%void")
+	}},
+
+	"bytevector?": {arity{1, 1}, func(a ...scmer) scmer {
+		_, ok := a[0].(*bytevector)
+		return boolean(ok)
+	}},
+	"make-bytevector": {arity{1, 2}, func(a ...scmer) scmer {
+		bytes := make([]byte, asLength("make-bytevector", a[0]))
+		if len(a) > 1 {
+			fill := asByte("make-bytevector", a[1])
+			for i := range bytes {
+				bytes[i] = fill
+			}
+		}
+		return &bytevector{bytes}
+	}},
+	"bytevector": {arity{0, variadic}, func(a ...scmer) scmer {
+		bytes := make([]byte, len(a))
+		for i, x := range a {
+			bytes[i] = asByte("bytevector", x)
+		}
+		return &bytevector{bytes}
+	}},
+	"bytevector-length": {arity{1, 1}, func(a ...scmer) scmer {
+		return fixnum(len(asBytevector("bytevector-length", a[0]).bytes))
+	}},
+	"bytevector-u8-ref": {arity{2, 2}, func(a ...scmer) scmer {
+		v := asBytevector("bytevector-u8-ref", a[0])
+		return fixnum(v.bytes[asIndex("bytevector-u8-ref", a[1], len(v.bytes)-1)])
+	}},
+	"bytevector-u8-set!": {arity{3, 3}, func(a ...scmer) scmer {
+		v := asBytevector("bytevector-u8-set!", a[0])
+		v.bytes[asIndex("bytevector-u8-set!", a[1], len(v.bytes)-1)] = asByte("bytevector-u8-set!", a[2])
+		return symbol("#%set!")
+	}},
+	"bytevector-copy": {arity{1, 3}, func(a ...scmer) scmer {
+		v := asBytevector("bytevector-copy", a[0])
+		start, end := span("bytevector-copy", a, 1, len(v.bytes))
+		return &bytevector{append([]byte(nil), v.bytes[start:end]...)}
+	}},
+	"bytevector-copy!": {arity{3, 5}, func(a ...scmer) scmer {
+		to := asBytevector("bytevector-copy!", a[0])
+		at := asIndex("bytevector-copy!", a[1], len(to.bytes))
+		from := asBytevector("bytevector-copy!", a[2])
+		start, end := span("bytevector-copy!", a, 3, len(from.bytes))
+		if at+end-start > len(to.bytes) {
+			Fail("bytevector-copy!: %d bytes do not fit at index %d of %s", end-start, at, to)
+		}
+		copy(to.bytes[at:], from.bytes[start:end])
+		return symbol("#%set!")
+	}},
+	"bytevector-append": {arity{0, variadic}, func(a ...scmer) scmer {
+		var bytes []byte
+		for _, x := range a {
+			bytes = append(bytes, asBytevector("bytevector-append", x).bytes...)
+		}
+		return &bytevector{bytes}
+	}},
+	"utf8->string": {arity{1, 3}, func(a ...scmer) scmer {
+		v := asBytevector("utf8->string", a[0])
+		start, end := span("utf8->string", a, 1, len(v.bytes))
+		return makeStr(string(v.bytes[start:end]))
+	}},
+	"string->utf8": {arity{1, 3}, func(a ...scmer) scmer {
+		s := asStr("string->utf8", a[0])
+		start, end := span("string->utf8", a, 1, len(s.runes))
+		return &bytevector{[]byte(string(s.runes[start:end]))}
+	}},
+}
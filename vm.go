@@ -0,0 +1,187 @@
+package lisp
+
+import "encoding/binary"
+
+/*
+ Virtual machine
+
+ A machine executes a codeBlock (see compile.go) one instruction at a time,
+ with its registers held in local variables, until the block returns a value
+ or applies a procedure that is not a primitive. A non-tail call pushes a
+ vmFrame, which resumes the caller when the callee returns; since it is a
+ frame like any other, call/cc, dynamic-wind and the exception handlers work
+ the same for bytecode as for the interpreter.
+*/
+
+// A closure is a procedure compiled to bytecode.
+type closure struct {
+	block *codeBlock
+	en    *activation
+}
+
+func (x *closure) String() string {
+	return x.block.written.String()
+}
+
+// executeBlock runs the top-level code block b, and returns its value.
+func executeBlock(b *codeBlock) scmer {
+	m := newMachine()
+	m.mode, m.block, m.pc = executing, b, 0
+	m.en, m.stack = nil, make([]scmer, 0, b.maxStack)
+	return m.run()
+}
+
+// operand decodes the operand at code[pc], and returns it with the offset of
+// the byte after it.
+func operand(code []byte, pc int) (int, int) {
+	if x := code[pc]; x < 0x80 {
+		return int(x), pc + 1
+	}
+	x, n := binary.Uvarint(code[pc:])
+	return int(x), pc + n
+}
+
+// execute executes m.block from m.pc, until it returns or applies something
+// other than a primitive or a closure. m.pc is brought up to date only when
+// the location of the code is needed.
+func (m *machine) execute() {
+	b, code, pc, en, stack := m.block, m.block.code, m.pc, m.en, m.stack
+	for {
+		op := code[pc]
+		pc++
+		switch op {
+		case opConst:
+			var k int
+			k, pc = operand(code, pc)
+			m.value = b.constants[k]
+		case opLocal0:
+			var i int
+			i, pc = operand(code, pc)
+			if m.value = en.values[i]; m.value == nil {
+				m.pc = pc
+				Fail("variable used before it is initialized: %s", b.names[i])
+			}
+		case opLocal:
+			var d, i int
+			d, pc = operand(code, pc)
+			i, pc = operand(code, pc)
+			r, outer := en, b
+			for ; d > 0; d-- {
+				r, outer = r.outer, outer.outer
+			}
+			if m.value = r.values[i]; m.value == nil {
+				m.pc = pc
+				Fail("variable used before it is initialized: %s", outer.names[i])
+			}
+		case opGlobal:
+			var g int
+			g, pc = operand(code, pc)
+			if m.value = b.globals[g].value; m.value == nil {
+				m.pc = pc
+				Fail("undefined symbol: %s", b.globals[g].name)
+			}
+		case opSetLocal:
+			var d, i int
+			d, pc = operand(code, pc)
+			i, pc = operand(code, pc)
+			r := en
+			for ; d > 0; d-- {
+				r = r.outer
+			}
+			r.values[i] = m.value
+		case opSetGlobal:
+			var g int
+			g, pc = operand(code, pc)
+			if b.globals[g].value == nil {
+				m.pc = pc
+				Fail("undefined symbol: %s", b.globals[g].name)
+			}
+			b.globals[g].value = m.value
+		case opDefineGlobal:
+			var g int
+			g, pc = operand(code, pc)
+			b.globals[g].value = m.value
+		case opJump:
+			var n int
+			n, pc = operand(code, pc)
+			pc += n
+		case opJumpFalse:
+			var n int
+			n, pc = operand(code, pc)
+			if m.value == boolean(false) {
+				pc += n
+			}
+		case opClosure:
+			var i int
+			i, pc = operand(code, pc)
+			m.value = &closure{b.blocks[i], en}
+		case opPush:
+			stack = append(stack, m.value)
+		case opCall, opTailCall:
+			var n int
+			n, pc = operand(code, pc)
+			top := len(stack) - n
+			f, room := stack[top-1], 0
+			// The machine's pc tells where the call is, if it fails or
+			// the procedure is compound.
+			m.pc = pc
+			if p, ok := f.(*primitive); ok {
+				// A primitive is given its arguments where they lie.
+				m.value = p.call(stack[top:])
+				stack = stack[:top-1]
+				if op == opTailCall {
+					m.ret(m.value)
+					return
+				}
+				continue
+			}
+			if c, ok := f.(*closure); ok {
+				// Leave room after the arguments for the callee's stack.
+				room = c.block.maxStack
+			}
+			args := make([]scmer, n, n+room)
+			copy(args, stack[top:])
+			stack = stack[:top-1]
+			if op == opCall {
+				m.push(&vmFrame{b, pc, en, stack, false, m.k})
+			}
+			m.apply(f, args)
+			if m.mode != executing {
+				return
+			}
+			b, code, pc, en, stack = m.block, m.block.code, m.pc, m.en, m.stack
+		case opReturn:
+			m.ret(m.value)
+			return
+		default:
+			Fail("vm: bad opcode %d at %d", op, pc-1)
+		}
+	}
+}
+
+// vmFrame resumes the execution of a code block once a procedure it called
+// returns.
+type vmFrame struct {
+	block   *codeBlock
+	pc      int
+	en      *activation
+	stack   []scmer
+	resumed bool // true once the frame has been resumed
+	next    frame
+}
+
+func (f *vmFrame) resume(m *machine) {
+	m.k = f.next
+	// The caller stopped pushing onto its stack when it made the call, so
+	// the first resumption can carry on with it. Any later one (through a
+	// continuation) must copy it, so as not to clobber a value pushed since.
+	stack := f.stack
+	if f.resumed {
+		stack = make([]scmer, len(f.stack), f.block.maxStack)
+		copy(stack, f.stack)
+	}
+	f.resumed = true
+	m.mode, m.block, m.pc, m.en, m.stack = executing, f.block, f.pc, f.en, stack
+}
+
+func (f *vmFrame) below() frame { return f.next }